@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/ngoyal88/relay/pkg/ai"
 	"github.com/ngoyal88/relay/pkg/api"
 	"github.com/ngoyal88/relay/pkg/cache"
 	"github.com/ngoyal88/relay/pkg/config"
 	"github.com/ngoyal88/relay/pkg/keymanager"
 	"github.com/ngoyal88/relay/pkg/middleware"
-	"github.com/ngoyal88/relay/pkg/proxy"
 	"github.com/ngoyal88/relay/pkg/storage"
+	"github.com/ngoyal88/relay/pkg/upstreams"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -39,100 +46,188 @@ func main() {
 
 	// 3. Initialize Storage (for request logging)
 	var store storage.Store
-	if cfg.Logging.Enabled && rdb != nil {
+	if cfg.Logging.Enabled {
 		retentionDays := cfg.Logging.RetentionDays
 		if retentionDays == 0 {
 			retentionDays = 30
 		}
-		store = storage.NewRedisStore(rdb, time.Duration(retentionDays)*24*time.Hour)
-		fmt.Println("✅ Request logging enabled")
-	}
+		retention := time.Duration(retentionDays) * 24 * time.Hour
 
-	var km *keymanager.Manager
-	if rdb != nil {
-		km = keymanager.New(rdb)
-	}
-
-	// 4. Create Proxy or Load Balancer
-	var handler http.Handler
+		var sinks []storage.LogSink
+		switch {
+		case cfg.Logging.Backend == "file":
+			fileStore, err := newFileStore(cfg.Logging.File, retention)
+			if err != nil {
+				log.Fatalf("Failed to open file log store: %v", err)
+			}
+			store = fileStore
+		case rdb != nil:
+			redisStore := storage.NewRedisStore(rdb, retention)
+			compressor, err := newCompressor(cfg.Logging.Compression, "log")
+			if err != nil {
+				log.Fatalf("Invalid logging.compression config: %v", err)
+			}
+			redisStore.EnableCompression(compressor)
+			store = redisStore
+			if cfg.Logging.File.Enabled {
+				fileStore, err := newFileStore(cfg.Logging.File, retention)
+				if err != nil {
+					log.Fatalf("Failed to open file log sink: %v", err)
+				}
+				sinks = append(sinks, fileStore)
+				fmt.Printf("✅ Request logs also written to %s\n", cfg.Logging.File.Dir)
+			}
+		}
 
-	if cfg.LoadBalancer.Enabled && len(cfg.LoadBalancer.Targets) > 0 {
-		targets := make([]proxy.TargetConfig, 0, len(cfg.LoadBalancer.Targets))
-		for _, t := range cfg.LoadBalancer.Targets {
-			targets = append(targets, proxy.TargetConfig{URL: t.URL, Weight: t.Weight})
+		if cfg.Logging.Export.Enabled && store != nil {
+			exp := cfg.Logging.Export
+			sink, err := storage.NewS3Sink(storage.S3SinkOptions{
+				Endpoint:      exp.Endpoint,
+				Bucket:        exp.Bucket,
+				Region:        exp.Region,
+				AccessKey:     exp.AccessKey,
+				SecretKey:     exp.SecretKey,
+				Prefix:        exp.Prefix,
+				BatchSize:     exp.BatchSize,
+				MaxBatchBytes: exp.MaxBatchMB << 20,
+				FlushInterval: exp.FlushInterval,
+				SpoolDir:      exp.SpoolDir,
+				MaxRetries:    exp.MaxRetries,
+			})
+			if err != nil {
+				log.Fatalf("Failed to start log export: %v", err)
+			}
+			sinks = append(sinks, sink)
+			fmt.Printf("✅ Request logs exported to %s/%s\n", exp.Endpoint, exp.Bucket)
 		}
-		// Use load balancer with multiple targets
-		lb, err := proxy.NewLoadBalancer(targets, cfg.LoadBalancer.Strategy)
-		if err != nil {
-			log.Fatalf("Failed to create load balancer: %v", err)
+
+		if store != nil && len(sinks) > 0 {
+			store = storage.NewFanOutStore(store, sinks...)
 		}
-		handler = lb
-		fmt.Printf("✅ Load balancer started with %d targets (strategy: %s)\n",
-			len(cfg.LoadBalancer.Targets), cfg.LoadBalancer.Strategy)
-	} else {
-		// Use single target proxy
-		gw, err := proxy.New(cfg.Proxy.Target)
-		if err != nil {
-			log.Fatal("Failed to create relay:", err)
+		if store != nil {
+			fmt.Println("✅ Request logging enabled")
 		}
-		handler = gw
-		fmt.Printf("✅ Proxy started targeting: %s\n", cfg.Proxy.Target)
 	}
 
-	// 5. Chain Middleware (order matters!)
-	// Start with the inner-most handler (The Proxy/Load Balancer)
-
-	// Layer A: Request Transformation (if enabled)
-	if cfg.Transform.Enabled {
-		transformCfg := middleware.TransformConfig{
-			RemoveHeaders:     cfg.Transform.RemoveHeaders,
-			AddHeaders:        cfg.Transform.AddHeaders,
-			ReplaceHeaders:    cfg.Transform.ReplaceHeaders,
-			RequestRules:      toTransformRules(cfg.Transform.RequestRules),
-			ResponseRules:     toTransformRules(cfg.Transform.ResponseRules),
-			MaskSensitiveData: cfg.Transform.MaskSensitiveData,
-			AllowedPaths:      cfg.Transform.AllowedPaths,
-			BlockedPaths:      cfg.Transform.BlockedPaths,
-		}
-		handler = middleware.TransformMiddleware(transformCfg)(handler)
-		fmt.Println("✅ Request transformation enabled")
+	var km *keymanager.Manager
+	if rdb != nil {
+		km = keymanager.New(rdb)
 	}
 
-	// Layer B: Rate Limiter (distributed if Redis is available)
-	handler = middleware.NewRateLimiter(rdb, cfgStore)(handler)
+	// 4. Chain Middleware (order matters!)
+	// Layers A-D run per route and are rebuilt on config changes (see gateway);
+	// E-I are shared by every route.
+	deps := &chainDeps{
+		cfgStore:  cfgStore,
+		rdb:       rdb,
+		rateLimit: middleware.NewRateLimiter(rdb, cfgStore),
+	}
 	if cfg.RateLimit.Enabled {
 		fmt.Printf("✅ Rate limiting: %.1f req/s (burst: %d)\n",
 			cfg.RateLimit.RPS, cfg.RateLimit.Burst)
 	}
 
-	// Layer C: Caching (Only if Redis is connected)
-	if cfg.Redis.Enabled && rdb != nil {
-		handler = middleware.CachingMiddleware(rdb)(handler)
-		fmt.Println("✅ Response caching enabled")
+	if cfg.Cache.Semantic.Enabled && rdb != nil {
+		sem := cfg.Cache.Semantic
+		threshold := sem.Threshold
+		if threshold <= 0 {
+			threshold = 0.95
+		}
+		index := cache.NewSemanticIndex(rdb, sem.TTL, sem.MaxEntries)
+		embedder := ai.NewEmbeddingClient(sem.EmbeddingURL, sem.EmbeddingModel, sem.APIKey)
+		deps.semantic = func(cacheCfg middleware.CacheConfig) func(http.Handler) http.Handler {
+			return middleware.SemanticCacheMiddleware(index, embedder, threshold, cacheCfg)
+		}
+		fmt.Printf("✅ Semantic caching enabled (threshold: %.2f)\n", threshold)
+	}
+	if cfg.Cache.Coalesce.Distributed && rdb != nil {
+		deps.cacheLocker = cache.NewRedisLocker(rdb)
+	}
+	if deps.compressor, err = newCompressor(cfg.Cache.Compression, "cache"); err != nil {
+		log.Fatalf("Invalid cache.compression config: %v", err)
+	}
+	responseCache, err := newCacheBackend(cfg, rdb)
+	if err != nil {
+		log.Fatalf("Invalid cache config: %v", err)
+	}
+	if responseCache != nil {
+		deps.responseCache = responseCache
+		fmt.Printf("✅ Response caching enabled (backend: %s)\n", cacheBackendName(cfg, rdb))
 	}
 
-	// Layer D: Authentication (if enabled)
-	if cfg.Auth.Enabled {
+	if cfg.Idempotency.Enabled {
 		if rdb == nil {
-			log.Fatal("Authentication requires Redis to be enabled")
+			log.Fatal("Idempotency keys require Redis to be enabled")
+		}
+		deps.idempotency = middleware.IdempotencyMiddleware(rdb, middleware.IdempotencyConfig{
+			TTL:     cfg.Idempotency.TTL,
+			LockTTL: cfg.Idempotency.LockTTL,
+			MaxWait: cfg.Idempotency.MaxWait,
+			Headers: cfg.Cache.Headers,
+		})
+		fmt.Println("✅ Idempotency-Key support enabled")
+	}
+
+	// Target changes made through the admin API, shared by every instance via Redis
+	if rdb != nil {
+		deps.overrides = upstreams.New(rdb)
+		if err := deps.overrides.Start(context.Background()); err != nil {
+			log.Fatalf("Failed to load upstream overrides: %v", err)
+		}
+	}
+
+	// Proxy or Load Balancer, route table and layers A-D
+	gw, err := newGateway(deps, cfg)
+	if err != nil {
+		log.Fatalf("Failed to create upstream: %v", err)
+	}
+	if usesLoadBalancer(&cfg.LoadBalancer) {
+		strategy, targets := gw.Pools()[defaultPool].Status()
+		fmt.Printf("✅ Load balancer started with %d targets (strategy: %s)\n", len(targets), strategy)
+		if d := cfg.LoadBalancer.Discovery; d != nil {
+			fmt.Printf("✅ Target discovery enabled (%s)\n", d.Type)
 		}
-		handler = middleware.AuthMiddleware(rdb, true)(handler)
+	} else {
+		fmt.Printf("✅ Proxy started targeting: %s\n", cfg.Proxy.Target)
+	}
+	if cfg.Transform.Enabled {
+		fmt.Println("✅ Request transformation enabled")
+	}
+	if cfg.Auth.Enabled {
 		fmt.Println("✅ API key authentication enabled")
 	}
+	if len(cfg.Routes) > 0 {
+		fmt.Printf("✅ Route table enabled with %d routes\n", len(cfg.Routes))
+	}
+	var handler http.Handler = gw
 
 	// Layer E: Request/Response Logging (if enabled)
 	if cfg.Logging.Enabled && store != nil {
-		handler = middleware.RequestLoggingMiddleware(store, true)(handler)
+		policy, err := middleware.NewLogPolicy(toLogPolicyConfig(cfg.Logging.Capture))
+		if err != nil {
+			log.Fatalf("Invalid logging.capture config: %v", err)
+		}
+		handler = middleware.RequestLoggingMiddleware(store, true, policy)(handler)
 		fmt.Printf("✅ Request logging enabled (retention: %d days)\n", cfg.Logging.RetentionDays)
 	}
 
 	// Layer F: Cost Tracking (uses live pricing from config store)
 	handler = middleware.TokenCostLogger(cfgStore)(handler)
 
+	// Layer F': Model aliases (outside cost tracking and logging so they see the
+	// resolved model; read from the live config so aliases can be repointed)
+	handler = middleware.ModelAliases(cfgStore)(handler)
+
 	// Layer G: Request Logger (Outer-most - console logging)
 	handler = middleware.RequestLogger(handler)
 
-	// 6. Setup HTTP Server
+	// Layer H: Response compression. Upstream bodies arrive decoded so the layers
+	// above can inspect them; they are re-encoded here per the client's Accept-Encoding.
+	if !cfg.Server.DisableCompression {
+		handler = middleware.ResponseCompression(handler)
+	}
+
+	// 5. Setup HTTP Server
 	mux := http.NewServeMux()
 
 	// Metrics endpoint
@@ -147,6 +242,10 @@ func main() {
 	// Admin API
 	if km != nil && cfg.Auth.AdminKey != "" {
 		adminAPI := api.NewAdminAPI(km, store, cfg.Auth.AdminKey)
+		if responseCache != nil {
+			adminAPI.EnableCache(responseCache, gw.CacheConfig, handler)
+		}
+		adminAPI.EnableUpstreams(deps.overrides, gw.Pools)
 		adminAPI.RegisterRoutes(mux)
 		fmt.Println("✅ Admin API enabled at /admin/*")
 	} else if cfg.Auth.AdminKey != "" && km == nil {
@@ -156,19 +255,178 @@ func main() {
 	// Main handler
 	mux.Handle("/", handler)
 
-	// 7. Start Server
+	// 6. Start Server
 	fmt.Println("\n🚀 Relay Features Active:")
 	fmt.Println("   - Metrics:         http://localhost" + cfg.Server.Port + "/metrics")
 	fmt.Println("   - Health Check:    http://localhost" + cfg.Server.Port + "/health")
 	fmt.Println("   - Main Endpoint:   http://localhost" + cfg.Server.Port)
-	fmt.Println("\n📊 Configuration can be hot-reloaded by editing configs/config.yaml")
+	fmt.Println("\n📊 Targets, routes, transform, cache policy, rate limits and pricing hot-reload from configs/config.yaml")
 	fmt.Printf("\n🎯 Server listening on %s\n", cfg.Server.Port)
 
-	if err := http.ListenAndServe(cfg.Server.Port, mux); err != nil {
-		log.Fatal("Server failed:", err)
+	// Request IDs wrap the whole mux, so errors from the proxy layers and the
+	// admin API alike carry one
+	srv := &http.Server{Addr: cfg.Server.Port, Handler: middleware.RequestID(mux)}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Server failed:", err)
+		}
+	}()
+
+	// 7. Graceful shutdown: finish in-flight requests, then flush buffered logs
+	// (S3 batches, files) so a restart or deploy doesn't lose them
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	fmt.Println("\n🛑 Shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("⚠️  Shutdown: %v", err)
+	}
+	middleware.WaitForRequestLogs()
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("⚠️  Failed to close log store: %v", err)
+		}
+	}
+}
+
+func newFileStore(cfg config.FileLogConfig, retention time.Duration) (*storage.FileStore, error) {
+	return storage.NewFileStore(storage.FileStoreOptions{
+		Dir:         cfg.Dir,
+		MaxSize:     int64(cfg.MaxSizeMB) << 20,
+		RotateEvery: cfg.RotateEvery,
+		Compress:    cfg.Compress,
+		Retention:   retention,
+	})
+}
+
+// newCacheBackend picks the response cache store. Without an explicit backend,
+// caching uses Redis when it is connected and is off otherwise.
+func newCacheBackend(cfg *config.Config, rdb *cache.Client) (cache.Backend, error) {
+	mem := cfg.Cache.Memory
+	newLRU := func() *cache.LRU {
+		return cache.NewLRU(int64(mem.MaxSizeMB)<<20, mem.MaxEntries)
+	}
+
+	switch cacheBackendName(cfg, rdb) {
+	case "":
+		return nil, nil
+	case "redis":
+		if rdb == nil {
+			return nil, fmt.Errorf("cache.backend redis requires Redis to be enabled")
+		}
+		return cache.NewRedisBackend(rdb), nil
+	case "memory":
+		return newLRU(), nil
+	case "tiered":
+		if rdb == nil {
+			return nil, fmt.Errorf("cache.backend tiered requires Redis to be enabled")
+		}
+		tiered := cache.NewTiered(newLRU(), cache.NewRedisBackend(rdb), mem.L1TTL)
+		if err := tiered.SharePurges(context.Background(), rdb); err != nil {
+			log.Printf("⚠️  Purges will only clear this instance's L1: %v", err)
+		}
+		return tiered, nil
+	default:
+		return nil, fmt.Errorf("unknown cache.backend %q (use redis, memory or tiered)", cfg.Cache.Backend)
+	}
+}
+
+func cacheBackendName(cfg *config.Config, rdb *cache.Client) string {
+	if cfg.Cache.Backend != "" {
+		return cfg.Cache.Backend
+	}
+	if rdb != nil {
+		return "redis"
+	}
+	return ""
+}
+
+// newCompressor builds the compressor for values of one store; nil when disabled
+func newCompressor(cfg config.CompressionConfig, store string) (*cache.Compressor, error) {
+	minBytes := cfg.MinBytes
+	if minBytes <= 0 {
+		minBytes = 512
+	}
+	return cache.NewCompressor(cfg.Algorithm, minBytes, store)
+}
+
+// toCacheConfig converts the cache section; keys without a namespace get the
+// upstream's so different providers never share entries
+func toCacheConfig(cfg *config.Config, namespace string) middleware.CacheConfig {
+	out := middleware.CacheConfig{
+		Key:                  toCacheKey(cfg.Cache.Key, namespace),
+		TTL:                  cfg.Cache.TTL,
+		ModelTTLs:            cfg.Cache.ModelTTLs,
+		RequireDeterministic: cfg.Cache.RequireDeterministic,
+		Headers:              cfg.Cache.Headers,
+		Coalesce: middleware.CoalesceConfig{
+			Enabled: cfg.Cache.Coalesce.Enabled,
+			LockTTL: cfg.Cache.Coalesce.LockTTL,
+			MaxWait: cfg.Cache.Coalesce.MaxWait,
+		},
+	}
+	for _, route := range cfg.Cache.Routes {
+		r := middleware.CacheRoute{
+			Path:                 route.Path,
+			TTL:                  route.TTL,
+			RequireDeterministic: route.RequireDeterministic,
+			Disabled:             route.Disabled,
+		}
+		if route.Key != nil {
+			key := toCacheKey(*route.Key, namespace)
+			r.Key = &key
+		}
+		out.Routes = append(out.Routes, r)
+	}
+	return out
+}
+
+func toCacheKey(in config.CacheKeyConfig, namespace string) middleware.CacheKeyConfig {
+	if in.Namespace != "" {
+		namespace = in.Namespace
+	}
+	return middleware.CacheKeyConfig{
+		Namespace:     namespace,
+		IncludeTenant: in.IncludeTenant,
+		IgnoreFields:  in.IgnoreFields,
+	}
+}
+
+func toTransformConfig(in config.TransformConfig) middleware.TransformConfig {
+	return middleware.TransformConfig{
+		RemoveHeaders:     in.RemoveHeaders,
+		AddHeaders:        in.AddHeaders,
+		ReplaceHeaders:    in.ReplaceHeaders,
+		RequestRules:      toTransformRules(in.RequestRules),
+		ResponseRules:     toTransformRules(in.ResponseRules),
+		MaskSensitiveData: in.MaskSensitiveData,
+		AllowedPaths:      in.AllowedPaths,
+		BlockedPaths:      in.BlockedPaths,
 	}
 }
 
+func toLogPolicyConfig(in config.CaptureConfig) middleware.LogPolicyConfig {
+	out := middleware.LogPolicyConfig{
+		Mode:           in.Mode,
+		MaxBodyBytes:   in.MaxBodyBytes,
+		Redact:         in.Redact,
+		RedactPatterns: in.RedactPatterns,
+	}
+	for _, rule := range in.Rules {
+		out.Rules = append(out.Rules, middleware.LogPolicyRule{
+			Path:         rule.Path,
+			Model:        rule.Model,
+			APIKey:       rule.APIKey,
+			Mode:         rule.Mode,
+			MaxBodyBytes: rule.MaxBodyBytes,
+		})
+	}
+	return out
+}
+
 func toTransformRules(in []config.TransformRule) []middleware.TransformRule {
 	if len(in) == 0 {
 		return nil
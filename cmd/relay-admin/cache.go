@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// handleCache runs a cache subcommand against a running relay's admin API
+func handleCache(args []string) {
+	if len(args) < 1 {
+		cacheUsage()
+		os.Exit(1)
+	}
+
+	sub, args := args[0], args[1:]
+	fs := flag.NewFlagSet("cache "+sub, flag.ExitOnError)
+	baseURL := fs.String("url", "", "Relay base URL (default http://localhost<server.port>)")
+	adminKey := fs.String("admin-key", "", "Admin key (default auth.admin_key or $ADMIN_KEY)")
+
+	var (
+		key, model, tenant, olderThan, path, file, apiKey *string
+		all, body                                         *bool
+	)
+	switch sub {
+	case "stats":
+	case "get":
+		key = fs.String("key", "", "Cache key (the X-Cache-Key response header)")
+		body = fs.Bool("body", false, "Include the cached response body")
+	case "lookup":
+		path = fs.String("path", "/v1/chat/completions", "Request path")
+		file = fs.String("file", "-", "File with the JSON request body (- for stdin)")
+		apiKey = fs.String("api-key", "", "API key the request would use (for tenant-scoped keys)")
+		tenant = fs.String("tenant", "", "Tenant (team or user ID) instead of -api-key")
+		body = fs.Bool("body", false, "Include the cached response body")
+	case "purge":
+		key = fs.String("key", "", "Purge a single cache key")
+		model = fs.String("model", "", "Purge entries for a model")
+		tenant = fs.String("tenant", "", "Purge entries for a tenant")
+		olderThan = fs.String("older-than", "", "Purge entries older than a duration, e.g. 24h")
+		all = fs.Bool("all", false, "Purge every entry")
+	case "warm":
+		file = fs.String("file", "", "JSONL file of requests")
+		apiKey = fs.String("api-key", "", "API key for lines that don't set one")
+	default:
+		cacheUsage()
+		os.Exit(1)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		log.Fatalf("failed to parse flags: %v", err)
+	}
+	client := newAdminClient(*baseURL, *adminKey)
+
+	switch sub {
+	case "stats":
+		client.do(http.MethodGet, "/admin/cache/stats", nil)
+	case "get":
+		if *key == "" {
+			log.Fatal("-key is required")
+		}
+		q := url.Values{"key": {*key}}
+		if *body {
+			q.Set("include_body", "true")
+		}
+		client.do(http.MethodGet, "/admin/cache/entry?"+q.Encode(), nil)
+	case "lookup":
+		reqBody, err := readInput(*file)
+		if err != nil {
+			log.Fatalf("failed to read request body: %v", err)
+		}
+		payload, _ := json.Marshal(map[string]interface{}{
+			"path":         *path,
+			"body":         json.RawMessage(reqBody),
+			"api_key":      *apiKey,
+			"tenant":       *tenant,
+			"include_body": *body,
+		})
+		client.do(http.MethodPost, "/admin/cache/lookup", payload)
+	case "purge":
+		payload, _ := json.Marshal(map[string]interface{}{
+			"key":        *key,
+			"model":      *model,
+			"tenant":     *tenant,
+			"older_than": *olderThan,
+			"all":        *all,
+		})
+		client.do(http.MethodPost, "/admin/cache/purge", payload)
+	case "warm":
+		if *file == "" {
+			log.Fatal("-file is required")
+		}
+		data, err := readInput(*file)
+		if err != nil {
+			log.Fatalf("failed to read %s: %v", *file, err)
+		}
+		path := "/admin/cache/warm"
+		if *apiKey != "" {
+			path += "?" + url.Values{"api_key": {*apiKey}}.Encode()
+		}
+		client.timeout = 30 * time.Minute
+		client.do(http.MethodPost, path, data)
+	}
+}
+
+func cacheUsage() {
+	fmt.Println("relay-admin cache commands (talk to a running relay):")
+	fmt.Println("  cache stats          Hit ratio, entry count and size")
+	fmt.Println("  cache get            Show an entry by key")
+	fmt.Println("     flags: -key -body")
+	fmt.Println("  cache lookup         Show the entry a request body maps to")
+	fmt.Println("     flags: -path -file -api-key -tenant -body")
+	fmt.Println("  cache purge          Delete entries")
+	fmt.Println("     flags: -key -model -tenant -older-than -all")
+	fmt.Println("  cache warm           Replay a JSONL file of requests to fill the cache")
+	fmt.Println("     flags: -file -api-key")
+	fmt.Println("  common flags: -url -admin-key")
+}
+
+type adminClient struct {
+	baseURL  string
+	adminKey string
+	timeout  time.Duration
+}
+
+func newAdminClient(baseURL, adminKey string) *adminClient {
+	if baseURL == "" || adminKey == "" {
+		cfg := mustLoadConfig()
+		if baseURL == "" {
+			baseURL = "http://localhost" + cfg.Server.Port
+		}
+		if adminKey == "" {
+			adminKey = cfg.Auth.AdminKey
+		}
+	}
+	if adminKey == "" {
+		adminKey = os.Getenv("ADMIN_KEY")
+	}
+	if adminKey == "" {
+		log.Fatal("admin key not set: use -admin-key, auth.admin_key or ADMIN_KEY")
+	}
+	return &adminClient{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		adminKey: adminKey,
+		timeout:  time.Minute,
+	}
+}
+
+// do sends an admin request and pretty-prints the JSON response
+func (c *adminClient) do(method, path string, body []byte) {
+	req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("invalid request: %v", err)
+	}
+	req.Header.Set("X-Admin-Key", c.adminKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := (&http.Client{Timeout: c.timeout}).Do(req)
+	if err != nil {
+		log.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	var out bytes.Buffer
+	if json.Indent(&out, data, "", "  ") == nil {
+		data = out.Bytes()
+	}
+	fmt.Println(strings.TrimSpace(string(data)))
+
+	if resp.StatusCode >= 300 {
+		os.Exit(1)
+	}
+}
+
+func readInput(file string) ([]byte, error) {
+	if file == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(file)
+}
@@ -46,6 +46,10 @@ func main() {
 		cfg := mustLoadConfig()
 		rdb := mustRedis(cfg)
 		handleListKeys(rdb)
+	case "cache":
+		handleCache(os.Args[2:])
+	case "upstreams":
+		handleUpstreams(os.Args[2:])
 	default:
 		usage()
 		os.Exit(1)
@@ -56,8 +60,10 @@ func usage() {
 	fmt.Println("relay-admin commands:")
 	fmt.Println("  init                 Generate admin key and store in .env")
 	fmt.Println("  create-key           Create a new API key")
-	fmt.Println("     flags: -name -user -desc -rps -burst -quota -expires-days")
+	fmt.Println("     flags: -name -user -team -desc -rps -burst -quota -expires-days")
 	fmt.Println("  list-keys            List all active keys")
+	fmt.Println("  cache <subcommand>   Inspect, purge and warm the response cache (stats|get|lookup|purge|warm)")
+	fmt.Println("  upstreams <subcommand>  Manage load balancer targets at runtime (list|add|remove|weight|drain|disable|enable|reset)")
 }
 
 func mustLoadConfig() *config.Config {
@@ -124,11 +130,13 @@ func handleCreateKey(cfg *config.Config, rdb *cache.Client) {
 	fs := flag.NewFlagSet("create-key", flag.ExitOnError)
 	name := fs.String("name", "root", "Key name")
 	user := fs.String("user", "root", "User ID")
+	team := fs.String("team", "", "Team ID")
 	desc := fs.String("desc", "bootstrap key", "Description")
 	rps := fs.Float64("rps", 10, "Requests per second")
 	burst := fs.Int("burst", 20, "Burst")
 	quota := fs.Int64("quota", 0, "Quota (0 = unlimited)")
 	expiresDays := fs.Int("expires-days", 0, "Expires in N days (0 = never)")
+	models := fs.String("models", "", "Comma-separated models the key may use (empty = all)")
 
 	if err := fs.Parse(os.Args[2:]); err != nil {
 		log.Fatalf("failed to parse flags: %v", err)
@@ -140,12 +148,19 @@ func handleCreateKey(cfg *config.Config, rdb *cache.Client) {
 		expiresIn = &d
 	}
 
+	var allowedModels []string
+	for _, m := range strings.Split(*models, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			allowedModels = append(allowedModels, m)
+		}
+	}
+
 	km := keymanager.New(rdb)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	key, err := km.CreateKey(ctx, *name, *user, *desc, *rps, *burst, *quota, expiresIn)
+	key, err := km.CreateKey(ctx, *name, *user, *team, *desc, *rps, *burst, *quota, expiresIn, allowedModels)
 	if err != nil {
 		log.Fatalf("failed to create key: %v", err)
 	}
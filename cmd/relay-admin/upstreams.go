@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// handleUpstreams runs an upstreams subcommand against a running relay's admin API
+func handleUpstreams(args []string) {
+	if len(args) < 1 {
+		upstreamsUsage()
+		os.Exit(1)
+	}
+
+	sub, args := args[0], args[1:]
+	fs := flag.NewFlagSet("upstreams "+sub, flag.ExitOnError)
+	baseURL := fs.String("url", "", "Relay base URL (default http://localhost<server.port>)")
+	adminKey := fs.String("admin-key", "", "Admin key (default auth.admin_key or $ADMIN_KEY)")
+	pool := fs.String("pool", "default", "Pool: a route name, or default for the top-level load balancer")
+
+	var (
+		target, provider, apiKey *string
+		weight                   *int
+	)
+	switch sub {
+	case "list":
+	case "add":
+		target = fs.String("target", "", "Target URL")
+		weight = fs.Int("weight", 0, "Weight (default 1)")
+		provider = fs.String("provider", "", "Provider (default openai)")
+		apiKey = fs.String("api-key", "", "Provider credential")
+	case "remove", "drain", "disable", "enable":
+		target = fs.String("target", "", "Target URL")
+	case "weight":
+		target = fs.String("target", "", "Target URL")
+		weight = fs.Int("weight", 0, "New weight")
+	case "reset":
+		target = fs.String("target", "", "Reset one target only")
+	default:
+		upstreamsUsage()
+		os.Exit(1)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		log.Fatalf("failed to parse flags: %v", err)
+	}
+	client := newAdminClient(*baseURL, *adminKey)
+
+	if sub == "list" {
+		client.do(http.MethodGet, "/admin/upstreams", nil)
+		return
+	}
+	if *target == "" && sub != "reset" {
+		log.Fatal("-target is required")
+	}
+
+	body := map[string]interface{}{
+		"pool": *pool,
+		"url":  *target,
+	}
+	if weight != nil {
+		body["weight"] = *weight
+	}
+	if provider != nil {
+		body["provider"] = *provider
+		body["api_key"] = *apiKey
+	}
+	payload, _ := json.Marshal(body)
+	client.do(http.MethodPost, "/admin/upstreams/"+sub, payload)
+}
+
+func upstreamsUsage() {
+	fmt.Println("relay-admin upstreams commands (talk to a running relay; changes reach every instance):")
+	fmt.Println("  upstreams list       Targets with health, circuit breaker, latency and weight")
+	fmt.Println("  upstreams add        Add a target, or restore a removed one")
+	fmt.Println("     flags: -target -weight -provider -api-key")
+	fmt.Println("  upstreams remove     Remove a target; in-flight requests finish")
+	fmt.Println("  upstreams weight     Change a target's weight")
+	fmt.Println("     flags: -target -weight")
+	fmt.Println("  upstreams drain      Stop new requests to a target; health checks go on")
+	fmt.Println("  upstreams disable    Stop new requests and health checks")
+	fmt.Println("  upstreams enable     Put a drained or disabled target back in rotation")
+	fmt.Println("  upstreams reset      Drop runtime changes and go back to the config file")
+	fmt.Println("  common flags: -url -admin-key -pool -target")
+}
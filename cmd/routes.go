@@ -0,0 +1,571 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/cache"
+	"github.com/ngoyal88/relay/pkg/config"
+	"github.com/ngoyal88/relay/pkg/discovery"
+	"github.com/ngoyal88/relay/pkg/middleware"
+	"github.com/ngoyal88/relay/pkg/proxy"
+	"github.com/ngoyal88/relay/pkg/upstreams"
+)
+
+// defaultPool names the top-level upstream in the admin API and the overrides
+const defaultPool = "default"
+
+// upstream is the inner-most handler of a chain: the Proxy or Load Balancer
+type upstream struct {
+	handler   http.Handler
+	lb        *proxy.LoadBalancer             // nil for a single target
+	targets   []proxy.TargetConfig            // Load balancer targets from the config file
+	discovery *discovery.Watcher              // Load balancer targets found at runtime; nil if not configured
+	discCfg   config.DiscoveryConfig          // What discovery was started with
+	strategy  string                          // Load balancer strategy
+	target    string                          // Single target URL
+	namespace string                          // Default cache key namespace
+	models    func() []middleware.ListedModel // Answers GET /v1/models; nil for a single target, which is asked itself
+	update    *proxy.TargetUpdate             // New targets for the previous load balancer, applied once every pool is built
+}
+
+// configured returns the static and discovered load balancer targets
+func (u *upstream) configured() []proxy.TargetConfig {
+	if u.discovery == nil {
+		return u.targets
+	}
+	return append(slices.Clone(u.targets), u.discovery.Targets()...)
+}
+
+// usesLoadBalancer reports whether a load balancer config is in effect
+func usesLoadBalancer(lbCfg *config.LoadBalancerConfig) bool {
+	return lbCfg != nil && lbCfg.Enabled && (len(lbCfg.Targets) > 0 || lbCfg.Discovery != nil)
+}
+
+// newUpstream builds a pool's load balancer when one is enabled, else a
+// single-target proxy. The pool's previous load balancer is reused when there
+// is one, so targets keep their circuit breaker and latency state; its new
+// targets are prepared in up.update for build to apply. Discovered targets and
+// the admin API's overrides are applied on top of the configured targets.
+func (g *gateway) newUpstream(pool, target string, lbCfg *config.LoadBalancerConfig) (*upstream, error) {
+	prev := g.upstreams[pool]
+	if !usesLoadBalancer(lbCfg) {
+		if prev != nil && prev.lb == nil && prev.target == target {
+			return prev, nil
+		}
+		gw, err := proxy.New(target)
+		if err != nil {
+			return nil, err
+		}
+		return &upstream{handler: gw, target: target, namespace: target}, nil
+	}
+
+	targets, namespace := toTargetConfigs(lbCfg.Targets)
+	up := &upstream{
+		targets:   targets,
+		strategy:  lbCfg.Strategy,
+		namespace: namespace,
+	}
+
+	// Discovered targets come and go, so the discovery config stands in for
+	// them in the cache namespace
+	if dc := lbCfg.Discovery; dc != nil {
+		namespace = strings.TrimPrefix(namespace+","+dc.Type+":"+dc.Name+dc.Path, ",")
+		up.namespace = namespace
+		up.discCfg = *dc
+		if prev != nil && prev.discovery != nil && reflect.DeepEqual(prev.discCfg, *dc) {
+			up.discovery = prev.discovery
+		} else {
+			w, err := discovery.NewWatcher(pool, *dc, g.refreshTargets)
+			if err != nil {
+				return nil, err
+			}
+			// A failed first lookup only stops the first startup of a pool
+			// with nothing else to route to. Otherwise the pool keeps the
+			// previous watcher's targets, or starts with none, until
+			// discovery finds some.
+			var known []proxy.TargetConfig
+			if prev != nil && prev.discovery != nil && sameSource(prev.discCfg, *dc) {
+				known = prev.discovery.Targets()
+			}
+			if err := w.Start(known); err != nil {
+				if g.upstreams == nil && len(targets) == 0 {
+					w.Stop()
+					return nil, err
+				}
+				log.Printf("⚠️ [DISCOVERY] %v; keeping %d previously discovered targets until a lookup succeeds", err, len(known))
+			}
+			up.discovery = w
+		}
+	}
+
+	effective := g.overridesFor(pool).Apply(up.configured())
+	if len(effective) == 0 && up.discovery == nil {
+		return nil, fmt.Errorf("no targets configured")
+	}
+	if prev != nil && prev.lb != nil {
+		update, err := prev.lb.PrepareUpdate(effective, lbCfg.Strategy)
+		if err != nil {
+			up.stopDiscovery(prev)
+			return nil, err
+		}
+		up.lb = prev.lb
+		up.update = update
+	} else {
+		lb, err := proxy.NewLoadBalancer(effective, lbCfg.Strategy)
+		if err != nil {
+			up.stopDiscovery(prev)
+			return nil, err
+		}
+		up.lb = lb
+	}
+
+	lb := up.lb
+	up.handler = lb
+	up.models = func() []middleware.ListedModel {
+		var out []middleware.ListedModel
+		for _, m := range lb.Models() {
+			out = append(out, middleware.ListedModel{ID: m.ID, OwnedBy: m.OwnedBy})
+		}
+		return out
+	}
+	return up, nil
+}
+
+// sameSource reports whether two discovery configs find the same targets,
+// differing at most in how often they look
+func sameSource(a, b config.DiscoveryConfig) bool {
+	a.Interval, b.Interval = 0, 0
+	return reflect.DeepEqual(a, b)
+}
+
+// stopDiscovery stops the upstream's discovery unless prev shares it
+func (u *upstream) stopDiscovery(prev *upstream) {
+	if u.discovery != nil && (prev == nil || prev.discovery != u.discovery) {
+		u.discovery.Stop()
+	}
+}
+
+// release stops what the upstreams in from use and those in keep don't
+func release(from, keep map[string]*upstream) {
+	inUse := make(map[any]bool)
+	for _, up := range keep {
+		if up.lb != nil {
+			inUse[up.lb] = true
+		}
+		if up.discovery != nil {
+			inUse[up.discovery] = true
+		}
+	}
+	for _, up := range from {
+		if up.lb != nil && !inUse[up.lb] {
+			up.lb.Close()
+		}
+		if up.discovery != nil && !inUse[up.discovery] {
+			up.discovery.Stop()
+		}
+	}
+}
+
+// validateUpstream reports what newUpstream would reject, without building anything
+func validateUpstream(target string, lbCfg *config.LoadBalancerConfig) error {
+	if !usesLoadBalancer(lbCfg) {
+		_, err := url.Parse(target)
+		return err
+	}
+	if lbCfg.Discovery != nil {
+		if _, err := discovery.NewSource(*lbCfg.Discovery); err != nil {
+			return err
+		}
+	}
+	targets, _ := toTargetConfigs(lbCfg.Targets)
+	return proxy.ValidateTargets(targets)
+}
+
+// toTargetConfigs converts load balancer targets; the namespace joins their URLs
+func toTargetConfigs(in []config.LoadBalancerTarget) ([]proxy.TargetConfig, string) {
+	targets := make([]proxy.TargetConfig, 0, len(in))
+	urls := make([]string, 0, len(in))
+	for _, t := range in {
+		targets = append(targets, proxy.TargetConfig{
+			URL:         t.URL,
+			Weight:      t.Weight,
+			Provider:    t.Provider,
+			APIKey:      t.APIKey,
+			Deployments: t.Deployments,
+			APIVersion:  t.APIVersion,
+		})
+		urls = append(urls, t.URL)
+	}
+	return targets, strings.Join(urls, ",")
+}
+
+// chainDeps are built once at startup and shared by the chains of all routes
+type chainDeps struct {
+	cfgStore      *config.Store
+	rdb           *cache.Client
+	responseCache cache.Backend
+	cacheLocker   cache.Locker      // Distributed coalescing; nil keeps it per-instance
+	compressor    *cache.Compressor // Cache entry compression; nil when off
+	rateLimit     func(http.Handler) http.Handler
+	overrides     *upstreams.Manager                                           // Target changes made through the admin API; nil without Redis
+	semantic      func(middleware.CacheConfig) func(http.Handler) http.Handler // nil when off
+	idempotency   func(http.Handler) http.Handler                              // nil when off
+}
+
+// cacheConfig converts the cache section for an upstream's namespace
+func (d *chainDeps) cacheConfig(cfg *config.Config, namespace string) middleware.CacheConfig {
+	out := toCacheConfig(cfg, namespace)
+	out.Coalesce.Locker = d.cacheLocker
+	out.Compressor = d.compressor
+	return out
+}
+
+// chainOptions are the settings of one route's chain
+type chainOptions struct {
+	upstream    *upstream
+	stripPrefix string
+	timeout     time.Duration
+	transform   *config.TransformConfig
+	rateLimit   func(http.Handler) http.Handler
+	cacheCfg    middleware.CacheConfig
+	noCache     bool // The route's cache.disabled; leaves the semantic layer out
+	auth        bool
+}
+
+// buildChain wraps a route's upstream in the per-route middleware, inner-most first
+func buildChain(deps *chainDeps, opts chainOptions) http.Handler {
+	handler := opts.upstream.handler
+	if opts.stripPrefix != "" {
+		handler = middleware.StripPrefix(opts.stripPrefix)(handler)
+	}
+	if opts.timeout > 0 {
+		handler = middleware.Timeout(opts.timeout)(handler)
+	}
+
+	// Layer A: Request Transformation (if enabled)
+	if opts.transform != nil && opts.transform.Enabled {
+		handler = middleware.TransformMiddleware(toTransformConfig(*opts.transform))(handler)
+	}
+
+	// Layer B: Rate Limiter (distributed if Redis is available)
+	handler = opts.rateLimit(handler)
+
+	// Layer C: Caching (Redis, in-memory or tiered; semantic caching needs Redis)
+	// The semantic layer sits inside the exact-match cache and only sees its misses.
+	if deps.semantic != nil && !opts.noCache {
+		handler = deps.semantic(opts.cacheCfg)(handler)
+	}
+	if deps.responseCache != nil {
+		handler = middleware.CachingMiddleware(deps.responseCache, opts.cacheCfg)(handler)
+	}
+
+	// Layer C': Idempotency keys (inside auth so keys are scoped per API key)
+	if deps.idempotency != nil {
+		handler = deps.idempotency(handler)
+	}
+
+	// Layer C'': GET /v1/models answered from every target's models, with the
+	// aliases (inside auth so the list is filtered by the API key)
+	handler = middleware.ModelsEndpoint(opts.upstream.models, deps.cfgStore)(handler)
+
+	// Layer D: Authentication (if enabled)
+	if opts.auth {
+		handler = middleware.AuthMiddleware(deps.rdb, true)(handler)
+	}
+	return handler
+}
+
+// chainSections are the parts of the config the chains are built from; a
+// reload that leaves them unchanged keeps the running chains
+type chainSections struct {
+	Target       string
+	LoadBalancer config.LoadBalancerConfig
+	Transform    config.TransformConfig
+	Cache        config.CacheConfig
+	Auth         bool
+	Routes       []config.RouteConfig
+}
+
+func sectionsOf(cfg *config.Config) chainSections {
+	return chainSections{
+		Target:       cfg.Proxy.Target,
+		LoadBalancer: cfg.LoadBalancer,
+		Transform:    cfg.Transform,
+		Cache:        cfg.Cache,
+		Auth:         cfg.Auth.Enabled,
+		Routes:       cfg.Routes,
+	}
+}
+
+// gateway serves layers A-D: the route table and each route's chain. They are
+// rebuilt and swapped in atomically when their config changes; requests
+// already running finish on the chain they started on.
+type gateway struct {
+	deps      *chainDeps
+	handler   atomic.Pointer[http.Handler]
+	cacheCfg  atomic.Pointer[middleware.CacheConfig] // Of the top-level chain, for the admin API
+	mu        sync.Mutex                             // Serializes rebuilds
+	built     chainSections
+	upstreams map[string]*upstream // By pool: route name, or defaultPool for the top-level upstream
+	limiters  map[string]func(http.Handler) http.Handler
+}
+
+func newGateway(deps *chainDeps, cfg *config.Config) (*gateway, error) {
+	g := &gateway{
+		deps:     deps,
+		limiters: make(map[string]func(http.Handler) http.Handler),
+	}
+	g.mu.Lock()
+	err := g.build(cfg)
+	g.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	deps.cfgStore.OnChange(g.reload)
+	if deps.overrides != nil {
+		deps.overrides.OnChange(g.refreshTargets)
+	}
+	return g, nil
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*g.handler.Load()).ServeHTTP(w, r)
+}
+
+// Pools returns the running load balancers by pool name
+func (g *gateway) Pools() map[string]*proxy.LoadBalancer {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	pools := make(map[string]*proxy.LoadBalancer)
+	for name, up := range g.upstreams {
+		if up.lb != nil {
+			pools[name] = up.lb
+		}
+	}
+	return pools
+}
+
+// overridesFor returns the admin API's target overrides for a pool
+func (g *gateway) overridesFor(pool string) upstreams.Overrides {
+	if g.deps.overrides == nil {
+		return upstreams.Overrides{}
+	}
+	return g.deps.overrides.Get(pool)
+}
+
+// refreshTargets re-applies discovered targets and overrides to the load
+// balancers after discovery or the admin API, here or on another instance,
+// changed them
+func (g *gateway) refreshTargets() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for pool, up := range g.upstreams {
+		if up.lb == nil {
+			continue
+		}
+		targets := g.overridesFor(pool).Apply(up.configured())
+		if len(targets) == 0 && up.discovery == nil {
+			log.Printf("⚠️ [UPSTREAMS] Keeping the previous targets of pool %s: no targets configured", pool)
+			continue
+		}
+		if err := up.lb.Update(targets, up.strategy); err != nil {
+			log.Printf("⚠️ [UPSTREAMS] Keeping the previous targets of pool %s: %v", pool, err)
+		}
+	}
+}
+
+// CacheConfig returns the cache settings of the top-level chain
+func (g *gateway) CacheConfig() middleware.CacheConfig {
+	return *g.cacheCfg.Load()
+}
+
+// reload rebuilds the chains after a config change; an invalid config keeps
+// the running ones
+func (g *gateway) reload(cfg *config.Config) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if reflect.DeepEqual(sectionsOf(cfg), g.built) {
+		return
+	}
+	if err := g.build(cfg); err != nil {
+		log.Printf("⚠️ [CONFIG] Keeping the previous routing: %v", err)
+		return
+	}
+	log.Printf("[CONFIG] Routing rebuilt (%d routes)", len(cfg.Routes))
+}
+
+// build validates the whole config before changing anything, then builds the
+// chains and swaps them in. The caller holds g.mu.
+func (g *gateway) build(cfg *config.Config) (err error) {
+	if err := validateUpstream(cfg.Proxy.Target, &cfg.LoadBalancer); err != nil {
+		return err
+	}
+	patterns, err := compileRoutes(cfg, g.deps.rdb != nil)
+	if err != nil {
+		return err
+	}
+	if cfg.Auth.Enabled && g.deps.rdb == nil {
+		return fmt.Errorf("authentication requires Redis to be enabled")
+	}
+
+	// On failure, stop what was started for the new chains only and leave the
+	// running load balancers' targets as they were
+	pools := make(map[string]*upstream)
+	defer func() {
+		if err != nil {
+			for _, up := range pools {
+				if up.update != nil {
+					up.update.Discard()
+					up.update = nil
+				}
+			}
+			release(pools, g.upstreams)
+		}
+	}()
+
+	up, err := g.newUpstream(defaultPool, cfg.Proxy.Target, &cfg.LoadBalancer)
+	if err != nil {
+		return err
+	}
+	pools[defaultPool] = up
+
+	defaults := chainOptions{
+		upstream:  up,
+		transform: &cfg.Transform,
+		rateLimit: g.deps.rateLimit,
+		cacheCfg:  g.deps.cacheConfig(cfg, up.namespace),
+		auth:      cfg.Auth.Enabled,
+	}
+	handler := buildChain(g.deps, defaults)
+
+	// Route table: matching requests get their own chain, the rest the default one
+	if len(cfg.Routes) > 0 {
+		var routes []middleware.Route
+		for i, rc := range cfg.Routes {
+			name := rc.RouteName()
+			opts := defaults
+			opts.stripPrefix = rc.StripPrefix
+			opts.timeout = rc.Timeout
+			if rc.Target != "" || rc.LoadBalancer != nil {
+				up, err := g.newUpstream(name, rc.Target, rc.LoadBalancer)
+				if err != nil {
+					return fmt.Errorf("route %s: %w", name, err)
+				}
+				pools[name] = up
+				opts.upstream = up
+				opts.cacheCfg = g.deps.cacheConfig(cfg, up.namespace)
+			}
+			if rc.Cache != nil {
+				opts.cacheCfg = withRouteCache(opts.cacheCfg, *rc.Cache)
+				opts.noCache = rc.Cache.Disabled
+			}
+			if rc.Transform != nil {
+				opts.transform = rc.Transform
+			}
+			if rc.RateLimit != nil {
+				opts.rateLimit = g.routeLimiter(name)
+			}
+			if rc.Auth != nil {
+				opts.auth = *rc.Auth
+			}
+			routes = append(routes, middleware.Route{
+				Name:    name,
+				Prefix:  rc.Path,
+				Pattern: patterns[i],
+				Handler: buildChain(g.deps, opts),
+			})
+		}
+		handler = middleware.RouteTable(routes, handler)
+	}
+
+	// Every pool was built, so the reused load balancers can switch targets
+	for _, up := range pools {
+		if up.update != nil {
+			up.update.Apply()
+			up.update = nil
+		}
+	}
+	g.cacheCfg.Store(&defaults.cacheCfg)
+	g.handler.Store(&handler)
+
+	// Stop the load balancers and discovery no longer in use
+	release(g.upstreams, pools)
+	g.upstreams = pools
+	g.built = sectionsOf(cfg)
+	return nil
+}
+
+// routeLimiter returns a route's rate limiter, kept across rebuilds so its
+// in-memory buckets survive reloads
+func (g *gateway) routeLimiter(name string) func(http.Handler) http.Handler {
+	limiter, ok := g.limiters[name]
+	if !ok {
+		limiter = middleware.NewRouteRateLimiter(g.deps.rdb, g.deps.cfgStore, name)
+		g.limiters[name] = limiter
+	}
+	return limiter
+}
+
+// compileRoutes checks every route and compiles its regex; a route matched by
+// path gets a nil pattern
+func compileRoutes(cfg *config.Config, haveRedis bool) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, len(cfg.Routes))
+	for i, rc := range cfg.Routes {
+		name := rc.RouteName()
+		if name == defaultPool {
+			return nil, fmt.Errorf("route name %q is reserved for the top-level upstream", defaultPool)
+		}
+		switch {
+		case rc.Path != "":
+		case rc.Regex != "":
+			re, err := regexp.Compile(rc.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: invalid regex: %w", name, err)
+			}
+			patterns[i] = re
+		default:
+			return nil, fmt.Errorf("route %s: path or regex is required", name)
+		}
+
+		if rc.Target != "" || rc.LoadBalancer != nil {
+			if err := validateUpstream(rc.Target, rc.LoadBalancer); err != nil {
+				return nil, fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+		auth := cfg.Auth.Enabled
+		if rc.Auth != nil {
+			auth = *rc.Auth
+		}
+		if auth && !haveRedis {
+			return nil, fmt.Errorf("route %s: authentication requires Redis to be enabled", name)
+		}
+	}
+	return patterns, nil
+}
+
+// withRouteCache puts a route's cache policy ahead of the path-based cache.routes
+func withRouteCache(cfg middleware.CacheConfig, rc config.RouteCacheConfig) middleware.CacheConfig {
+	route := middleware.CacheRoute{
+		Path:                 "", // Matches every path of the route
+		TTL:                  rc.TTL,
+		RequireDeterministic: rc.RequireDeterministic,
+		Disabled:             rc.Disabled,
+	}
+	if rc.Key != nil {
+		key := toCacheKey(*rc.Key, cfg.Key.Namespace)
+		route.Key = &key
+	}
+	cfg.Routes = append([]middleware.CacheRoute{route}, cfg.Routes...)
+	return cfg
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ngoyal88/relay/pkg/cache"
+	"github.com/ngoyal88/relay/pkg/config"
+	"github.com/ngoyal88/relay/pkg/middleware"
+	"github.com/ngoyal88/relay/pkg/upstreams"
+)
+
+// newTestDeps returns what the chains for cfg need, without Redis
+func newTestDeps(cfg *config.Config) *chainDeps {
+	return &chainDeps{
+		cfgStore:  config.NewStore(cfg),
+		rateLimit: func(next http.Handler) http.Handler { return next },
+	}
+}
+
+// newTestGateway builds the chains for cfg
+func newTestGateway(t *testing.T, deps *chainDeps, cfg *config.Config) *gateway {
+	t.Helper()
+	g, err := newGateway(deps, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { release(g.upstreams, nil) })
+	return g
+}
+
+// poolTargets returns the URLs a pool's load balancer routes to
+func poolTargets(t *testing.T, g *gateway, pool string) []string {
+	t.Helper()
+	lb := g.Pools()[pool]
+	if lb == nil {
+		t.Fatalf("no load balancer for pool %s", pool)
+	}
+	_, targets := lb.Status()
+	urls := make([]string, 0, len(targets))
+	for _, target := range targets {
+		urls = append(urls, target.URL)
+	}
+	return urls
+}
+
+func newTestBackend(t *testing.T) string {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// writeTargets writes a discovery file listing the backends
+func writeTargets(t *testing.T, path string, backends ...string) {
+	t.Helper()
+	hosts := `[]`
+	if len(backends) > 0 {
+		hosts = `[{"targets": [`
+		for i, b := range backends {
+			u, err := url.Parse(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i > 0 {
+				hosts += ", "
+			}
+			hosts += `"` + u.Host + `"`
+		}
+		hosts += `]}]`
+	}
+	if err := os.WriteFile(path, []byte(hosts), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildChainLeavesSemanticCacheOutOfDisabledRoutes(t *testing.T) {
+	semanticLayers := 0
+	deps := &chainDeps{
+		semantic: func(middleware.CacheConfig) func(http.Handler) http.Handler {
+			semanticLayers++
+			return func(next http.Handler) http.Handler { return next }
+		},
+	}
+	opts := chainOptions{
+		upstream:  &upstream{handler: http.NotFoundHandler()},
+		rateLimit: func(next http.Handler) http.Handler { return next },
+	}
+
+	buildChain(deps, opts)
+	if semanticLayers != 1 {
+		t.Fatalf("semantic layer added %d times to a cached route, want 1", semanticLayers)
+	}
+	opts.noCache = true
+	buildChain(deps, opts)
+	if semanticLayers != 1 {
+		t.Errorf("semantic layer added to a route with cache.disabled")
+	}
+}
+
+func TestBuildLeavesTargetsUnchangedWhenARouteFails(t *testing.T) {
+	a, b, c := newTestBackend(t), newTestBackend(t), newTestBackend(t)
+	mr := miniredis.RunT(t)
+	rdb, err := cache.NewRedis(mr.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("connect to miniredis: %v", err)
+	}
+	cfg := &config.Config{
+		LoadBalancer: config.LoadBalancerConfig{Enabled: true, Targets: []config.LoadBalancerTarget{{URL: a}}},
+	}
+	deps := newTestDeps(cfg)
+	deps.overrides = upstreams.New(rdb)
+	g := newTestGateway(t, deps, cfg)
+
+	// The admin API took out the only target of the route's pool, so it can't be built
+	err = deps.overrides.Update(context.Background(), "fleet", func(o *upstreams.Overrides) error {
+		o.Removed = []string{c}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.build(&config.Config{
+		LoadBalancer: config.LoadBalancerConfig{Enabled: true, Targets: []config.LoadBalancerTarget{{URL: b}}},
+		Routes: []config.RouteConfig{{
+			Name: "fleet",
+			Path: "/fleet",
+			LoadBalancer: &config.LoadBalancerConfig{
+				Enabled: true,
+				Targets: []config.LoadBalancerTarget{{URL: c}},
+			},
+		}},
+	})
+	if err == nil {
+		t.Fatal("build succeeded without targets for /fleet")
+	}
+	if got := poolTargets(t, g, defaultPool); len(got) != 1 || got[0] != a {
+		t.Errorf("default pool routes to %v after a failed build, want [%s]", got, a)
+	}
+}
+
+func TestStartupFailsWhenDiscoveryIsTheOnlySourceAndFails(t *testing.T) {
+	a := newTestBackend(t)
+	missing := filepath.Join(t.TempDir(), "targets.json")
+	cfg := &config.Config{
+		LoadBalancer: config.LoadBalancerConfig{
+			Enabled:   true,
+			Discovery: &config.DiscoveryConfig{Type: "file", Path: missing},
+		},
+	}
+	if g, err := newGateway(newTestDeps(cfg), cfg); err == nil {
+		release(g.upstreams, nil)
+		t.Fatal("gateway started without any targets")
+	}
+
+	// Static targets carry the pool until discovery works
+	cfg.LoadBalancer.Targets = []config.LoadBalancerTarget{{URL: a}}
+	g := newTestGateway(t, newTestDeps(cfg), cfg)
+	if got := poolTargets(t, g, defaultPool); len(got) != 1 || got[0] != a {
+		t.Errorf("default pool routes to %v, want [%s]", got, a)
+	}
+}
+
+func TestReloadSurvivesFailedDiscovery(t *testing.T) {
+	a, b := newTestBackend(t), newTestBackend(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "targets.json")
+	writeTargets(t, file, b)
+
+	discovered := func(interval time.Duration) *config.LoadBalancerConfig {
+		return &config.LoadBalancerConfig{
+			Enabled:   true,
+			Discovery: &config.DiscoveryConfig{Type: "file", Path: file, Interval: interval},
+		}
+	}
+	cfg := &config.Config{
+		LoadBalancer: config.LoadBalancerConfig{Enabled: true, Targets: []config.LoadBalancerTarget{{URL: a}}},
+		Routes:       []config.RouteConfig{{Name: "fleet", Path: "/fleet", LoadBalancer: discovered(time.Hour)}},
+	}
+	g := newTestGateway(t, newTestDeps(cfg), cfg)
+	if got := poolTargets(t, g, "fleet"); len(got) != 1 || got[0] != b {
+		t.Fatalf("fleet routes to %v, want [%s]", got, b)
+	}
+
+	// A new watcher for the same source keeps the previous one's targets
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Routes[0].LoadBalancer = discovered(2 * time.Hour)
+	if err := g.build(cfg); err != nil {
+		t.Fatalf("reload failed on a discovery error: %v", err)
+	}
+	if got := poolTargets(t, g, "fleet"); len(got) != 1 || got[0] != b {
+		t.Errorf("fleet routes to %v after a failed lookup, want [%s]", got, b)
+	}
+
+	// A new source starts empty and answers 503 until it finds targets
+	cfg.Routes = append(cfg.Routes, config.RouteConfig{
+		Name: "spare",
+		Path: "/spare",
+		LoadBalancer: &config.LoadBalancerConfig{
+			Enabled:   true,
+			Discovery: &config.DiscoveryConfig{Type: "file", Path: filepath.Join(dir, "spare.json")},
+		},
+	})
+	if err := g.build(cfg); err != nil {
+		t.Fatalf("reload failed on a new route's discovery error: %v", err)
+	}
+	if got := poolTargets(t, g, "spare"); len(got) != 0 {
+		t.Errorf("spare routes to %v, want no targets", got)
+	}
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/spare/v1/chat/completions", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("spare answered %d, want 503", rec.Code)
+	}
+}
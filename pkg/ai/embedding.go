@@ -0,0 +1,74 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// EmbeddingClient calls an OpenAI-compatible /v1/embeddings endpoint
+type EmbeddingClient struct {
+	url    string
+	model  string
+	apiKey string
+	client *http.Client
+}
+
+// NewEmbeddingClient creates a client for the given endpoint URL and embedding model
+func NewEmbeddingClient(url, model, apiKey string) *EmbeddingClient {
+	return &EmbeddingClient{
+		url:    url,
+		model:  model,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Embed returns the embedding vector for a single input text
+func (c *EmbeddingClient) Embed(ctx context.Context, text string) ([]float32, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"model": c.model,
+		"input": text,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("embedding request failed: %s: %s", resp.Status, body)
+	}
+
+	var result struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid embedding response: %w", err)
+	}
+	if len(result.Data) == 0 || len(result.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("embedding response contained no vectors")
+	}
+
+	return result.Data[0].Embedding, nil
+}
@@ -5,10 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
+	"github.com/ngoyal88/relay/pkg/apierror"
+	"github.com/ngoyal88/relay/pkg/cache"
 	"github.com/ngoyal88/relay/pkg/keymanager"
+	"github.com/ngoyal88/relay/pkg/middleware"
+	"github.com/ngoyal88/relay/pkg/proxy"
 	"github.com/ngoyal88/relay/pkg/storage"
+	"github.com/ngoyal88/relay/pkg/upstreams"
 )
 
 // AdminAPI provides endpoints for managing the relay
@@ -16,6 +23,15 @@ type AdminAPI struct {
 	keyManager *keymanager.Manager
 	store      storage.Store
 	adminKey   string // Simple admin authentication
+
+	// Response cache management (optional, see EnableCache)
+	cache    cache.Backend
+	cacheCfg func() middleware.CacheConfig
+	proxy    http.Handler
+
+	// Load balancer target management (optional, see EnableUpstreams)
+	upstreams *upstreams.Manager
+	pools     func() map[string]*proxy.LoadBalancer
 }
 
 // NewAdminAPI creates a new admin API handler
@@ -40,37 +56,40 @@ func (api *AdminAPI) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/admin/usage", api.authenticate(api.handleUsageStats))
 	mux.HandleFunc("/admin/costs", api.authenticate(api.handleCostStats))
 	mux.HandleFunc("/admin/logs", api.authenticate(api.handleLogs))
+
+	// Cache
+	api.registerCacheRoutes(mux)
+
+	// Upstreams
+	api.registerUpstreamRoutes(mux)
 	
 	// System
 	mux.HandleFunc("/admin/health", api.handleHealth)
 }
 
-// authenticate middleware checks admin key
+// authenticate middleware checks admin key, tagging the request with an ID
+// first so errors carry one wherever the admin API is mounted
 func (api *AdminAPI) authenticate(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+	return middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("X-Admin-Key")
 		if authHeader != api.adminKey {
-			respondJSON(w, http.StatusUnauthorized, map[string]string{
-				"error": "Invalid admin key",
-			})
+			apierror.Write(w, r, apierror.CodeInvalidAdminKey, "Invalid admin key")
 			return
 		}
 		next(w, r)
-	}
+	})).ServeHTTP
 }
 
 // handleKeys lists all API keys for a user
 func (api *AdminAPI) handleKeys(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	userID := r.URL.Query().Get("user_id")
 	if userID == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "user_id parameter required",
-		})
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "user_id parameter required")
 		return
 	}
 
@@ -79,9 +98,7 @@ func (api *AdminAPI) handleKeys(w http.ResponseWriter, r *http.Request) {
 
 	keys, err := api.keyManager.ListUserKeys(ctx, userID)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to list keys: %v", err),
-		})
+		apierror.Write(w, r, apierror.CodeInternalError, fmt.Sprintf("Failed to list keys: %v", err))
 		return
 	}
 
@@ -93,32 +110,30 @@ func (api *AdminAPI) handleKeys(w http.ResponseWriter, r *http.Request) {
 // handleCreateKey creates a new API key
 func (api *AdminAPI) handleCreateKey(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	var req struct {
 		Name        string  `json:"name"`
 		UserID      string  `json:"user_id"`
+		TeamID      string  `json:"team_id"`
 		Description string  `json:"description"`
 		RateLimit   float64 `json:"rate_limit"`
 		Burst       int     `json:"burst"`
 		Quota       int64   `json:"quota"`
 		ExpiresInDays int   `json:"expires_in_days"`
+		AllowedModels []string `json:"allowed_models"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "Invalid request body")
 		return
 	}
 
 	// Validation
 	if req.Name == "" || req.UserID == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "name and user_id are required",
-		})
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "name and user_id are required")
 		return
 	}
 
@@ -135,16 +150,16 @@ func (api *AdminAPI) handleCreateKey(w http.ResponseWriter, r *http.Request) {
 		ctx,
 		req.Name,
 		req.UserID,
+		req.TeamID,
 		req.Description,
 		req.RateLimit,
 		req.Burst,
 		req.Quota,
 		expiresIn,
+		req.AllowedModels,
 	)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to create key: %v", err),
-		})
+		apierror.Write(w, r, apierror.CodeInternalError, fmt.Sprintf("Failed to create key: %v", err))
 		return
 	}
 
@@ -157,7 +172,7 @@ func (api *AdminAPI) handleCreateKey(w http.ResponseWriter, r *http.Request) {
 // handleRevokeKey deactivates an API key
 func (api *AdminAPI) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
@@ -166,9 +181,7 @@ func (api *AdminAPI) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "Invalid request body")
 		return
 	}
 
@@ -176,9 +189,7 @@ func (api *AdminAPI) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	if err := api.keyManager.RevokeKey(ctx, req.Key); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to revoke key: %v", err),
-		})
+		apierror.Write(w, r, apierror.CodeInternalError, fmt.Sprintf("Failed to revoke key: %v", err))
 		return
 	}
 
@@ -190,15 +201,13 @@ func (api *AdminAPI) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
 // handleDeleteKey permanently removes an API key
 func (api *AdminAPI) handleDeleteKey(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	key := r.URL.Query().Get("key")
 	if key == "" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "key parameter required",
-		})
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "key parameter required")
 		return
 	}
 
@@ -206,9 +215,7 @@ func (api *AdminAPI) handleDeleteKey(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	if err := api.keyManager.DeleteKey(ctx, key); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to delete key: %v", err),
-		})
+		apierror.Write(w, r, apierror.CodeInternalError, fmt.Sprintf("Failed to delete key: %v", err))
 		return
 	}
 
@@ -220,7 +227,7 @@ func (api *AdminAPI) handleDeleteKey(w http.ResponseWriter, r *http.Request) {
 // handleRotateKey creates a new key and deactivates the old one
 func (api *AdminAPI) handleRotateKey(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
@@ -229,9 +236,7 @@ func (api *AdminAPI) handleRotateKey(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "Invalid request body",
-		})
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "Invalid request body")
 		return
 	}
 
@@ -240,9 +245,7 @@ func (api *AdminAPI) handleRotateKey(w http.ResponseWriter, r *http.Request) {
 
 	newKey, err := api.keyManager.RotateKey(ctx, req.OldKey)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to rotate key: %v", err),
-		})
+		apierror.Write(w, r, apierror.CodeInternalError, fmt.Sprintf("Failed to rotate key: %v", err))
 		return
 	}
 
@@ -255,9 +258,7 @@ func (api *AdminAPI) handleRotateKey(w http.ResponseWriter, r *http.Request) {
 // handleUsageStats returns usage statistics
 func (api *AdminAPI) handleUsageStats(w http.ResponseWriter, r *http.Request) {
 	if api.store == nil {
-		respondJSON(w, http.StatusServiceUnavailable, map[string]string{
-			"error": "Logging not enabled",
-		})
+		apierror.Write(w, r, apierror.CodeNotEnabled, "Logging not enabled")
 		return
 	}
 
@@ -278,11 +279,22 @@ func (api *AdminAPI) handleUsageStats(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	stats, err := api.store.GetUsageStats(ctx, userID, from, to)
+	query := storage.StatsQuery{
+		UserID: userID,
+		APIKey: middleware.TruncateKey(r.URL.Query().Get("api_key")),
+		Model:  r.URL.Query().Get("model"),
+		TeamID: r.URL.Query().Get("team_id"),
+		From:   from,
+		To:     to,
+	}
+	if err := query.Validate(); err != nil {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	stats, err := api.store.GetUsageStats(ctx, query)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to get stats: %v", err),
-		})
+		apierror.Write(w, r, apierror.CodeInternalError, fmt.Sprintf("Failed to get stats: %v", err))
 		return
 	}
 
@@ -292,9 +304,7 @@ func (api *AdminAPI) handleUsageStats(w http.ResponseWriter, r *http.Request) {
 // handleCostStats returns cost statistics
 func (api *AdminAPI) handleCostStats(w http.ResponseWriter, r *http.Request) {
 	if api.store == nil {
-		respondJSON(w, http.StatusServiceUnavailable, map[string]string{
-			"error": "Logging not enabled",
-		})
+		apierror.Write(w, r, apierror.CodeNotEnabled, "Logging not enabled")
 		return
 	}
 
@@ -315,59 +325,124 @@ func (api *AdminAPI) handleCostStats(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	stats, err := api.store.GetCostStats(ctx, userID, from, to)
+	query := storage.StatsQuery{
+		UserID: userID,
+		APIKey: middleware.TruncateKey(r.URL.Query().Get("api_key")),
+		Model:  r.URL.Query().Get("model"),
+		TeamID: r.URL.Query().Get("team_id"),
+		From:   from,
+		To:     to,
+	}
+	if err := query.Validate(); err != nil {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	stats, err := api.store.GetCostStats(ctx, query)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to get stats: %v", err),
-		})
+		apierror.Write(w, r, apierror.CodeInternalError, fmt.Sprintf("Failed to get stats: %v", err))
 		return
 	}
 
 	respondJSON(w, http.StatusOK, stats)
 }
 
-// handleLogs returns request logs
+// handleLogs searches request logs with cursor pagination
 func (api *AdminAPI) handleLogs(w http.ResponseWriter, r *http.Request) {
 	if api.store == nil {
-		respondJSON(w, http.StatusServiceUnavailable, map[string]string{
-			"error": "Logging not enabled",
-		})
+		apierror.Write(w, r, apierror.CodeNotEnabled, "Logging not enabled")
 		return
 	}
 
-	filters := storage.LogFilters{
-		UserID:  r.URL.Query().Get("user_id"),
-		Model:   r.URL.Query().Get("model"),
-		Limit:   100,
-	}
-
-	fromStr := r.URL.Query().Get("from")
-	toStr := r.URL.Query().Get("to")
-	
-	if fromStr != "" {
-		filters.From, _ = time.Parse(time.RFC3339, fromStr)
-	}
-	if toStr != "" {
-		filters.To, _ = time.Parse(time.RFC3339, toStr)
+	filters, err := parseLogFilters(r.URL.Query())
+	if err != nil {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, err.Error())
+		return
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	logs, err := api.store.ListRequestLogs(ctx, filters)
+	page, err := api.store.QueryRequestLogs(ctx, filters)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to get logs: %v", err),
-		})
+		apierror.Write(w, r, apierror.CodeInternalError, fmt.Sprintf("Failed to get logs: %v", err))
 		return
 	}
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"logs":  logs,
-		"count": len(logs),
+		"logs":        page.Logs,
+		"count":       len(page.Logs),
+		"next_cursor": page.NextCursor,
 	})
 }
 
+// parseLogFilters maps /admin/logs query parameters onto storage filters
+func parseLogFilters(q url.Values) (storage.LogFilters, error) {
+	filters := storage.LogFilters{
+		UserID: q.Get("user_id"),
+		Model:  q.Get("model"),
+		Path:   q.Get("path"),
+		Search: q.Get("q"),
+		Cursor: q.Get("cursor"),
+		Order:  q.Get("order"),
+		Limit:  100,
+	}
+	if key := q.Get("api_key"); key != "" {
+		filters.APIKey = middleware.TruncateKey(key)
+	}
+	if filters.Order != "" && filters.Order != "asc" && filters.Order != "desc" {
+		return filters, fmt.Errorf("order must be asc or desc")
+	}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if filters.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return filters, fmt.Errorf("invalid from: %v", err)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filters.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return filters, fmt.Errorf("invalid to: %v", err)
+		}
+	}
+
+	ints := map[string]*int{
+		"limit":      &filters.Limit,
+		"status":     &filters.StatusCode,
+		"status_min": &filters.StatusMin,
+		"status_max": &filters.StatusMax,
+	}
+	for name, dst := range ints {
+		if v := q.Get(name); v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil {
+				return filters, fmt.Errorf("invalid %s: %v", name, err)
+			}
+		}
+	}
+
+	if v := q.Get("cache_hit"); v != "" {
+		hit, err := strconv.ParseBool(v)
+		if err != nil {
+			return filters, fmt.Errorf("invalid cache_hit: %v", err)
+		}
+		filters.CacheHit = &hit
+	}
+	if v := q.Get("min_latency_ms"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil {
+			return filters, fmt.Errorf("invalid min_latency_ms: %v", err)
+		}
+		filters.MinDuration = time.Duration(ms) * time.Millisecond
+	}
+	if v := q.Get("min_cost"); v != "" {
+		if filters.MinCost, err = strconv.ParseFloat(v, 64); err != nil {
+			return filters, fmt.Errorf("invalid min_cost: %v", err)
+		}
+	}
+
+	return filters, nil
+}
+
 // handleHealth returns system health
 func (api *AdminAPI) handleHealth(w http.ResponseWriter, r *http.Request) {
 	health := map[string]interface{}{
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ngoyal88/relay/pkg/cache"
+	"github.com/ngoyal88/relay/pkg/keymanager"
+	"github.com/ngoyal88/relay/pkg/middleware"
+	"github.com/ngoyal88/relay/pkg/storage"
+)
+
+const testAdminKey = "test-admin-key"
+
+// testRelay is a proxy with auth and request logging in front of a stub
+// upstream, plus the admin API over the same Redis
+type testRelay struct {
+	rdb   *cache.Client
+	km    *keymanager.Manager
+	store storage.Store
+	admin *http.ServeMux
+	proxy http.Handler
+}
+
+func newTestRelay(t *testing.T) *testRelay {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb, err := cache.NewRedis(mr.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("connect to miniredis: %v", err)
+	}
+
+	tr := &testRelay{
+		rdb:   rdb,
+		km:    keymanager.New(rdb),
+		store: storage.NewRedisStore(rdb, time.Hour),
+		admin: http.NewServeMux(),
+	}
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"usage":{"prompt_tokens":1,"completion_tokens":1}}`))
+	})
+	tr.proxy = middleware.RequestLoggingMiddleware(tr.store, true, nil)(middleware.AuthMiddleware(rdb, true)(upstream))
+	NewAdminAPI(tr.km, tr.store, testAdminKey).RegisterRoutes(tr.admin)
+	return tr
+}
+
+func (tr *testRelay) createKey(t *testing.T, userID string) string {
+	t.Helper()
+	key, err := tr.km.CreateKey(context.Background(), userID, userID, "", "", 0, 0, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	return key.Key
+}
+
+func (tr *testRelay) send(t *testing.T, key string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	req.Header.Set("Authorization", "Bearer "+key)
+	rec := httptest.NewRecorder()
+	tr.proxy.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("proxy status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func (tr *testRelay) adminRequest(method, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-Admin-Key", testAdminKey)
+	rec := httptest.NewRecorder()
+	tr.admin.ServeHTTP(rec, req)
+	return rec
+}
+
+// waitForLogs polls until the asynchronously saved logs are all stored
+func (tr *testRelay) waitForLogs(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		page, err := tr.store.QueryRequestLogs(context.Background(), storage.LogFilters{Limit: n})
+		if err == nil && len(page.Logs) == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("fewer than %d logs saved", n)
+}
+
+func TestLogsFilterByAPIKeyPaginates(t *testing.T) {
+	tr := newTestRelay(t)
+	alice := tr.createKey(t, "alice")
+	bob := tr.createKey(t, "bob")
+	for i := 0; i < 3; i++ {
+		tr.send(t, alice)
+		tr.send(t, bob)
+	}
+	tr.waitForLogs(t, 6)
+
+	var seen []string
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		q := url.Values{"api_key": {alice}, "limit": {"2"}}
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		rec := tr.adminRequest(http.MethodGet, "/admin/logs?"+q.Encode(), "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		var resp struct {
+			Logs       []storage.RequestLog `json:"logs"`
+			NextCursor string               `json:"next_cursor"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		for _, entry := range resp.Logs {
+			if entry.UserID != "alice" {
+				t.Errorf("log %s belongs to %q", entry.ID, entry.UserID)
+			}
+			seen = append(seen, entry.ID)
+		}
+		if cursor = resp.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("got %d of alice's logs, want 3", len(seen))
+	}
+}
+
+func TestAdminErrorsUseEnvelope(t *testing.T) {
+	tr := newTestRelay(t)
+
+	unauthorized := httptest.NewRequest(http.MethodGet, "/admin/keys?user_id=alice", nil)
+	unauthorized.Header.Set("X-Admin-Key", "wrong")
+	rec := httptest.NewRecorder()
+	tr.admin.ServeHTTP(rec, unauthorized)
+
+	tests := []struct {
+		name   string
+		rec    *httptest.ResponseRecorder
+		status int
+		code   string
+	}{
+		{"wrong admin key", rec, http.StatusUnauthorized, "invalid_admin_key"},
+		{"wrong method", tr.adminRequest(http.MethodGet, "/admin/keys/create", ""), http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"missing parameter", tr.adminRequest(http.MethodGet, "/admin/keys", ""), http.StatusBadRequest, "invalid_request"},
+		{"invalid body", tr.adminRequest(http.MethodPost, "/admin/keys/create", "{"), http.StatusBadRequest, "invalid_request"},
+		{"combined filters", tr.adminRequest(http.MethodGet, "/admin/usage?user_id=alice&model=gpt-4o", ""), http.StatusBadRequest, "invalid_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.rec.Code != tt.status {
+				t.Errorf("status %d, want %d", tt.rec.Code, tt.status)
+			}
+			var got struct {
+				Error *struct {
+					Message string `json:"message"`
+					Type    string `json:"type"`
+					Code    string `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(tt.rec.Body.Bytes(), &got); err != nil || got.Error == nil {
+				t.Fatalf("not an error envelope: %s", tt.rec.Body)
+			}
+			if got.Error.Code != tt.code || got.Error.Message == "" || got.Error.Type == "" {
+				t.Errorf("error = %+v, want code %s", got.Error, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/apierror"
+	"github.com/ngoyal88/relay/pkg/cache"
+	"github.com/ngoyal88/relay/pkg/middleware"
+)
+
+// maxWarmRequests bounds a single /admin/cache/warm upload
+const maxWarmRequests = 10000
+
+// EnableCache turns on the /admin/cache endpoints. cfg must return the config
+// CachingMiddleware currently runs with so lookups compute the same keys;
+// handler is the full proxy chain used to replay requests when warming the cache.
+func (api *AdminAPI) EnableCache(backend cache.Backend, cfg func() middleware.CacheConfig, handler http.Handler) {
+	api.cache = backend
+	api.cacheCfg = cfg
+	api.proxy = handler
+}
+
+func (api *AdminAPI) registerCacheRoutes(mux *http.ServeMux) {
+	if api.cache == nil {
+		return
+	}
+	mux.HandleFunc("/admin/cache/stats", api.authenticate(api.handleCacheStats))
+	mux.HandleFunc("/admin/cache/entry", api.authenticate(api.handleCacheEntry))
+	mux.HandleFunc("/admin/cache/lookup", api.authenticate(api.handleCacheLookup))
+	mux.HandleFunc("/admin/cache/purge", api.authenticate(api.handleCachePurge))
+	mux.HandleFunc("/admin/cache/warm", api.authenticate(api.handleCacheWarm))
+}
+
+// handleCacheStats returns hit ratio and size statistics
+func (api *AdminAPI) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	stats, err := api.cache.Stats(ctx)
+	if err != nil {
+		apierror.Write(w, r, apierror.CodeInternalError, fmt.Sprintf("Failed to get cache stats: %v", err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, stats)
+}
+
+// handleCacheEntry returns a cached entry by its hash (the X-Cache-Key header)
+func (api *AdminAPI) handleCacheEntry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "key parameter required")
+		return
+	}
+	includeBody, _ := strconv.ParseBool(r.URL.Query().Get("include_body"))
+
+	api.respondCacheEntry(w, r, key, includeBody)
+}
+
+// handleCacheLookup computes the key a request body would be cached under and returns the entry
+func (api *AdminAPI) handleCacheLookup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Path        string          `json:"path"`
+		Body        json.RawMessage `json:"body"`
+		APIKey      string          `json:"api_key"`
+		Tenant      string          `json:"tenant"`
+		IncludeBody bool            `json:"include_body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Body) == 0 {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "Invalid request body: path and body are required")
+		return
+	}
+	if req.Path == "" {
+		req.Path = "/v1/chat/completions"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// Tenant-scoped keys depend on the API key the request would have used
+	var apiKey *middleware.APIKey
+	switch {
+	case req.APIKey != "":
+		k, err := api.keyManager.GetKey(ctx, req.APIKey)
+		if err != nil {
+			apierror.Write(w, r, apierror.CodeInvalidRequest, fmt.Sprintf("Unknown api_key: %v", err))
+			return
+		}
+		apiKey = k
+	case req.Tenant != "":
+		apiKey = &middleware.APIKey{TeamID: req.Tenant}
+	}
+
+	probe, err := http.NewRequestWithContext(ctx, http.MethodPost, req.Path, nil)
+	if err != nil {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, fmt.Sprintf("Invalid path: %v", err))
+		return
+	}
+	if apiKey != nil {
+		probe = probe.WithContext(middleware.WithAPIKey(ctx, apiKey))
+	}
+
+	api.respondCacheEntry(w, r, api.cacheCfg().CacheKey(probe, req.Body), req.IncludeBody)
+}
+
+func (api *AdminAPI) respondCacheEntry(w http.ResponseWriter, r *http.Request, key string, includeBody bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	meta, body, err := api.cache.Get(ctx, key)
+	if err == cache.ErrMiss {
+		apierror.Write(w, r, apierror.CodeNotFound, fmt.Sprintf("Entry %s not cached", key))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.CodeInternalError, fmt.Sprintf("Failed to get cache entry: %v", err))
+		return
+	}
+
+	if meta == nil {
+		meta = &cache.EntryMeta{Key: key, Size: len(body)}
+	}
+	entry, err := cache.DecodeEntry(body)
+	if err != nil {
+		apierror.Write(w, r, apierror.CodeInternalError, fmt.Sprintf("Failed to decode cache entry: %v", err))
+		return
+	}
+
+	resp := map[string]interface{}{
+		"entry":    meta,
+		"response": entry,
+	}
+	if includeBody {
+		if json.Valid(entry.Body) {
+			resp["body"] = json.RawMessage(entry.Body)
+		} else {
+			resp["body"] = string(entry.Body)
+		}
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// handleCachePurge deletes entries by key, model, tenant or age
+func (api *AdminAPI) handleCachePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Key       string `json:"key"`
+		Model     string `json:"model"`
+		Tenant    string `json:"tenant"`
+		OlderThan string `json:"older_than"` // Go duration, e.g. "24h"
+		All       bool   `json:"all"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	filter := cache.PurgeFilter{
+		Key:    req.Key,
+		Model:  req.Model,
+		Tenant: req.Tenant,
+		All:    req.All,
+	}
+	if req.OlderThan != "" {
+		d, err := time.ParseDuration(req.OlderThan)
+		if err != nil || d <= 0 {
+			apierror.Write(w, r, apierror.CodeInvalidRequest, "older_than must be a positive duration such as 24h")
+			return
+		}
+		filter.OlderThan = d
+	}
+	if filter.Empty() {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "one of key, model, tenant, older_than or all is required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	purged, err := api.cache.Purge(ctx, filter)
+	if err != nil && !errors.Is(err, cache.ErrPurgeNotAnnounced) {
+		apierror.Write(w, r, apierror.CodeInternalError, fmt.Sprintf("Failed to purge cache after %d entries: %v", purged, err))
+		return
+	}
+
+	resp := map[string]interface{}{
+		"purged":  purged,
+		"message": fmt.Sprintf("Purged %d cache entries", purged),
+	}
+	// Copies held by other instances outlive the purge until they expire
+	switch {
+	case err != nil:
+		resp["warning"] = fmt.Sprintf("Other instances may serve purged entries until cache.memory.l1_ttl passes: %v", err)
+	case isLocalCache(api.cache):
+		resp["warning"] = "The memory cache is per instance; only this instance's entries were purged"
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// warmRequest is one line of a warm-up file. A line without "body" is taken
+// to be a chat completion request body itself.
+type warmRequest struct {
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body"`
+	APIKey string          `json:"api_key"`
+}
+
+// handleCacheWarm replays a JSONL file of requests through the proxy so their
+// responses are cached. Requests that already hit the cache are not resent.
+func (api *AdminAPI) handleCacheWarm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	defaultKey := r.URL.Query().Get("api_key")
+	result := map[string]int{
+		"cached":   0, // Fetched upstream and stored
+		"existing": 0, // Already in the cache
+		"skipped":  0, // Not cacheable under the current policy
+		"failed":   0,
+	}
+	var errs []string
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 64*1024), 10<<20)
+	line := 0
+	for scanner.Scan() {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		line++
+		if line > maxWarmRequests {
+			errs = append(errs, fmt.Sprintf("stopped after %d requests", maxWarmRequests))
+			break
+		}
+
+		var req warmRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			result["failed"]++
+			errs = append(errs, fmt.Sprintf("line %d: invalid JSON", line))
+			continue
+		}
+		if len(req.Body) == 0 {
+			req.Body = append(json.RawMessage(nil), raw...)
+			req.Path = ""
+		}
+		if req.Path == "" {
+			req.Path = "/v1/chat/completions"
+		}
+		if req.APIKey == "" {
+			req.APIKey = defaultKey
+		}
+
+		status, outcome := api.warmOne(r.Context(), req)
+		switch {
+		case status != http.StatusOK:
+			result["failed"]++
+			errs = append(errs, fmt.Sprintf("line %d: upstream returned %d", line, status))
+		case outcome == "HIT" || outcome == "COALESCED":
+			result["existing"]++
+		case outcome == "MISS":
+			result["cached"]++
+		default:
+			result["skipped"]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Sprintf("read error: %v", err))
+	}
+
+	resp := map[string]interface{}{
+		"requests": line,
+		"result":   result,
+	}
+	if len(errs) > 0 {
+		if len(errs) > 20 {
+			errs = append(errs[:20], fmt.Sprintf("... and %d more", len(errs)-20))
+		}
+		resp["errors"] = errs
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// warmOne sends a request through the proxy and reports its status and X-Cache outcome
+func (api *AdminAPI) warmOne(ctx context.Context, req warmRequest) (int, string) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	// Each replay is its own request in the logs, not the warm call's
+	ctx = apierror.WithRequestID(ctx, apierror.NewRequestID())
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.Path, bytes.NewReader(req.Body))
+	if err != nil {
+		return http.StatusBadRequest, ""
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if req.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+req.APIKey)
+	}
+
+	rec := &discardResponse{header: make(http.Header)}
+	api.proxy.ServeHTTP(rec, httpReq)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.status, strings.ToUpper(rec.header.Get("X-Cache"))
+}
+
+// discardResponse records status and headers of a replayed request and drops the body
+type discardResponse struct {
+	header http.Header
+	status int
+}
+
+func (d *discardResponse) Header() http.Header { return d.header }
+
+func (d *discardResponse) WriteHeader(code int) {
+	if d.status == 0 {
+		d.status = code
+	}
+}
+
+func (d *discardResponse) Write(b []byte) (int, error) {
+	if d.status == 0 {
+		d.status = http.StatusOK
+	}
+	return len(b), nil
+}
+
+// isLocalCache reports whether the cache lives only in this instance
+func isLocalCache(b cache.Backend) bool {
+	_, ok := b.(*cache.LRU)
+	return ok
+}
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/apierror"
+	"github.com/ngoyal88/relay/pkg/proxy"
+	"github.com/ngoyal88/relay/pkg/upstreams"
+)
+
+// EnableUpstreams turns on the /admin/upstreams endpoints. pools returns the
+// running load balancers by pool name; changes go through m so every
+// instance applies them.
+func (api *AdminAPI) EnableUpstreams(m *upstreams.Manager, pools func() map[string]*proxy.LoadBalancer) {
+	api.upstreams = m
+	api.pools = pools
+}
+
+func (api *AdminAPI) registerUpstreamRoutes(mux *http.ServeMux) {
+	if api.upstreams == nil {
+		return
+	}
+	mux.HandleFunc("/admin/upstreams", api.authenticate(api.handleUpstreams))
+	mux.HandleFunc("/admin/upstreams/add", api.authenticate(api.handleAddUpstream))
+	mux.HandleFunc("/admin/upstreams/remove", api.authenticate(api.handleRemoveUpstream))
+	mux.HandleFunc("/admin/upstreams/weight", api.authenticate(api.handleUpstreamWeight))
+	mux.HandleFunc("/admin/upstreams/drain", api.authenticate(api.upstreamStateHandler(proxy.TargetDraining)))
+	mux.HandleFunc("/admin/upstreams/disable", api.authenticate(api.upstreamStateHandler(proxy.TargetDisabled)))
+	mux.HandleFunc("/admin/upstreams/enable", api.authenticate(api.upstreamStateHandler(proxy.TargetActive)))
+	mux.HandleFunc("/admin/upstreams/reset", api.authenticate(api.handleResetUpstreams))
+}
+
+// upstreamPool is a load balancer as shown by the admin API
+type upstreamPool struct {
+	Name     string               `json:"name"`
+	Strategy string               `json:"strategy"`
+	Targets  []proxy.TargetStatus `json:"targets"`
+	Removed  []string             `json:"removed,omitempty"` // Configured or discovered targets removed at runtime
+}
+
+// upstreamRequest is the body of the /admin/upstreams changes
+type upstreamRequest struct {
+	Pool   string `json:"pool"` // Defaults to "default", the top-level upstream
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// handleUpstreams lists every pool's targets with their live state
+func (api *AdminAPI) handleUpstreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	pools := api.pools()
+	out := make([]upstreamPool, 0, len(pools))
+	for name, lb := range pools {
+		out = append(out, api.upstreamPool(name, lb))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"pools": out,
+	})
+}
+
+func (api *AdminAPI) upstreamPool(name string, lb *proxy.LoadBalancer) upstreamPool {
+	strategy, statuses := lb.Status()
+	return upstreamPool{
+		Name:     name,
+		Strategy: strategy,
+		Targets:  statuses,
+		Removed:  api.upstreams.Get(name).Removed,
+	}
+}
+
+// handleAddUpstream adds a target to a pool, or restores a removed one
+func (api *AdminAPI) handleAddUpstream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Pool string `json:"pool"`
+		proxy.TargetConfig
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "Invalid request body: url is required")
+		return
+	}
+	req.URL = proxy.TargetURL(req.URL)
+	lb, ok := api.lookupPool(w, r, &req.Pool)
+	if !ok {
+		return
+	}
+	if targetIndex(lb, req.URL) >= 0 {
+		apierror.Write(w, r, apierror.CodeConflict, fmt.Sprintf("Target %s is already in pool %s", req.URL, req.Pool))
+		return
+	}
+	if err := proxy.ValidateTargets([]proxy.TargetConfig{req.TargetConfig}); err != nil {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	api.updateUpstreams(w, r, req.Pool, func(o *upstreams.Overrides) {
+		// A configured target removed earlier is restored rather than added
+		if i := removedIndex(o, req.URL); i >= 0 {
+			o.Removed = slices.Delete(o.Removed, i, i+1)
+			return
+		}
+		o.Added = append(o.Added, req.TargetConfig)
+	})
+}
+
+// handleRemoveUpstream takes a target out of a pool; in-flight requests finish
+func (api *AdminAPI) handleRemoveUpstream(w http.ResponseWriter, r *http.Request) {
+	req, lb, ok := api.decodeUpstreamRequest(w, r)
+	if !ok {
+		return
+	}
+	if _, statuses := lb.Status(); len(statuses) == 1 {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "Cannot remove the last target of a pool; disable it instead")
+		return
+	}
+
+	api.updateUpstreams(w, r, req.Pool, func(o *upstreams.Overrides) {
+		added := o.IsAdded(req.URL)
+		clearTarget(o, req.URL)
+		if !added && removedIndex(o, req.URL) < 0 {
+			o.Removed = append(o.Removed, req.URL)
+		}
+	})
+}
+
+// handleUpstreamWeight changes a target's weight
+func (api *AdminAPI) handleUpstreamWeight(w http.ResponseWriter, r *http.Request) {
+	req, _, ok := api.decodeUpstreamRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Weight < 1 {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "weight must be at least 1")
+		return
+	}
+
+	api.updateUpstreams(w, r, req.Pool, func(o *upstreams.Overrides) {
+		if o.Weights == nil {
+			o.Weights = make(map[string]int)
+		}
+		deleteTarget(o.Weights, req.URL)
+		o.Weights[req.URL] = req.Weight
+	})
+}
+
+// upstreamStateHandler drains, disables or re-enables a target
+func (api *AdminAPI) upstreamStateHandler(state proxy.TargetState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, _, ok := api.decodeUpstreamRequest(w, r)
+		if !ok {
+			return
+		}
+
+		api.updateUpstreams(w, r, req.Pool, func(o *upstreams.Overrides) {
+			deleteTarget(o.States, req.URL)
+			if state == proxy.TargetActive {
+				return
+			}
+			if o.States == nil {
+				o.States = make(map[string]proxy.TargetState)
+			}
+			o.States[req.URL] = state
+		})
+	}
+}
+
+// handleResetUpstreams drops the runtime changes to one target, or to the
+// whole pool when no url is given, going back to the config file
+func (api *AdminAPI) handleResetUpstreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req upstreamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if req.URL != "" {
+		req.URL = proxy.TargetURL(req.URL)
+	}
+	if _, ok := api.lookupPool(w, r, &req.Pool); !ok {
+		return
+	}
+
+	api.updateUpstreams(w, r, req.Pool, func(o *upstreams.Overrides) {
+		if req.URL == "" {
+			*o = upstreams.Overrides{}
+			return
+		}
+		clearTarget(o, req.URL)
+		if i := removedIndex(o, req.URL); i >= 0 {
+			o.Removed = slices.Delete(o.Removed, i, i+1)
+		}
+	})
+}
+
+// decodeUpstreamRequest reads a change to an existing target of a pool
+func (api *AdminAPI) decodeUpstreamRequest(w http.ResponseWriter, r *http.Request) (upstreamRequest, *proxy.LoadBalancer, bool) {
+	var req upstreamRequest
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return req, nil, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		apierror.Write(w, r, apierror.CodeInvalidRequest, "Invalid request body: url is required")
+		return req, nil, false
+	}
+	req.URL = proxy.TargetURL(req.URL)
+
+	lb, ok := api.lookupPool(w, r, &req.Pool)
+	if !ok {
+		return req, nil, false
+	}
+	if targetIndex(lb, req.URL) < 0 {
+		apierror.Write(w, r, apierror.CodeNotFound, fmt.Sprintf("Target %s not found in pool %s", req.URL, req.Pool))
+		return req, nil, false
+	}
+	return req, lb, true
+}
+
+// lookupPool finds a pool's load balancer, defaulting the name
+func (api *AdminAPI) lookupPool(w http.ResponseWriter, r *http.Request, pool *string) (*proxy.LoadBalancer, bool) {
+	if *pool == "" {
+		*pool = "default"
+	}
+	lb, ok := api.pools()[*pool]
+	if !ok {
+		apierror.Write(w, r, apierror.CodeNotFound, fmt.Sprintf("Pool %s not found (only load balancers can be managed)", *pool))
+	}
+	return lb, ok
+}
+
+// updateUpstreams saves a change to a pool's overrides and responds with the pool
+func (api *AdminAPI) updateUpstreams(w http.ResponseWriter, r *http.Request, pool string, change func(*upstreams.Overrides)) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	err := api.upstreams.Update(ctx, pool, func(o *upstreams.Overrides) error {
+		change(o)
+		return nil
+	})
+	if err != nil {
+		apierror.Write(w, r, apierror.CodeInternalError, fmt.Sprintf("Failed to update upstreams: %v", err))
+		return
+	}
+
+	lb, ok := api.pools()[pool]
+	if !ok {
+		respondJSON(w, http.StatusOK, map[string]string{
+			"message": "Upstreams updated",
+		})
+		return
+	}
+	respondJSON(w, http.StatusOK, api.upstreamPool(pool, lb))
+}
+
+// targetIndex returns the position of url among the pool's targets, or -1
+func targetIndex(lb *proxy.LoadBalancer, url string) int {
+	url = proxy.TargetURL(url)
+	_, statuses := lb.Status()
+	for i, s := range statuses {
+		if s.URL == url {
+			return i
+		}
+	}
+	return -1
+}
+
+// removedIndex returns the position of url among the removed targets, or -1
+func removedIndex(o *upstreams.Overrides, url string) int {
+	return slices.IndexFunc(o.Removed, func(u string) bool { return proxy.TargetURL(u) == url })
+}
+
+// clearTarget drops a target's weight, state and, if it was added at runtime, the target itself
+func clearTarget(o *upstreams.Overrides, url string) {
+	deleteTarget(o.Weights, url)
+	deleteTarget(o.States, url)
+	o.Added = slices.DeleteFunc(o.Added, func(t proxy.TargetConfig) bool { return proxy.TargetURL(t.URL) == url })
+}
+
+// deleteTarget removes url's entry, however the stored URL was written
+func deleteTarget[V any](m map[string]V, url string) {
+	for u := range m {
+		if proxy.TargetURL(u) == url {
+			delete(m, u)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ngoyal88/relay/pkg/apierror"
+	"github.com/ngoyal88/relay/pkg/cache"
+	"github.com/ngoyal88/relay/pkg/keymanager"
+	"github.com/ngoyal88/relay/pkg/proxy"
+	"github.com/ngoyal88/relay/pkg/upstreams"
+)
+
+// newTestUpstreams serves the upstreams admin API for one pool whose targets
+// come from configs, re-applying the overrides on every change like the gateway
+func newTestUpstreams(t *testing.T, configs []proxy.TargetConfig) (*http.ServeMux, *proxy.LoadBalancer) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb, err := cache.NewRedis(mr.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("connect to miniredis: %v", err)
+	}
+	lb, err := proxy.NewLoadBalancer(configs, "round-robin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(lb.Close)
+
+	m := upstreams.New(rdb)
+	m.OnChange(func() {
+		if err := lb.Update(m.Get("default").Apply(configs), "round-robin"); err != nil {
+			t.Errorf("apply overrides: %v", err)
+		}
+	})
+
+	api := NewAdminAPI(keymanager.New(rdb), nil, testAdminKey)
+	api.EnableUpstreams(m, func() map[string]*proxy.LoadBalancer {
+		return map[string]*proxy.LoadBalancer{"default": lb}
+	})
+	mux := http.NewServeMux()
+	api.RegisterRoutes(mux)
+	return mux, lb
+}
+
+func upstreamChange(t *testing.T, mux *http.ServeMux, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("X-Admin-Key", testAdminKey)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func targetWeights(lb *proxy.LoadBalancer) map[string]int {
+	_, statuses := lb.Status()
+	out := make(map[string]int, len(statuses))
+	for _, s := range statuses {
+		out[s.URL] = s.Weight
+	}
+	return out
+}
+
+func TestUpstreamChangesMatchNormalizedURLs(t *testing.T) {
+	// The config spells the first target's scheme in capitals; Status reports it normalized
+	mux, lb := newTestUpstreams(t, []proxy.TargetConfig{
+		{URL: "HTTP://127.0.0.1:9001"},
+		{URL: "http://127.0.0.1:9002"},
+	})
+	const first = "http://127.0.0.1:9001"
+
+	rec := upstreamChange(t, mux, "/admin/upstreams/weight", `{"url":"`+first+`","weight":5}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("weight: status %d: %s", rec.Code, rec.Body)
+	}
+	if got := targetWeights(lb)[first]; got != 5 {
+		t.Errorf("weight of %s = %d, want 5: %v", first, got, targetWeights(lb))
+	}
+
+	rec = upstreamChange(t, mux, "/admin/upstreams/remove", `{"url":"HTTP://127.0.0.1:9001"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("remove: status %d: %s", rec.Code, rec.Body)
+	}
+	if weights := targetWeights(lb); len(weights) != 1 {
+		t.Errorf("targets after removing %s: %v", first, weights)
+	}
+
+	// Adding it back restores the configured target instead of adding a copy
+	rec = upstreamChange(t, mux, "/admin/upstreams/add", `{"url":"`+first+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add: status %d: %s", rec.Code, rec.Body)
+	}
+	var pool upstreamPool
+	json.Unmarshal(rec.Body.Bytes(), &pool)
+	if len(pool.Targets) != 2 || len(pool.Removed) != 0 {
+		t.Errorf("pool after restoring %s: %s", first, rec.Body)
+	}
+	for _, s := range pool.Targets {
+		if s.Source != "config" {
+			t.Errorf("target %s has source %s", s.URL, s.Source)
+		}
+	}
+}
+
+func TestUpstreamErrorsUseEnvelope(t *testing.T) {
+	mux, _ := newTestUpstreams(t, []proxy.TargetConfig{{URL: "http://127.0.0.1:9001"}})
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{"duplicate target", "/admin/upstreams/add", `{"url":"HTTP://127.0.0.1:9001"}`, http.StatusConflict, "conflict"},
+		{"unknown target", "/admin/upstreams/drain", `{"url":"http://127.0.0.1:9009"}`, http.StatusNotFound, "not_found"},
+		{"unknown pool", "/admin/upstreams/drain", `{"pool":"nope","url":"http://127.0.0.1:9001"}`, http.StatusNotFound, "not_found"},
+		{"last target", "/admin/upstreams/remove", `{"url":"http://127.0.0.1:9001"}`, http.StatusBadRequest, "invalid_request"},
+		{"invalid provider", "/admin/upstreams/add", `{"url":"http://127.0.0.1:9003","provider":"nope"}`, http.StatusBadRequest, "invalid_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := upstreamChange(t, mux, tt.path, tt.body)
+			var got struct {
+				Error *struct {
+					Code      string `json:"code"`
+					RequestID string `json:"request_id"`
+				} `json:"error"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &got)
+			if rec.Code != tt.status || got.Error == nil || got.Error.Code != tt.code {
+				t.Fatalf("status %d, body %s; want %d %s", rec.Code, rec.Body, tt.status, tt.code)
+			}
+			if got.Error.RequestID == "" || got.Error.RequestID != rec.Header().Get(apierror.Header) {
+				t.Errorf("request_id %q, header %q; want the same non-empty ID", got.Error.RequestID, rec.Header().Get(apierror.Header))
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/upstreams/add", nil)
+	req.Header.Set("X-Admin-Key", testAdminKey)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed || !strings.Contains(rec.Body.String(), `"method_not_allowed"`) {
+		t.Errorf("wrong method: status %d, body %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,136 @@
+// Package apierror writes errors in OpenAI's envelope,
+// {"error":{"message","type","code","param"}}, so SDKs parse and retry Relay's
+// own errors the same way as the upstream's.
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Code identifies a Relay error. Codes are stable; messages may change.
+type Code string
+
+// The Relay error code catalogue
+const (
+	CodeInvalidRequest       Code = "invalid_request"        // 400: the body or parameters couldn't be read or translated
+	CodeMissingAPIKey        Code = "missing_api_key"        // 401: no Authorization header
+	CodeInvalidAPIKey        Code = "invalid_api_key"        // 401: malformed or unknown key
+	CodeInvalidAdminKey      Code = "invalid_admin_key"      // 401: wrong or missing X-Admin-Key on /admin/*
+	CodeAPIKeyInactive       Code = "api_key_inactive"       // 403: key revoked
+	CodeAPIKeyExpired        Code = "api_key_expired"        // 403: key past its expiry
+	CodePathNotAllowed       Code = "path_not_allowed"       // 403: blocked by transform.allowed/blocked_paths
+	CodeModelNotAllowed      Code = "model_not_allowed"      // 403: not in the API key's allowed_models
+	CodeModelNotFound        Code = "model_not_found"        // 404: no target serves the model
+	CodeUnsupportedEndpoint  Code = "unsupported_endpoint"   // 404: the target's provider has no equivalent
+	CodeNotFound             Code = "not_found"              // 404: the admin API resource doesn't exist
+	CodeMethodNotAllowed     Code = "method_not_allowed"     // 405: the endpoint doesn't accept the method
+	CodeIdempotencyConflict  Code = "idempotency_conflict"   // 409: the first request with the key is running or failed
+	CodeConflict             Code = "conflict"               // 409: the admin API change clashes with the current state
+	CodeIdempotencyKeyReused Code = "idempotency_key_reused" // 422: the key was used for a different request
+	CodeQuotaExceeded        Code = "quota_exceeded"         // 429: the API key's quota is used up
+	CodeRateLimitExceeded    Code = "rate_limit_exceeded"    // 429: ratelimit.requests_per_second exceeded
+	CodeInternalError        Code = "internal_error"         // 500: a fault in Relay itself
+	CodeUpstreamError        Code = "upstream_error"         // 502: the upstream failed; also marks upstream errors without a code
+	CodeNoHealthyUpstream    Code = "no_healthy_upstream"    // 503: every load balancer target is down
+	CodeCircuitOpen          Code = "circuit_open"           // 503: the upstream's circuit breaker is open
+	CodeNotEnabled           Code = "not_enabled"            // 503: the admin API feature isn't configured
+	CodeUpstreamTimeout      Code = "upstream_timeout"       // 504: the upstream didn't answer in time
+)
+
+var catalogue = map[Code]struct {
+	status  int
+	errType string
+}{
+	CodeInvalidRequest:       {http.StatusBadRequest, "invalid_request_error"},
+	CodeMissingAPIKey:        {http.StatusUnauthorized, "authentication_error"},
+	CodeInvalidAPIKey:        {http.StatusUnauthorized, "authentication_error"},
+	CodeInvalidAdminKey:      {http.StatusUnauthorized, "authentication_error"},
+	CodeAPIKeyInactive:       {http.StatusForbidden, "permission_error"},
+	CodeAPIKeyExpired:        {http.StatusForbidden, "permission_error"},
+	CodePathNotAllowed:       {http.StatusForbidden, "permission_error"},
+	CodeModelNotAllowed:      {http.StatusForbidden, "permission_error"},
+	CodeModelNotFound:        {http.StatusNotFound, "invalid_request_error"},
+	CodeUnsupportedEndpoint:  {http.StatusNotFound, "invalid_request_error"},
+	CodeNotFound:             {http.StatusNotFound, "invalid_request_error"},
+	CodeMethodNotAllowed:     {http.StatusMethodNotAllowed, "invalid_request_error"},
+	CodeIdempotencyConflict:  {http.StatusConflict, "invalid_request_error"},
+	CodeConflict:             {http.StatusConflict, "invalid_request_error"},
+	CodeIdempotencyKeyReused: {http.StatusUnprocessableEntity, "invalid_request_error"},
+	CodeQuotaExceeded:        {http.StatusTooManyRequests, "insufficient_quota"},
+	CodeRateLimitExceeded:    {http.StatusTooManyRequests, "rate_limit_error"},
+	CodeInternalError:        {http.StatusInternalServerError, "server_error"},
+	CodeUpstreamError:        {http.StatusBadGateway, "server_error"},
+	CodeNoHealthyUpstream:    {http.StatusServiceUnavailable, "server_error"},
+	CodeCircuitOpen:          {http.StatusServiceUnavailable, "server_error"},
+	CodeNotEnabled:           {http.StatusServiceUnavailable, "server_error"},
+	CodeUpstreamTimeout:      {http.StatusGatewayTimeout, "server_error"},
+}
+
+// Status returns the HTTP status for a code
+func (c Code) Status() int {
+	if e, ok := catalogue[c]; ok {
+		return e.status
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is the object inside the envelope. Code and Param are null when
+// unset, as in OpenAI's responses; upstream codes may be any JSON value.
+type Error struct {
+	Message   string      `json:"message"`
+	Type      string      `json:"type"`
+	Code      interface{} `json:"code"`
+	Param     interface{} `json:"param"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// Envelope is the error response body
+type Envelope struct {
+	Error *Error `json:"error"`
+}
+
+// New builds the error for a catalogued code
+func New(code Code, message string) *Error {
+	errType := TypeForStatus(code.Status())
+	if e, ok := catalogue[code]; ok {
+		errType = e.errType
+	}
+	return &Error{Message: message, Type: errType, Code: string(code)}
+}
+
+// TypeForStatus picks the OpenAI error type for an HTTP status
+func TypeForStatus(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status < 500:
+		return "invalid_request_error"
+	}
+	return "server_error"
+}
+
+// Marshal encodes e in the envelope
+func Marshal(e *Error) []byte {
+	out, _ := json.Marshal(Envelope{Error: e})
+	return out
+}
+
+// Write sends a catalogued error, tagged with the request's ID
+func Write(w http.ResponseWriter, r *http.Request, code Code, message string) {
+	e := New(code, message)
+	e.RequestID = RequestID(r.Context())
+	WriteError(w, code.Status(), e)
+}
+
+// WriteError sends e with the given status
+func WriteError(w http.ResponseWriter, status int, e *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	w.Write(Marshal(e))
+}
@@ -0,0 +1,31 @@
+package apierror
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header carries the request ID on every response. It is separate from the
+// upstream's own X-Request-Id, which is passed through for its support team.
+const Header = "X-Relay-Request-Id"
+
+type contextKey struct{}
+
+// WithRequestID stores the request ID in ctx
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// RequestID returns the ID stored by WithRequestID, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random ID such as req_1a2b...
+func NewRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "req_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrMiss is returned by Backend.Get when a key is not cached
+var ErrMiss = errors.New("cache: miss")
+
+// Backend stores cached responses. Implementations: RedisBackend (shared),
+// LRU (in-process) and Tiered (LRU in front of Redis).
+type Backend interface {
+	// Get returns a cached body and its metadata, or ErrMiss. Metadata is nil
+	// for entries written before it was recorded.
+	Get(ctx context.Context, key string) (*EntryMeta, []byte, error)
+	// Set stores a body under meta.Key for ttl
+	Set(ctx context.Context, meta EntryMeta, body []byte, ttl time.Duration) error
+	// RecordLookup counts a hit or miss for the hit ratio
+	RecordLookup(ctx context.Context, hit bool)
+	// Stats summarises the entries and hit ratio
+	Stats(ctx context.Context) (*ResponseStats, error)
+	// Purge deletes matching entries and returns how many were removed
+	Purge(ctx context.Context, f PurgeFilter) (int, error)
+}
+
+// EntryMeta describes a cached response so entries can be inspected and purged
+type EntryMeta struct {
+	Key       string    `json:"key"` // Content hash, without the cache: prefix
+	Path      string    `json:"path"`
+	Model     string    `json:"model,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Size      int       `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ResponseStats summarises the response cache
+type ResponseStats struct {
+	Backend   string                     `json:"backend"`
+	Hits      int64                      `json:"hits"`
+	Misses    int64                      `json:"misses"`
+	HitRatio  float64                    `json:"hit_ratio"`
+	Entries   int64                      `json:"entries"`
+	SizeBytes int64                      `json:"size_bytes"`
+	ByModel   map[string]*ResponseBucket `json:"by_model"`
+	ByTenant  map[string]*ResponseBucket `json:"by_tenant,omitempty"`
+	Oldest    *time.Time                 `json:"oldest,omitempty"`
+	L1        *ResponseStats             `json:"l1,omitempty"` // Local tier of a tiered cache
+}
+
+// ResponseBucket counts entries of one model or tenant
+type ResponseBucket struct {
+	Entries   int64 `json:"entries"`
+	SizeBytes int64 `json:"size_bytes"`
+}
+
+// PurgeFilter selects cache entries to delete. Filters combine with AND;
+// entries stored without metadata are only removed by Key or All.
+type PurgeFilter struct {
+	Key       string
+	Model     string
+	Tenant    string
+	OlderThan time.Duration
+	All       bool
+}
+
+// Empty reports whether the filter would match nothing specific
+func (f PurgeFilter) Empty() bool {
+	return f.Key == "" && f.Model == "" && f.Tenant == "" && f.OlderThan <= 0 && !f.All
+}
+
+// matches reports whether an entry (other than by key) is selected by the filter
+func (f PurgeFilter) matches(meta *EntryMeta, now time.Time) bool {
+	if f.All {
+		return true
+	}
+	if meta == nil {
+		return false
+	}
+	if f.Model != "" && meta.Model != f.Model {
+		return false
+	}
+	if f.Tenant != "" && meta.Tenant != f.Tenant {
+		return false
+	}
+	if f.OlderThan > 0 && !meta.CreatedAt.Before(now.Add(-f.OlderThan)) {
+		return false
+	}
+	return true
+}
+
+func newResponseStats(backend string) *ResponseStats {
+	return &ResponseStats{
+		Backend:  backend,
+		ByModel:  make(map[string]*ResponseBucket),
+		ByTenant: make(map[string]*ResponseBucket),
+	}
+}
+
+// add counts one entry; meta may be nil for entries without metadata
+func (s *ResponseStats) add(meta *EntryMeta) {
+	s.Entries++
+	if meta == nil {
+		return
+	}
+	s.SizeBytes += int64(meta.Size)
+	addBucket(s.ByModel, meta.Model, meta.Size)
+	if meta.Tenant != "" {
+		addBucket(s.ByTenant, meta.Tenant, meta.Size)
+	}
+	if s.Oldest == nil || meta.CreatedAt.Before(*s.Oldest) {
+		created := meta.CreatedAt
+		s.Oldest = &created
+	}
+}
+
+func (s *ResponseStats) setCounters(hits, misses int64) {
+	s.Hits = hits
+	s.Misses = misses
+	if total := hits + misses; total > 0 {
+		s.HitRatio = float64(hits) / float64(total)
+	}
+}
+
+func addBucket(buckets map[string]*ResponseBucket, name string, size int) {
+	b, ok := buckets[name]
+	if !ok {
+		b = &ResponseBucket{}
+		buckets[name] = b
+	}
+	b.Entries++
+	b.SizeBytes += int64(size)
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressMagic prefixes compressed values, followed by one codec byte. The
+// leading NUL never starts JSON or an entry envelope, so values written before
+// compression was enabled are returned as they are.
+var compressMagic = []byte{0, 'R', 'L', 'Z'}
+
+const (
+	codecZstd byte = 'z'
+	codecGzip byte = 'g'
+)
+
+// Compressor shrinks values before they are written to Redis or memory.
+// Values smaller than the minimum size, or that do not shrink, are stored raw.
+type Compressor struct {
+	codec   byte
+	minSize int
+	store   string // Metrics label, e.g. "cache" or "log"
+	zenc    *zstd.Encoder
+}
+
+// NewCompressor returns a compressor for algorithm ("zstd" or "gzip"), or nil
+// for "" and "none". Compress on a nil Compressor is a no-op.
+func NewCompressor(algorithm string, minSize int, store string) (*Compressor, error) {
+	c := &Compressor{minSize: minSize, store: store}
+	switch algorithm {
+	case "", "none":
+		return nil, nil
+	case "zstd":
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		c.codec = codecZstd
+		c.zenc = enc
+	case "gzip":
+		c.codec = codecGzip
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %q (use zstd, gzip or none)", algorithm)
+	}
+	return c, nil
+}
+
+// Compress returns data in its stored form
+func (c *Compressor) Compress(data []byte) []byte {
+	if c == nil {
+		return data
+	}
+	if len(data) < c.minSize {
+		compressionRecord(c.store, len(data), len(data))
+		return data
+	}
+
+	out := append([]byte(nil), compressMagic...)
+	out = append(out, c.codec)
+	switch c.codec {
+	case codecZstd:
+		out = c.zenc.EncodeAll(data, out)
+	case codecGzip:
+		buf := bytes.NewBuffer(out)
+		zw := gzip.NewWriter(buf)
+		zw.Write(data)
+		zw.Close()
+		out = buf.Bytes()
+	}
+
+	// Already-compressed or tiny payloads can grow; keep those raw
+	if len(out) >= len(data) {
+		compressionRecord(c.store, len(data), len(data))
+		return data
+	}
+	compressionRecord(c.store, len(data), len(out))
+	compressionRatio.WithLabelValues(c.store).Observe(float64(len(data)) / float64(len(out)))
+	return out
+}
+
+var (
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderErr  error
+)
+
+// Decompress reverses Compress. Values without the marker are returned unchanged.
+func Decompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, compressMagic) || len(data) <= len(compressMagic) {
+		return data, nil
+	}
+
+	payload := data[len(compressMagic)+1:]
+	switch data[len(compressMagic)] {
+	case codecZstd:
+		zstdDecoderOnce.Do(func() {
+			zstdDecoder, zstdDecoderErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+		})
+		if zstdDecoderErr != nil {
+			return nil, zstdDecoderErr
+		}
+		return zstdDecoder.DecodeAll(payload, nil)
+	case codecGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	default:
+		return nil, fmt.Errorf("unknown compression codec %q", data[len(compressMagic)])
+	}
+}
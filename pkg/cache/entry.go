@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// entryMagic prefixes envelope-encoded values. Values without it are bare
+// response bodies written by older versions.
+var entryMagic = []byte("RLYC")
+
+// EntryVersion is the envelope format written by Encode
+const EntryVersion = 1
+
+// Entry is a cached response: enough to replay it faithfully, plus what the
+// original request cost so hits can be counted as savings.
+type Entry struct {
+	Version   int         `json:"v"`
+	Status    int         `json:"status"`
+	Header    http.Header `json:"header,omitempty"`
+	Body      []byte      `json:"-"`
+	CreatedAt time.Time   `json:"created_at"`
+	CostUSD   float64     `json:"cost_usd,omitempty"`
+	TokensIn  int         `json:"tokens_in,omitempty"`
+	TokensOut int         `json:"tokens_out,omitempty"`
+
+	// RequestHash identifies the request an idempotent response belongs to
+	RequestHash string `json:"request_hash,omitempty"`
+}
+
+// Encode writes the envelope as magic, version byte, 4-byte length of the JSON
+// metadata, the metadata, then the raw body
+func (e *Entry) Encode() ([]byte, error) {
+	e.Version = EntryVersion
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(entryMagic)+5+len(meta)+len(e.Body)))
+	buf.Write(entryMagic)
+	buf.WriteByte(EntryVersion)
+	binary.Write(buf, binary.BigEndian, uint32(len(meta)))
+	buf.Write(meta)
+	buf.Write(e.Body)
+	return buf.Bytes(), nil
+}
+
+// DecodeEntry reads a (possibly compressed) envelope, treating legacy values
+// as a 200 JSON body
+func DecodeEntry(data []byte) (*Entry, error) {
+	data, err := Decompress(data)
+	if err != nil {
+		return nil, fmt.Errorf("cache entry: %w", err)
+	}
+	if !bytes.HasPrefix(data, entryMagic) {
+		return &Entry{
+			Status: http.StatusOK,
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   data,
+		}, nil
+	}
+
+	rest := data[len(entryMagic):]
+	if len(rest) < 5 {
+		return nil, fmt.Errorf("cache entry truncated")
+	}
+	if version := int(rest[0]); version != EntryVersion {
+		return nil, fmt.Errorf("unsupported cache entry version %d", version)
+	}
+	metaLen := int(binary.BigEndian.Uint32(rest[1:5]))
+	rest = rest[5:]
+	if metaLen > len(rest) {
+		return nil, fmt.Errorf("cache entry truncated")
+	}
+
+	var e Entry
+	if err := json.Unmarshal(rest[:metaLen], &e); err != nil {
+		return nil, fmt.Errorf("invalid cache entry: %w", err)
+	}
+	e.Body = rest[metaLen:]
+	if e.Status == 0 {
+		e.Status = http.StatusOK
+	}
+	return &e, nil
+}
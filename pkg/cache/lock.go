@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Locker elects one instance to fetch a key while others wait for the result
+type Locker interface {
+	// Acquire takes the lock for key if it is free. release must be called once
+	// the result is stored; the lock also expires after ttl.
+	Acquire(ctx context.Context, key string, ttl time.Duration) (release func(), ok bool, err error)
+	// Held reports whether some instance holds the lock for key
+	Held(ctx context.Context, key string) (bool, error)
+}
+
+// releaseScript deletes the lock only if it still carries our token
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// RedisLocker implements Locker with SET NX locks at cachelock:<key>
+type RedisLocker struct {
+	rdb *Client
+}
+
+// NewRedisLocker creates a Locker shared by all instances using rdb
+func NewRedisLocker(rdb *Client) *RedisLocker {
+	return &RedisLocker{rdb: rdb}
+}
+
+// Acquire implements Locker
+func (l *RedisLocker) Acquire(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	token, err := newEntryID()
+	if err != nil {
+		return nil, false, err
+	}
+
+	lockKey := "cachelock:" + key
+	ok, err := l.rdb.Redis().SetNX(ctx, lockKey, token, ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	release := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		releaseScript.Run(ctx, l.rdb.Redis(), []string{lockKey}, token)
+	}
+	return release, true, nil
+}
+
+// Held implements Locker
+func (l *RedisLocker) Held(ctx context.Context, key string) (bool, error) {
+	n, err := l.rdb.Redis().Exists(ctx, "cachelock:"+key).Result()
+	return n > 0, err
+}
@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LRU is an in-process response cache bounded by total body size (and
+// optionally entry count). The least recently used entries are evicted first.
+type LRU struct {
+	maxBytes   int64
+	maxEntries int
+
+	mu    sync.Mutex
+	ll    *list.List // Front = most recently used
+	items map[string]*list.Element
+	size  int64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type lruEntry struct {
+	meta      EntryMeta
+	body      []byte
+	expiresAt time.Time
+}
+
+// NewLRU creates an in-memory cache holding at most maxBytes of bodies.
+// maxEntries <= 0 means no limit on the number of entries.
+func NewLRU(maxBytes int64, maxEntries int) *LRU {
+	if maxBytes <= 0 {
+		maxBytes = 256 << 20
+	}
+	return &LRU{
+		maxBytes:   maxBytes,
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Get returns a live entry and marks it as recently used
+func (c *LRU) Get(ctx context.Context, key string) (*EntryMeta, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, nil, ErrMiss
+	}
+	e := el.Value.(*lruEntry)
+	if time.Now().After(e.expiresAt) {
+		c.removeLocked(el)
+		return nil, nil, ErrMiss
+	}
+
+	c.ll.MoveToFront(el)
+	meta := e.meta
+	return &meta, e.body, nil
+}
+
+// Set stores an entry, evicting least recently used ones to stay within bounds.
+// Bodies larger than the whole cache are not stored.
+func (c *LRU) Set(ctx context.Context, meta EntryMeta, body []byte, ttl time.Duration) error {
+	if int64(len(body)) > c.maxBytes {
+		return fmt.Errorf("entry of %d bytes exceeds cache size", len(body))
+	}
+
+	meta.Size = len(body)
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = time.Now()
+	}
+	if meta.ExpiresAt.IsZero() {
+		meta.ExpiresAt = meta.CreatedAt.Add(ttl)
+	}
+	entry := &lruEntry{meta: meta, body: body, expiresAt: time.Now().Add(ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[meta.Key]; ok {
+		c.removeLocked(el)
+	}
+	c.items[meta.Key] = c.ll.PushFront(entry)
+	c.size += int64(len(body))
+
+	for c.size > c.maxBytes || (c.maxEntries > 0 && c.ll.Len() > c.maxEntries) {
+		c.removeLocked(c.ll.Back())
+	}
+	return nil
+}
+
+// RecordLookup counts a hit or miss for this instance
+func (c *LRU) RecordLookup(ctx context.Context, hit bool) {
+	if hit {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+// Stats summarises live entries and this instance's hit ratio
+func (c *LRU) Stats(ctx context.Context) (*ResponseStats, error) {
+	stats := newResponseStats("memory")
+	stats.setCounters(c.hits.Load(), c.misses.Load())
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*lruEntry)
+		if now.After(e.expiresAt) {
+			continue
+		}
+		meta := e.meta
+		stats.add(&meta)
+	}
+	return stats, nil
+}
+
+// Purge deletes matching entries and returns how many were removed
+func (c *LRU) Purge(ctx context.Context, f PurgeFilter) (int, error) {
+	if f.Empty() {
+		return 0, fmt.Errorf("purge filter is empty")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f.Key != "" {
+		el, ok := c.items[f.Key]
+		if !ok {
+			return 0, nil
+		}
+		c.removeLocked(el)
+		return 1, nil
+	}
+
+	now := time.Now()
+	purged := 0
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*lruEntry)
+		if f.matches(&e.meta, now) {
+			c.removeLocked(el)
+			purged++
+		}
+		el = next
+	}
+	return purged, nil
+}
+
+func (c *LRU) removeLocked(el *list.Element) {
+	e := c.ll.Remove(el).(*lruEntry)
+	delete(c.items, e.meta.Key)
+	c.size -= int64(len(e.body))
+}
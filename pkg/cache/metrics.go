@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	compressionInputBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "relay_compression_input_bytes_total",
+		Help: "Bytes of cache entries and logs before compression",
+	}, []string{"store"})
+	compressionOutputBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "relay_compression_output_bytes_total",
+		Help: "Bytes of cache entries and logs as stored, after compression",
+	}, []string{"store"})
+	compressionRatio = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "relay_compression_ratio",
+		Help:    "Original to compressed size ratio of compressed values",
+		Buckets: []float64{1.25, 1.5, 2, 3, 4, 6, 8, 12, 16, 32},
+	}, []string{"store"})
+)
+
+func compressionRecord(store string, in, out int) {
+	compressionInputBytes.WithLabelValues(store).Add(float64(in))
+	compressionOutputBytes.WithLabelValues(store).Add(float64(out))
+}
@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	responsePrefix     = "cache:"
+	responseMetaPrefix = "cachemeta:"
+	responseStatsKey   = "cachestats"
+)
+
+// RedisBackend keeps responses in Redis so every relay instance shares them.
+// Bodies live at cache:<key>, metadata at cachemeta:<key> with the same TTL.
+type RedisBackend struct {
+	rdb *Client
+}
+
+// NewRedisBackend creates a response cache on top of a Redis client
+func NewRedisBackend(rdb *Client) *RedisBackend {
+	return &RedisBackend{rdb: rdb}
+}
+
+// Set stores a response body and its metadata with the same lifetime
+func (b *RedisBackend) Set(ctx context.Context, meta EntryMeta, body []byte, ttl time.Duration) error {
+	meta.Size = len(body)
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = time.Now()
+	}
+	meta.ExpiresAt = meta.CreatedAt.Add(ttl)
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	pipe := b.rdb.Redis().TxPipeline()
+	pipe.Set(ctx, responsePrefix+meta.Key, body, ttl)
+	pipe.Set(ctx, responseMetaPrefix+meta.Key, data, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// RecordLookup counts a cache hit or miss for the shared hit ratio
+func (b *RedisBackend) RecordLookup(ctx context.Context, hit bool) {
+	field := "misses"
+	if hit {
+		field = "hits"
+	}
+	b.rdb.Redis().HIncrBy(ctx, responseStatsKey, field, 1)
+}
+
+// Get returns a cached body and its metadata
+func (b *RedisBackend) Get(ctx context.Context, key string) (*EntryMeta, []byte, error) {
+	values, err := b.rdb.Redis().MGet(ctx, responsePrefix+key, responseMetaPrefix+key).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, ok := values[0].(string)
+	if !ok {
+		return nil, nil, ErrMiss
+	}
+	data, ok := values[1].(string)
+	if !ok {
+		return nil, []byte(body), nil
+	}
+
+	var meta EntryMeta
+	if err := json.Unmarshal([]byte(data), &meta); err != nil {
+		return nil, []byte(body), nil
+	}
+	return &meta, []byte(body), nil
+}
+
+// Stats scans the cache and combines entry sizes with the hit counters
+func (b *RedisBackend) Stats(ctx context.Context) (*ResponseStats, error) {
+	stats := newResponseStats("redis")
+
+	counters, err := b.rdb.Redis().HGetAll(ctx, responseStatsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	var hits, misses int64
+	fmt.Sscan(counters["hits"], &hits)
+	fmt.Sscan(counters["misses"], &misses)
+	stats.setCounters(hits, misses)
+
+	err = b.scan(ctx, func(metas []*EntryMeta, keys []string) error {
+		for _, meta := range metas {
+			stats.add(meta)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Purge deletes matching entries and returns how many were removed
+func (b *RedisBackend) Purge(ctx context.Context, f PurgeFilter) (int, error) {
+	if f.Empty() {
+		return 0, fmt.Errorf("purge filter is empty")
+	}
+
+	if f.Key != "" {
+		n, err := b.rdb.Redis().Del(ctx, responsePrefix+f.Key, responseMetaPrefix+f.Key).Result()
+		if err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	now := time.Now()
+	purged := 0
+	err := b.scan(ctx, func(metas []*EntryMeta, keys []string) error {
+		var del []string
+		for i, meta := range metas {
+			if !f.matches(meta, now) {
+				continue
+			}
+			del = append(del, responsePrefix+keys[i], responseMetaPrefix+keys[i])
+			purged++
+		}
+		if len(del) == 0 {
+			return nil
+		}
+		return b.rdb.Redis().Del(ctx, del...).Err()
+	})
+	return purged, err
+}
+
+// scan walks all cache entries in batches, loading their metadata
+func (b *RedisBackend) scan(ctx context.Context, fn func(metas []*EntryMeta, keys []string) error) error {
+	var cursor uint64
+	for {
+		redisKeys, next, err := b.rdb.Redis().Scan(ctx, cursor, responsePrefix+"*", 500).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(redisKeys) > 0 {
+			keys := make([]string, len(redisKeys))
+			metaKeys := make([]string, len(redisKeys))
+			for i, k := range redisKeys {
+				keys[i] = k[len(responsePrefix):]
+				metaKeys[i] = responseMetaPrefix + keys[i]
+			}
+
+			values, err := b.rdb.Redis().MGet(ctx, metaKeys...).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			metas := make([]*EntryMeta, len(values))
+			for i, v := range values {
+				s, ok := v.(string)
+				if !ok {
+					continue
+				}
+				var meta EntryMeta
+				if json.Unmarshal([]byte(s), &meta) == nil {
+					metas[i] = &meta
+				}
+			}
+
+			if err := fn(metas, keys); err != nil {
+				return err
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
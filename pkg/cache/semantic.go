@@ -0,0 +1,310 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SemanticIndex is a brute-force nearest-neighbour index over prompt embeddings.
+// Vectors are persisted in Redis (one hash per partition) so every instance
+// shares them; each instance keeps an in-process copy that is reloaded periodically.
+type SemanticIndex struct {
+	rdb        *Client
+	ttl        time.Duration
+	maxEntries int
+	reload     time.Duration
+
+	mu         sync.Mutex
+	partitions map[string]*semanticPartition
+}
+
+type semanticPartition struct {
+	mu       sync.RWMutex
+	entries  []semanticEntry
+	loadedAt time.Time
+}
+
+type semanticEntry struct {
+	ID        string    `json:"id"`
+	Vector    string    `json:"vector"` // base64 little-endian float32, unit length
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // Zero for the index TTL
+
+	vec []float32
+}
+
+// SemanticMatch is the closest cached prompt found for a query
+type SemanticMatch struct {
+	ID         string
+	Similarity float64
+	Body       []byte
+}
+
+// NewSemanticIndex creates an index whose entries live for ttl, keeping at most
+// maxEntries per partition (oldest evicted first)
+func NewSemanticIndex(rdb *Client, ttl time.Duration, maxEntries int) *SemanticIndex {
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+	return &SemanticIndex{
+		rdb:        rdb,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		reload:     30 * time.Second,
+		partitions: make(map[string]*semanticPartition),
+	}
+}
+
+// Search returns the most similar live entry in the partition if it reaches threshold
+func (idx *SemanticIndex) Search(ctx context.Context, partition string, vector []float32, threshold float64) (*SemanticMatch, bool) {
+	p, err := idx.partition(ctx, partition)
+	if err != nil {
+		return nil, false
+	}
+
+	query := normalize(vector)
+	now := time.Now()
+	cutoff := now.Add(-idx.ttl)
+
+	p.mu.RLock()
+	var best *semanticEntry
+	bestScore := -1.0
+	for i := range p.entries {
+		e := &p.entries[i]
+		if e.expired(now, cutoff) || len(e.vec) != len(query) {
+			continue
+		}
+		if score := dot(query, e.vec); score > bestScore {
+			bestScore = score
+			best = e
+		}
+	}
+	p.mu.RUnlock()
+
+	if best == nil || bestScore < threshold {
+		return nil, false
+	}
+
+	body, err := idx.rdb.Get(ctx, semanticBodyKey(best.ID))
+	if err != nil {
+		// Body expired before the vector was pruned
+		idx.remove(ctx, partition, best.ID)
+		return nil, false
+	}
+
+	return &SemanticMatch{ID: best.ID, Similarity: bestScore, Body: body}, true
+}
+
+// Add stores a response body under the embedding of its prompt for ttl, which
+// is capped at the index's own lifetime (0 uses it)
+func (idx *SemanticIndex) Add(ctx context.Context, partition string, vector []float32, body []byte, ttl time.Duration) error {
+	p, err := idx.partition(ctx, partition)
+	if err != nil {
+		return err
+	}
+
+	id, err := newEntryID()
+	if err != nil {
+		return err
+	}
+
+	if ttl <= 0 || ttl > idx.ttl {
+		ttl = idx.ttl
+	}
+	vec := normalize(vector)
+	now := time.Now()
+	entry := semanticEntry{
+		ID:        id,
+		Vector:    encodeVector(vec),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		vec:       vec,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := idx.rdb.Set(ctx, semanticBodyKey(id), body, ttl); err != nil {
+		return err
+	}
+	hashKey := semanticIndexKey(partition)
+	if err := idx.rdb.Redis().HSet(ctx, hashKey, id, data).Err(); err != nil {
+		return err
+	}
+	idx.rdb.Redis().Expire(ctx, hashKey, idx.ttl)
+
+	p.mu.Lock()
+	p.entries = append(p.entries, entry)
+	evicted := p.evictLocked(idx.maxEntries, time.Now().Add(-idx.ttl))
+	p.mu.Unlock()
+
+	if len(evicted) > 0 {
+		idx.rdb.Redis().HDel(ctx, hashKey, evicted...)
+	}
+	return nil
+}
+
+// partition returns the in-process copy of a partition, (re)loading it from Redis when stale
+func (idx *SemanticIndex) partition(ctx context.Context, name string) (*semanticPartition, error) {
+	idx.mu.Lock()
+	p, ok := idx.partitions[name]
+	if !ok {
+		p = &semanticPartition{}
+		idx.partitions[name] = p
+	}
+	idx.mu.Unlock()
+
+	p.mu.RLock()
+	fresh := time.Since(p.loadedAt) < idx.reload
+	p.mu.RUnlock()
+	if fresh {
+		return p, nil
+	}
+
+	raw, err := idx.rdb.Redis().HGetAll(ctx, semanticIndexKey(name)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]semanticEntry, 0, len(raw))
+	for _, data := range raw {
+		var e semanticEntry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			continue
+		}
+		if e.vec, err = decodeVector(e.Vector); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	p.mu.Lock()
+	p.entries = entries
+	p.loadedAt = time.Now()
+	evicted := p.evictLocked(idx.maxEntries, time.Now().Add(-idx.ttl))
+	p.mu.Unlock()
+
+	if len(evicted) > 0 {
+		idx.rdb.Redis().HDel(ctx, semanticIndexKey(name), evicted...)
+	}
+	return p, nil
+}
+
+func (idx *SemanticIndex) remove(ctx context.Context, partition, id string) {
+	idx.rdb.Redis().HDel(ctx, semanticIndexKey(partition), id)
+
+	idx.mu.Lock()
+	p, ok := idx.partitions[partition]
+	idx.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	p.mu.Lock()
+	for i := range p.entries {
+		if p.entries[i].ID == id {
+			p.entries = append(p.entries[:i], p.entries[i+1:]...)
+			break
+		}
+	}
+	p.mu.Unlock()
+}
+
+// expired reports whether the entry outlived its own TTL or the index's
+func (e *semanticEntry) expired(now, cutoff time.Time) bool {
+	return e.CreatedAt.Before(cutoff) || (!e.ExpiresAt.IsZero() && now.After(e.ExpiresAt))
+}
+
+// evictLocked drops expired entries and the oldest ones beyond max, returning their IDs
+func (p *semanticPartition) evictLocked(max int, cutoff time.Time) []string {
+	now := time.Now()
+	sort.Slice(p.entries, func(i, j int) bool {
+		return p.entries[i].CreatedAt.Before(p.entries[j].CreatedAt)
+	})
+
+	var evicted []string
+	keep := p.entries[:0]
+	for i, e := range p.entries {
+		if e.expired(now, cutoff) || len(p.entries)-i > max {
+			evicted = append(evicted, e.ID)
+			continue
+		}
+		keep = append(keep, e)
+	}
+	p.entries = keep
+	return evicted
+}
+
+func semanticIndexKey(partition string) string {
+	return fmt.Sprintf("semcache:idx:%s", partition)
+}
+
+func semanticBodyKey(id string) string {
+	return fmt.Sprintf("semcache:resp:%s", id)
+}
+
+func newEntryID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	norm := math.Sqrt(sum)
+	out := make([]float32, len(v))
+	if norm == 0 {
+		return out
+	}
+	for i, x := range v {
+		out[i] = float32(float64(x) / norm)
+	}
+	return out
+}
+
+// dot of two unit vectors is their cosine similarity
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+func encodeVector(v []float32) string {
+	buf := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(x))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+func decodeVector(s string) ([]float32, error) {
+	buf, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(buf)%4 != 0 {
+		return nil, fmt.Errorf("invalid vector encoding")
+	}
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:]))
+	}
+	return v, nil
+}
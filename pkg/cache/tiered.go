@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// purgeChannel announces purges so every instance drops its L1 copies
+const purgeChannel = "cache:purged"
+
+// ErrPurgeNotAnnounced is returned with the purged count when the other
+// instances could not be told to drop their L1 copies
+var ErrPurgeNotAnnounced = errors.New("purge not announced to other instances")
+
+// Tiered serves hot entries from a local L1 and falls back to a shared L2.
+// Purges are announced over Redis pub/sub (see SharePurges) so other instances
+// drop their L1 copies too; l1TTL bounds how long a copy can outlive a purge
+// whose announcement was missed.
+type Tiered struct {
+	l1    Backend
+	l2    Backend
+	l1TTL time.Duration
+	rdb   *Client // Announces purges; nil keeps them to this instance
+}
+
+// NewTiered puts l1 (usually an LRU) in front of l2 (usually Redis)
+func NewTiered(l1, l2 Backend, l1TTL time.Duration) *Tiered {
+	if l1TTL <= 0 {
+		l1TTL = time.Minute
+	}
+	return &Tiered{l1: l1, l2: l2, l1TTL: l1TTL}
+}
+
+// Get checks L1 first, then L2, copying L2 hits into L1
+func (t *Tiered) Get(ctx context.Context, key string) (*EntryMeta, []byte, error) {
+	meta, body, err := t.l1.Get(ctx, key)
+	if err == nil {
+		t.l1.RecordLookup(ctx, true)
+		return meta, body, nil
+	}
+	t.l1.RecordLookup(ctx, false)
+
+	meta, body, err = t.l2.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if meta != nil {
+		if ttl := t.localTTL(time.Until(meta.ExpiresAt)); ttl > 0 {
+			t.l1.Set(ctx, *meta, body, ttl)
+		}
+	}
+	return meta, body, nil
+}
+
+// Set writes through to both tiers
+func (t *Tiered) Set(ctx context.Context, meta EntryMeta, body []byte, ttl time.Duration) error {
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = time.Now()
+	}
+	meta.ExpiresAt = meta.CreatedAt.Add(ttl)
+
+	t.l1.Set(ctx, meta, body, t.localTTL(ttl))
+	return t.l2.Set(ctx, meta, body, ttl)
+}
+
+// RecordLookup counts the overall outcome in the shared tier
+func (t *Tiered) RecordLookup(ctx context.Context, hit bool) {
+	t.l2.RecordLookup(ctx, hit)
+}
+
+// Stats reports L2 with the local tier nested under L1
+func (t *Tiered) Stats(ctx context.Context) (*ResponseStats, error) {
+	stats, err := t.l2.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats.Backend = "tiered"
+	if l1, err := t.l1.Stats(ctx); err == nil {
+		stats.L1 = l1
+	}
+	return stats, nil
+}
+
+// Purge removes entries from both tiers and tells the other instances to
+// drop their L1 copies, reporting the L2 count
+func (t *Tiered) Purge(ctx context.Context, f PurgeFilter) (int, error) {
+	t.l1.Purge(ctx, f)
+	n, err := t.l2.Purge(ctx, f)
+	if err != nil {
+		return n, err
+	}
+	if t.rdb == nil {
+		return n, ErrPurgeNotAnnounced
+	}
+	data, err := json.Marshal(f)
+	if err == nil {
+		err = t.rdb.Redis().Publish(ctx, purgeChannel, data).Err()
+	}
+	if err != nil {
+		return n, fmt.Errorf("%w: %v", ErrPurgeNotAnnounced, err)
+	}
+	return n, nil
+}
+
+// SharePurges subscribes to the purges of every instance using rdb and
+// announces this one's, until ctx is done. Call it before serving requests.
+func (t *Tiered) SharePurges(ctx context.Context, rdb *Client) error {
+	t.rdb = rdb
+	sub := rdb.Redis().Subscribe(ctx, purgeChannel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return fmt.Errorf("subscribe to cache purges: %w", err)
+	}
+	go func() {
+		defer sub.Close()
+		purges := sub.Channel()
+		for {
+			select {
+			case msg, ok := <-purges:
+				if !ok {
+					return
+				}
+				var f PurgeFilter
+				if err := json.Unmarshal([]byte(msg.Payload), &f); err != nil || f.Empty() {
+					log.Printf("⚠️ [CACHE] Ignoring invalid purge announcement: %q", msg.Payload)
+					continue
+				}
+				t.l1.Purge(ctx, f)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *Tiered) localTTL(ttl time.Duration) time.Duration {
+	if ttl > t.l1TTL {
+		return t.l1TTL
+	}
+	return ttl
+}
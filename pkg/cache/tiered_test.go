@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestTieredPurgeClearsOtherInstancesL1(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb, err := NewRedis(mr.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("connect to miniredis: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two instances sharing L2, each with its own L1
+	l1a, l1b := NewLRU(1<<20, 0), NewLRU(1<<20, 0)
+	a := NewTiered(l1a, NewRedisBackend(rdb), time.Hour)
+	b := NewTiered(l1b, NewRedisBackend(rdb), time.Hour)
+	for _, tiered := range []*Tiered{a, b} {
+		if err := tiered.SharePurges(ctx, rdb); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	meta := EntryMeta{Key: "k1", Model: "gpt-4o"}
+	if err := a.Set(ctx, meta, []byte(`{"ok":true}`), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Get(ctx, meta.Key); err != nil {
+		t.Fatalf("instance B get: %v", err)
+	}
+	if _, _, err := l1b.Get(ctx, meta.Key); err != nil {
+		t.Fatalf("instance B did not keep an L1 copy: %v", err)
+	}
+
+	if _, err := a.Purge(ctx, PurgeFilter{Model: "gpt-4o"}); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, _, err := l1b.Get(ctx, meta.Key); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("instance B still serves the purged entry from L1")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTieredPurgeWarnsWhenNotAnnounced(t *testing.T) {
+	tiered := NewTiered(NewLRU(1<<20, 0), NewLRU(1<<20, 0), time.Minute)
+	if _, err := tiered.Purge(context.Background(), PurgeFilter{All: true}); !errors.Is(err, ErrPurgeNotAnnounced) {
+		t.Errorf("err = %v, want ErrPurgeNotAnnounced", err)
+	}
+}
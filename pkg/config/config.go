@@ -4,6 +4,7 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
@@ -12,19 +13,24 @@ import (
 // Config holds all the configuration for our application
 // The structure tags (mapstructure) tell Viper which YAML field maps to which Go struct field.
 type Config struct {
-	Server       ServerConfig       `mapstructure:"server"`
-	Proxy        ProxyConfig        `mapstructure:"proxy"`
-	RateLimit    RateLimitConfig    `mapstructure:"ratelimit"`
-	Redis        RedisConfig        `mapstructure:"redis"`
-	Auth         AuthConfig         `mapstructure:"auth"`
-	Logging      LoggingConfig      `mapstructure:"logging"`
-	Transform    TransformConfig    `mapstructure:"transform"`
-	LoadBalancer LoadBalancerConfig `mapstructure:"loadbalancer"`
-	Models       map[string]float64 `mapstructure:"models"`
+	Server       ServerConfig          `mapstructure:"server"`
+	Proxy        ProxyConfig           `mapstructure:"proxy"`
+	RateLimit    RateLimitConfig       `mapstructure:"ratelimit"`
+	Redis        RedisConfig           `mapstructure:"redis"`
+	Auth         AuthConfig            `mapstructure:"auth"`
+	Logging      LoggingConfig         `mapstructure:"logging"`
+	Transform    TransformConfig       `mapstructure:"transform"`
+	LoadBalancer LoadBalancerConfig    `mapstructure:"loadbalancer"`
+	Cache        CacheConfig           `mapstructure:"cache"`
+	Idempotency  IdempotencyConfig     `mapstructure:"idempotency"`
+	Models       map[string]float64    `mapstructure:"models"`
+	Aliases      map[string]ModelAlias `mapstructure:"aliases"`
+	Routes       []RouteConfig         `mapstructure:"routes"`
 }
 
 type ServerConfig struct {
-	Port string `mapstructure:"port"`
+	Port               string `mapstructure:"port"`
+	DisableCompression bool   `mapstructure:"disable_compression"` // Don't gzip responses for clients that accept it
 }
 
 type ProxyConfig struct {
@@ -43,8 +49,63 @@ type AuthConfig struct {
 }
 
 type LoggingConfig struct {
-	Enabled       bool `mapstructure:"enabled"`
-	RetentionDays int  `mapstructure:"retention_days"`
+	Enabled       bool              `mapstructure:"enabled"`
+	RetentionDays int               `mapstructure:"retention_days"`
+	Backend       string            `mapstructure:"backend"` // "redis" (default) or "file"
+	File          FileLogConfig     `mapstructure:"file"`
+	Export        ExportConfig      `mapstructure:"export"`
+	Capture       CaptureConfig     `mapstructure:"capture"`
+	Compression   CompressionConfig `mapstructure:"compression"` // Redis backend only
+}
+
+// CompressionConfig compresses values stored in Redis or memory. Entries
+// written without compression stay readable after it is turned on.
+type CompressionConfig struct {
+	Algorithm string `mapstructure:"algorithm"` // zstd, gzip or none (default)
+	MinBytes  int    `mapstructure:"min_bytes"` // Smaller values are stored raw
+}
+
+// CaptureConfig decides how much of each request/response body is stored.
+type CaptureConfig struct {
+	Mode           string        `mapstructure:"mode"` // metadata, truncated, full, hashed
+	MaxBodyBytes   int           `mapstructure:"max_body_bytes"`
+	Redact         bool          `mapstructure:"redact"`
+	RedactPatterns []string      `mapstructure:"redact_patterns"`
+	Rules          []CaptureRule `mapstructure:"rules"`
+}
+
+type CaptureRule struct {
+	Path         string `mapstructure:"path"`
+	Model        string `mapstructure:"model"`
+	APIKey       string `mapstructure:"api_key"`
+	Mode         string `mapstructure:"mode"`
+	MaxBodyBytes int    `mapstructure:"max_body_bytes"`
+}
+
+// FileLogConfig configures the JSONL file store. It is the primary store when
+// logging.backend is "file", or an extra sink next to Redis when enabled.
+type FileLogConfig struct {
+	Enabled     bool          `mapstructure:"enabled"`
+	Dir         string        `mapstructure:"dir"`
+	MaxSizeMB   int           `mapstructure:"max_size_mb"`
+	RotateEvery time.Duration `mapstructure:"rotate_every"`
+	Compress    bool          `mapstructure:"compress"`
+}
+
+// ExportConfig ships request logs to S3-compatible object storage in gzipped JSONL batches.
+type ExportConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	Endpoint      string        `mapstructure:"endpoint"`
+	Bucket        string        `mapstructure:"bucket"`
+	Region        string        `mapstructure:"region"`
+	AccessKey     string        `mapstructure:"access_key"`
+	SecretKey     string        `mapstructure:"secret_key"`
+	Prefix        string        `mapstructure:"prefix"`
+	BatchSize     int           `mapstructure:"batch_size"`
+	MaxBatchMB    int           `mapstructure:"max_batch_mb"`
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	SpoolDir      string        `mapstructure:"spool_dir"`
+	MaxRetries    int           `mapstructure:"max_retries"`
 }
 
 type TransformConfig struct {
@@ -67,15 +128,148 @@ type TransformRule struct {
 	Replace string      `mapstructure:"replace"`
 }
 
+type CacheConfig struct {
+	Backend              string                   `mapstructure:"backend"` // redis | memory | tiered
+	Memory               MemoryCacheConfig        `mapstructure:"memory"`
+	TTL                  time.Duration            `mapstructure:"ttl"`
+	ModelTTLs            map[string]time.Duration `mapstructure:"model_ttls"`
+	RequireDeterministic bool                     `mapstructure:"require_deterministic"`
+	Key                  CacheKeyConfig           `mapstructure:"key"`
+	Routes               []CacheRouteConfig       `mapstructure:"routes"`
+	Coalesce             CoalesceConfig           `mapstructure:"coalesce"`
+	Headers              []string                 `mapstructure:"headers"` // Upstream headers kept with cached responses
+	Compression          CompressionConfig        `mapstructure:"compression"`
+	Semantic             SemanticCacheConfig      `mapstructure:"semantic"`
+}
+
+// CoalesceConfig collapses concurrent identical requests into one upstream call.
+type CoalesceConfig struct {
+	Enabled     bool          `mapstructure:"enabled"`
+	Distributed bool          `mapstructure:"distributed"` // Coordinate across instances via a Redis lock
+	LockTTL     time.Duration `mapstructure:"lock_ttl"`
+	MaxWait     time.Duration `mapstructure:"max_wait"`
+}
+
+// IdempotencyConfig replays the stored response to POSTs that repeat an Idempotency-Key.
+type IdempotencyConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	TTL     time.Duration `mapstructure:"ttl"` // How long responses are kept for replay
+	LockTTL time.Duration `mapstructure:"lock_ttl"`
+	MaxWait time.Duration `mapstructure:"max_wait"`
+}
+
+// RouteConfig sends requests whose path matches to their own upstream and
+// middleware settings. Routes are tried in order; unset sections inherit the
+// top-level ones, and requests no route matches use the top-level setup.
+type RouteConfig struct {
+	Name        string `mapstructure:"name"`         // Defaults to the path or regex
+	Path        string `mapstructure:"path"`         // Prefix: /anthropic matches /anthropic and /anthropic/...
+	Regex       string `mapstructure:"regex"`        // Used when path is empty
+	StripPrefix string `mapstructure:"strip_prefix"` // Removed before the request is proxied
+
+	Target       string              `mapstructure:"target"` // Single upstream, or:
+	LoadBalancer *LoadBalancerConfig `mapstructure:"loadbalancer"`
+
+	Cache     *RouteCacheConfig `mapstructure:"cache"`
+	Transform *TransformConfig  `mapstructure:"transform"`
+	RateLimit *RateLimitConfig  `mapstructure:"ratelimit"` // Own limit and buckets; hot-reloadable
+	Timeout   time.Duration     `mapstructure:"timeout"`   // Whole request, including streaming
+	Auth      *bool             `mapstructure:"auth"`
+}
+
+// RouteName identifies the route in logs and rate limit buckets
+func (r RouteConfig) RouteName() string {
+	switch {
+	case r.Name != "":
+		return r.Name
+	case r.Path != "":
+		return r.Path
+	}
+	return r.Regex
+}
+
+// RouteCacheConfig is a route's cache policy; it wins over cache.routes
+type RouteCacheConfig struct {
+	Key                  *CacheKeyConfig `mapstructure:"key"`
+	TTL                  time.Duration   `mapstructure:"ttl"`
+	RequireDeterministic *bool           `mapstructure:"require_deterministic"`
+	Disabled             bool            `mapstructure:"disabled"`
+}
+
+// ModelAlias is a virtual model name standing for a concrete model. The
+// defaults are applied only where the request leaves them unset.
+type ModelAlias struct {
+	Model        string   `mapstructure:"model"`
+	Temperature  *float64 `mapstructure:"temperature"`
+	MaxTokens    int      `mapstructure:"max_tokens"`
+	SystemPrompt string   `mapstructure:"system_prompt"` // Added when the chat has no system message
+}
+
+// MemoryCacheConfig sizes the in-process LRU (the whole cache, or L1 when tiered).
+type MemoryCacheConfig struct {
+	MaxSizeMB  int           `mapstructure:"max_size_mb"`
+	MaxEntries int           `mapstructure:"max_entries"`
+	L1TTL      time.Duration `mapstructure:"l1_ttl"` // Tiered only: max lifetime of a local copy
+}
+
+// CacheKeyConfig controls which request components make up the cache key.
+type CacheKeyConfig struct {
+	Namespace     string   `mapstructure:"namespace"` // Defaults to the upstream target
+	IncludeTenant bool     `mapstructure:"include_tenant"`
+	IgnoreFields  []string `mapstructure:"ignore_fields"`
+}
+
+// CacheRouteConfig overrides cache settings for paths matching a regex.
+type CacheRouteConfig struct {
+	Path                 string          `mapstructure:"path"`
+	Key                  *CacheKeyConfig `mapstructure:"key"`
+	TTL                  time.Duration   `mapstructure:"ttl"`
+	RequireDeterministic *bool           `mapstructure:"require_deterministic"`
+	Disabled             bool            `mapstructure:"disabled"`
+}
+
+// SemanticCacheConfig enables embedding-based lookups for near-duplicate prompts.
+type SemanticCacheConfig struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	EmbeddingURL   string        `mapstructure:"embedding_url"`
+	EmbeddingModel string        `mapstructure:"embedding_model"`
+	APIKey         string        `mapstructure:"api_key"`
+	Threshold      float64       `mapstructure:"threshold"`
+	TTL            time.Duration `mapstructure:"ttl"`
+	MaxEntries     int           `mapstructure:"max_entries"` // Per model/tenant partition
+}
+
 type LoadBalancerConfig struct {
-	Enabled  bool                 `mapstructure:"enabled"`
-	Strategy string               `mapstructure:"strategy"`
-	Targets  []LoadBalancerTarget `mapstructure:"targets"`
+	Enabled   bool                 `mapstructure:"enabled"`
+	Strategy  string               `mapstructure:"strategy"`
+	Targets   []LoadBalancerTarget `mapstructure:"targets"`
+	Discovery *DiscoveryConfig     `mapstructure:"discovery"` // Targets found at runtime, added to the static ones
+}
+
+// DiscoveryConfig finds load balancer targets in DNS or a file that changes
+// as a fleet scales
+type DiscoveryConfig struct {
+	Type     string        `mapstructure:"type"`     // dns or file
+	Name     string        `mapstructure:"name"`     // dns: SRV name, e.g. _http._tcp.llm.internal, or a host name with port
+	Port     int           `mapstructure:"port"`     // dns: resolve A/AAAA records of name on this port instead of SRV
+	Path     string        `mapstructure:"path"`     // file: JSON or YAML in Prometheus file_sd format
+	Scheme   string        `mapstructure:"scheme"`   // http (default) or https
+	Interval time.Duration `mapstructure:"interval"` // Refresh period (default 30s)
+
+	// Applied to every discovered target
+	Provider string `mapstructure:"provider"`
+	APIKey   string `mapstructure:"api_key"`
 }
 
 type LoadBalancerTarget struct {
-	URL    string `mapstructure:"url"`
-	Weight int    `mapstructure:"weight"`
+	URL      string `mapstructure:"url"`
+	Weight   int    `mapstructure:"weight"`
+	Provider string `mapstructure:"provider"` // openai (default), gemini, ollama, openai-compatible or azure
+	APIKey   string `mapstructure:"api_key"`
+
+	// Azure OpenAI: model -> deployment name, and the api-version to call
+	Deployments map[string]string `mapstructure:"deployments"`
+	APIVersion  string            `mapstructure:"api_version"`
 }
 type RedisConfig struct {
 	Address  string `mapstructure:"address"`
@@ -86,8 +280,14 @@ type RedisConfig struct {
 
 // Store wraps configuration with thread-safe access and hot-reload updates.
 type Store struct {
-	mu  sync.RWMutex
-	cfg *Config
+	mu        sync.RWMutex
+	cfg       *Config
+	listeners []func(*Config)
+}
+
+// NewStore returns a store holding cfg that no file changes update
+func NewStore(cfg *Config) *Store {
+	return &Store{cfg: cfg}
 }
 
 func (s *Store) Get() *Config {
@@ -103,6 +303,20 @@ func (s *Store) Get() *Config {
 func (s *Store) set(cfg *Config) {
 	s.mu.Lock()
 	s.cfg = cfg
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	for _, fn := range listeners {
+		cpy := *cfg
+		fn(&cpy)
+	}
+}
+
+// OnChange registers fn to be called with each reloaded config, for settings
+// that are built into objects rather than read per request
+func (s *Store) OnChange(fn func(*Config)) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, fn)
 	s.mu.Unlock()
 }
 
@@ -0,0 +1,181 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/config"
+	"github.com/ngoyal88/relay/pkg/proxy"
+)
+
+// defaultInterval is how often targets are rediscovered unless configured
+const defaultInterval = 30 * time.Second
+
+// Source finds the current targets of a load balancer
+type Source interface {
+	Discover(ctx context.Context) ([]proxy.TargetConfig, error)
+}
+
+// NewSource returns the source for a discovery config
+func NewSource(cfg config.DiscoveryConfig) (Source, error) {
+	scheme := strings.ToLower(cfg.Scheme)
+	switch scheme {
+	case "":
+		scheme = "http"
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("unknown discovery scheme %q (use http or https)", cfg.Scheme)
+	}
+	template := proxy.TargetConfig{
+		Provider: cfg.Provider,
+		APIKey:   cfg.APIKey,
+		Source:   "discovery",
+	}
+
+	switch strings.ToLower(cfg.Type) {
+	case "dns":
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("dns discovery requires a name")
+		}
+		return newDNSSource(cfg.Name, cfg.Port, scheme, template), nil
+	case "file":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("file discovery requires a path")
+		}
+		return &fileSource{path: cfg.Path, scheme: scheme, template: template}, nil
+	default:
+		return nil, fmt.Errorf("unknown discovery type %q (use dns or file)", cfg.Type)
+	}
+}
+
+// Watcher keeps the targets of a Source up to date
+type Watcher struct {
+	name     string // For logs
+	source   Source
+	interval time.Duration
+	onChange func()
+
+	mu       sync.RWMutex
+	targets  []proxy.TargetConfig
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewWatcher returns a watcher for cfg; Start runs it
+func NewWatcher(name string, cfg config.DiscoveryConfig, onChange func()) (*Watcher, error) {
+	source, err := NewSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	return &Watcher{
+		name:     name,
+		source:   source,
+		interval: interval,
+		onChange: onChange,
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// Start discovers the targets once, then refreshes them in the background and
+// calls onChange whenever they change. If the first lookup fails or finds
+// nothing, the watcher starts with known and Start reports why; the background
+// refresh runs either way.
+func (w *Watcher) Start(known []proxy.TargetConfig) error {
+	targets, err := w.discover()
+	if err == nil && len(targets) == 0 {
+		err = fmt.Errorf("no targets found")
+	}
+	if err != nil {
+		targets = known
+		err = fmt.Errorf("discovery for %s: %w", w.name, err)
+	}
+	w.mu.Lock()
+	w.targets = targets
+	w.mu.Unlock()
+
+	go w.loop()
+	return err
+}
+
+// Targets returns the last discovered targets
+func (w *Watcher) Targets() []proxy.TargetConfig {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.targets
+}
+
+// Stop ends the background refresh
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+func (w *Watcher) loop() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.stop:
+			return
+		}
+		w.refresh()
+	}
+}
+
+// refresh rediscovers the targets. A failed lookup or an empty result keeps
+// the last targets, whose health checks take any dead ones out of rotation.
+func (w *Watcher) refresh() {
+	targets, err := w.discover()
+	if err != nil {
+		log.Printf("⚠️ [DISCOVERY] %s: keeping %d known targets: %v", w.name, len(w.Targets()), err)
+		return
+	}
+	if len(targets) == 0 {
+		log.Printf("⚠️ [DISCOVERY] %s: no targets found, keeping %d known targets", w.name, len(w.Targets()))
+		return
+	}
+
+	w.mu.Lock()
+	changed := !reflect.DeepEqual(w.targets, targets)
+	w.targets = targets
+	w.mu.Unlock()
+
+	if changed {
+		log.Printf("[DISCOVERY] %s: %d targets", w.name, len(targets))
+		w.onChange()
+	}
+}
+
+// discover runs the source, dropping targets the load balancer would reject
+// and sorting the rest so unchanged sets compare equal
+func (w *Watcher) discover() ([]proxy.TargetConfig, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	found, err := w.source.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]proxy.TargetConfig, 0, len(found))
+	for _, t := range found {
+		if err := proxy.ValidateTargets([]proxy.TargetConfig{t}); err != nil {
+			log.Printf("⚠️ [DISCOVERY] %s: skipping %v", w.name, err)
+			continue
+		}
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].URL < targets[j].URL })
+	return targets, nil
+}
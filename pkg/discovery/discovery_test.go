@@ -0,0 +1,227 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ngoyal88/relay/pkg/config"
+	"github.com/ngoyal88/relay/pkg/proxy"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func discoverFile(t *testing.T, path string) ([]proxy.TargetConfig, error) {
+	t.Helper()
+	source, err := NewSource(config.DiscoveryConfig{Type: "file", Path: path, Provider: "ollama", APIKey: "sk-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source.Discover(context.Background())
+}
+
+func TestFileSourceReadsJSONAndYAML(t *testing.T) {
+	want := []proxy.TargetConfig{
+		{URL: "http://10.0.0.5:8000", Provider: "ollama", APIKey: "sk-test", Source: "discovery"},
+		{URL: "https://gpu-2.internal", Provider: "ollama", APIKey: "sk-test", Source: "discovery"},
+		{URL: "https://10.0.0.6:8000", Provider: "openai-compatible", APIKey: "sk-test", Source: "discovery", Weight: 2},
+	}
+	files := map[string]string{
+		"targets.json": `[
+			{"targets": ["10.0.0.5:8000", "https://gpu-2.internal"]},
+			{"targets": ["10.0.0.6:8000"], "labels": {"__scheme__": "https", "provider": "openai-compatible", "weight": "2"}}
+		]`,
+		"targets.yaml": `
+- targets: ["10.0.0.5:8000", "https://gpu-2.internal"]
+- targets:
+    - 10.0.0.6:8000
+  labels:
+    __scheme__: https
+    provider: openai-compatible
+    weight: "2"
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			got, err := discoverFile(t, writeFile(t, name, content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("targets = %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestFileSourceRejectsBadFiles(t *testing.T) {
+	tests := map[string]string{
+		"bad weight": `[{"targets": ["10.0.0.5:8000"], "labels": {"weight": "heavy"}}]`,
+		"not a list": `{"targets": ["10.0.0.5:8000"]}`,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got, err := discoverFile(t, writeFile(t, "targets.json", content)); err == nil {
+				t.Errorf("no error; targets %+v", got)
+			}
+		})
+	}
+
+	if _, err := discoverFile(t, filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing file: no error")
+	}
+}
+
+// fakeResolver answers DNS lookups from fixed records
+type fakeResolver struct {
+	hosts []string
+	srv   []*net.SRV
+}
+
+func (r fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return r.hosts, nil
+}
+
+func (r fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return name, r.srv, nil
+}
+
+func TestDNSSourceUsesTopPrioritySRVRecords(t *testing.T) {
+	source := newDNSSource("_http._tcp.llm.internal", 0, "http", proxy.TargetConfig{Source: "discovery"})
+	source.resolver = fakeResolver{srv: []*net.SRV{
+		{Target: "gpu-1.internal.", Port: 8000, Priority: 10, Weight: 3},
+		{Target: "gpu-2.internal.", Port: 8001, Priority: 10, Weight: 1},
+		{Target: "backup.internal.", Port: 8000, Priority: 20, Weight: 1},
+	}}
+
+	got, err := source.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []proxy.TargetConfig{
+		{URL: "http://gpu-1.internal:8000", Weight: 3, Source: "discovery"},
+		{URL: "http://gpu-2.internal:8001", Weight: 1, Source: "discovery"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("targets = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestDNSSourceUsesAddressesWithPort(t *testing.T) {
+	source := newDNSSource("llm.internal", 8000, "https", proxy.TargetConfig{})
+	source.resolver = fakeResolver{hosts: []string{"10.0.0.5", "fd00::5"}}
+
+	got, err := source.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []proxy.TargetConfig{{URL: "https://10.0.0.5:8000"}, {URL: "https://[fd00::5]:8000"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("targets = %+v\nwant %+v", got, want)
+	}
+}
+
+// fakeSource returns the next scripted result on each Discover
+type fakeSource struct {
+	results []fakeResult
+	calls   int
+}
+
+type fakeResult struct {
+	targets []proxy.TargetConfig
+	err     error
+}
+
+func (s *fakeSource) Discover(ctx context.Context) ([]proxy.TargetConfig, error) {
+	r := s.results[s.calls]
+	s.calls++
+	return r.targets, r.err
+}
+
+func newTestWatcher(source Source, changes *atomic.Int32) *Watcher {
+	return &Watcher{
+		name:     "test",
+		source:   source,
+		interval: defaultInterval,
+		onChange: func() { changes.Add(1) },
+		stop:     make(chan struct{}),
+	}
+}
+
+func urls(targets []proxy.TargetConfig) string {
+	out := make([]string, 0, len(targets))
+	for _, t := range targets {
+		out = append(out, t.URL)
+	}
+	return strings.Join(out, ",")
+}
+
+func TestWatcherKeepsTargetsWhenLookupsFail(t *testing.T) {
+	a := proxy.TargetConfig{URL: "http://10.0.0.1:8000"}
+	b := proxy.TargetConfig{URL: "http://10.0.0.2:8000"}
+	source := &fakeSource{results: []fakeResult{
+		{targets: []proxy.TargetConfig{b, a}},
+		{err: errors.New("lookup failed")},
+		{},
+		{targets: []proxy.TargetConfig{a, b}},
+		{targets: []proxy.TargetConfig{b, {URL: "http://10.0.0.3:8000", Provider: "unknown"}}},
+	}}
+	var changes atomic.Int32
+	w := newTestWatcher(source, &changes)
+	defer w.Stop()
+
+	// Discovered targets are sorted, so reordered results compare equal
+	if err := w.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name    string
+		want    string
+		changes int32
+	}{
+		{"failed lookup", "http://10.0.0.1:8000,http://10.0.0.2:8000", 0},
+		{"empty result", "http://10.0.0.1:8000,http://10.0.0.2:8000", 0},
+		{"same targets reordered", "http://10.0.0.1:8000,http://10.0.0.2:8000", 0},
+		{"invalid target skipped", "http://10.0.0.2:8000", 1},
+	}
+	for _, step := range steps {
+		w.refresh()
+		if got := urls(w.Targets()); got != step.want || changes.Load() != step.changes {
+			t.Errorf("%s: targets %s, %d changes; want %s, %d", step.name, got, changes.Load(), step.want, step.changes)
+		}
+	}
+}
+
+func TestWatcherStartsWithKnownTargetsWhenFirstLookupFails(t *testing.T) {
+	known := []proxy.TargetConfig{{URL: "http://10.0.0.1:8000"}}
+	for name, first := range map[string]fakeResult{
+		"failed lookup": {err: errors.New("lookup failed")},
+		"empty result":  {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var changes atomic.Int32
+			w := newTestWatcher(&fakeSource{results: []fakeResult{first}}, &changes)
+			defer w.Stop()
+
+			if err := w.Start(known); err == nil {
+				t.Error("no error from a first lookup that found nothing")
+			}
+			if got := w.Targets(); !reflect.DeepEqual(got, known) {
+				t.Errorf("targets = %+v, want %+v", got, known)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ngoyal88/relay/pkg/proxy"
+)
+
+// dnsSource resolves SRV records, or A/AAAA records when a port is configured
+type dnsSource struct {
+	name     string
+	port     int
+	scheme   string
+	template proxy.TargetConfig
+	resolver resolver
+}
+
+// resolver is the part of net.Resolver the DNS source uses
+type resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+func newDNSSource(name string, port int, scheme string, template proxy.TargetConfig) *dnsSource {
+	return &dnsSource{
+		name:     name,
+		port:     port,
+		scheme:   scheme,
+		template: template,
+		resolver: net.DefaultResolver,
+	}
+}
+
+// Discover implements Source
+func (s *dnsSource) Discover(ctx context.Context) ([]proxy.TargetConfig, error) {
+	if s.port > 0 {
+		addrs, err := s.resolver.LookupHost(ctx, s.name)
+		if err != nil {
+			return nil, err
+		}
+		targets := make([]proxy.TargetConfig, 0, len(addrs))
+		for _, addr := range addrs {
+			targets = append(targets, s.target(addr, s.port, 0))
+		}
+		return targets, nil
+	}
+
+	_, records, err := s.resolver.LookupSRV(ctx, "", "", s.name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	// Records come sorted by priority. Only the preferred ones are used: the
+	// others are backups, and health checks handle failures within a priority.
+	targets := make([]proxy.TargetConfig, 0, len(records))
+	for _, srv := range records {
+		if srv.Priority != records[0].Priority {
+			break
+		}
+		targets = append(targets, s.target(strings.TrimSuffix(srv.Target, "."), int(srv.Port), int(srv.Weight)))
+	}
+	return targets, nil
+}
+
+func (s *dnsSource) target(host string, port, weight int) proxy.TargetConfig {
+	t := s.template
+	t.URL = s.scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port))
+	t.Weight = weight
+	return t
+}
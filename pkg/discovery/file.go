@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ngoyal88/relay/pkg/proxy"
+	"go.yaml.in/yaml/v3"
+)
+
+// fileSource reads targets from a file in Prometheus file_sd format, JSON or
+// YAML. The file is reread on every refresh, so it can be replaced atomically.
+//
+//	[{"targets": ["10.0.0.5:8000", "https://gpu-2.internal"],
+//	  "labels": {"__scheme__": "https", "provider": "openai-compatible", "weight": "2"}}]
+type fileSource struct {
+	path     string
+	scheme   string
+	template proxy.TargetConfig
+}
+
+// fileGroup is one entry of a file_sd file
+type fileGroup struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// Discover implements Source
+func (s *fileSource) Discover(ctx context.Context) ([]proxy.TargetConfig, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is valid YAML, so one decoder reads both
+	var groups []fileGroup
+	if err := yaml.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", s.path, err)
+	}
+
+	var targets []proxy.TargetConfig
+	for _, group := range groups {
+		t := s.template
+		scheme := s.scheme
+		if v := group.Labels["__scheme__"]; v != "" {
+			scheme = v
+		}
+		if v := group.Labels["provider"]; v != "" {
+			t.Provider = v
+		}
+		if v := group.Labels["weight"]; v != "" {
+			weight, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("parse %s: invalid weight %q", s.path, v)
+			}
+			t.Weight = weight
+		}
+
+		for _, addr := range group.Targets {
+			t.URL = addr
+			if !strings.Contains(addr, "://") {
+				t.URL = scheme + "://" + addr
+			}
+			targets = append(targets, t)
+		}
+	}
+	return targets, nil
+}
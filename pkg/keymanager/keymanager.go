@@ -23,7 +23,7 @@ func New(rdb *cache.Client) *Manager {
 }
 
 // CreateKey generates a new API key
-func (m *Manager) CreateKey(ctx context.Context, name, userID, description string, rateLimit float64, burst int, quota int64, expiresIn *time.Duration) (*middleware.APIKey, error) {
+func (m *Manager) CreateKey(ctx context.Context, name, userID, teamID, description string, rateLimit float64, burst int, quota int64, expiresIn *time.Duration, allowedModels []string) (*middleware.APIKey, error) {
 	// Generate secure random key
 	keyStr, err := generateSecureKey()
 	if err != nil {
@@ -41,6 +41,7 @@ func (m *Manager) CreateKey(ctx context.Context, name, userID, description strin
 		Key:         keyStr,
 		Name:        name,
 		UserID:      userID,
+		TeamID:      teamID,
 		RateLimit:   rateLimit,
 		Burst:       burst,
 		Quota:       quota,
@@ -49,6 +50,8 @@ func (m *Manager) CreateKey(ctx context.Context, name, userID, description strin
 		CreatedAt:   now,
 		ExpiresAt:   expiresAt,
 		Description: description,
+
+		AllowedModels: allowedModels,
 	}
 
 	// Store in Redis
@@ -112,6 +115,9 @@ func (m *Manager) UpdateKey(ctx context.Context, key string, updates map[string]
 	if desc, ok := updates["description"].(string); ok {
 		apiKey.Description = desc
 	}
+	if models, ok := updates["allowed_models"].([]string); ok {
+		apiKey.AllowedModels = models
+	}
 
 	// Save back
 	keyData := fmt.Sprintf("apikey:%s", key)
@@ -190,11 +196,13 @@ func (m *Manager) RotateKey(ctx context.Context, oldKey string) (*middleware.API
 		ctx,
 		apiKey.Name,
 		apiKey.UserID,
+		apiKey.TeamID,
 		fmt.Sprintf("Rotated from %s", rotatedFrom),
 		apiKey.RateLimit,
 		apiKey.Burst,
 		apiKey.Quota,
 		expiresIn,
+		apiKey.AllowedModels,
 	)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ngoyal88/relay/pkg/config"
+)
+
+const modelAliasContextKey contextKey = "model_alias"
+
+// ModelAliases resolves virtual model names (aliases: in the live config) to the
+// model they stand for, filling in the alias's default parameters the request
+// leaves unset. Aliases don't chain. The alias is kept in the context for cost
+// tracking, logs and allowed_models; must run outside TokenCostLogger and
+// RequestLoggingMiddleware so they see the resolved model.
+func ModelAliases(cfgStore *config.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg := cfgStore.Get()
+			if cfg == nil || len(cfg.Aliases) == 0 || r.Method != http.MethodPost ||
+				!strings.Contains(r.Header.Get("Content-Type"), "json") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			bodyBytes, _ := io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+
+			var payload map[string]interface{}
+			dec := json.NewDecoder(bytes.NewReader(bodyBytes))
+			dec.UseNumber() // Keep numbers exactly as sent
+			if err := dec.Decode(&payload); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			name, _ := payload["model"].(string)
+			alias, ok := cfg.Aliases[name]
+			if !ok || alias.Model == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			applyAlias(payload, alias)
+			resolved, err := json.Marshal(payload)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), modelAliasContextKey, name))
+			r.Body = io.NopCloser(bytes.NewReader(resolved))
+			r.ContentLength = int64(len(resolved))
+			r.Header.Del("Content-Length")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// applyAlias swaps in the alias's model and its defaults
+func applyAlias(payload map[string]interface{}, alias config.ModelAlias) {
+	payload["model"] = alias.Model
+
+	if _, set := payload["temperature"]; !set && alias.Temperature != nil {
+		payload["temperature"] = *alias.Temperature
+	}
+	if alias.MaxTokens > 0 {
+		_, set := payload["max_tokens"]
+		_, setCompletion := payload["max_completion_tokens"]
+		if !set && !setCompletion {
+			payload["max_tokens"] = alias.MaxTokens
+		}
+	}
+
+	messages, ok := payload["messages"].([]interface{})
+	if alias.SystemPrompt == "" || !ok {
+		return
+	}
+	for _, m := range messages {
+		if msg, ok := m.(map[string]interface{}); ok && (msg["role"] == "system" || msg["role"] == "developer") {
+			return
+		}
+	}
+	system := map[string]interface{}{"role": "system", "content": alias.SystemPrompt}
+	payload["messages"] = append([]interface{}{system}, messages...)
+}
+
+// GetModelAliasFromContext returns the alias the client asked for, set by ModelAliases
+func GetModelAliasFromContext(ctx context.Context) (string, bool) {
+	alias, ok := ctx.Value(modelAliasContextKey).(string)
+	return alias, ok
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ngoyal88/relay/pkg/apierror"
 	"github.com/ngoyal88/relay/pkg/cache"
 	"github.com/redis/go-redis/v9"
 )
@@ -17,6 +18,7 @@ type APIKey struct {
 	Key         string     `json:"key"`
 	Name        string     `json:"name"`
 	UserID      string     `json:"user_id"`
+	TeamID      string     `json:"team_id,omitempty"`
 	RateLimit   float64    `json:"rate_limit"` // requests per second
 	Burst       int        `json:"burst"`
 	Quota       int64      `json:"quota"` // total requests allowed
@@ -26,6 +28,22 @@ type APIKey struct {
 	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
 	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
 	Description string     `json:"description,omitempty"`
+
+	// AllowedModels restricts the models the key may use; empty allows all
+	AllowedModels []string `json:"allowed_models,omitempty"`
+}
+
+// AllowsModel reports whether the key may use model
+func (k *APIKey) AllowsModel(model string) bool {
+	if len(k.AllowedModels) == 0 || model == "" {
+		return true
+	}
+	for _, m := range k.AllowedModels {
+		if m == model {
+			return true
+		}
+	}
+	return false
 }
 
 type contextKey string
@@ -48,19 +66,19 @@ func AuthMiddleware(rdb *cache.Client, enableAuth bool) func(http.Handler) http.
 			// Format: "Bearer relay_xxxxxxxxxxxxx"
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
-				respondError(w, "Missing Authorization header", http.StatusUnauthorized)
+				apierror.Write(w, r, apierror.CodeMissingAPIKey, "Missing Authorization header")
 				return
 			}
 
 			parts := strings.SplitN(authHeader, " ", 2)
 			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-				respondError(w, "Invalid Authorization format. Use: Bearer <api_key>", http.StatusUnauthorized)
+				apierror.Write(w, r, apierror.CodeInvalidAPIKey, "Invalid Authorization format. Use: Bearer <api_key>")
 				return
 			}
 
 			apiKeyStr := parts[1]
 			if !strings.HasPrefix(apiKeyStr, "relay_") {
-				respondError(w, "Invalid API key format", http.StatusUnauthorized)
+				apierror.Write(w, r, apierror.CodeInvalidAPIKey, "Invalid API key format")
 				return
 			}
 
@@ -70,25 +88,36 @@ func AuthMiddleware(rdb *cache.Client, enableAuth bool) func(http.Handler) http.
 
 			apiKey, err := validateAPIKey(ctx, rdb, apiKeyStr)
 			if err != nil {
-				respondError(w, fmt.Sprintf("Invalid API key: %v", err), http.StatusUnauthorized)
+				apierror.Write(w, r, apierror.CodeInvalidAPIKey, fmt.Sprintf("Invalid API key: %v", err))
 				return
 			}
 
 			// Check if key is active
 			if !apiKey.Active {
-				respondError(w, "API key is inactive", http.StatusForbidden)
+				apierror.Write(w, r, apierror.CodeAPIKeyInactive, "API key is inactive")
 				return
 			}
 
 			// Check expiration
 			if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
-				respondError(w, "API key has expired", http.StatusForbidden)
+				apierror.Write(w, r, apierror.CodeAPIKeyExpired, "API key has expired")
 				return
 			}
 
 			// Check quota
 			if apiKey.Quota > 0 && apiKey.Used >= apiKey.Quota {
-				respondError(w, "API key quota exceeded", http.StatusTooManyRequests)
+				apierror.Write(w, r, apierror.CodeQuotaExceeded, "API key quota exceeded")
+				return
+			}
+
+			// Check the model; an alias is allowed if either it or its model is
+			model := requestModel(r)
+			alias, aliased := GetModelAliasFromContext(r.Context())
+			if !apiKey.AllowsModel(model) && !(aliased && apiKey.AllowsModel(alias)) {
+				if aliased {
+					model = alias
+				}
+				apierror.Write(w, r, apierror.CodeModelNotAllowed, fmt.Sprintf("API key is not allowed to use model %q", model))
 				return
 			}
 
@@ -98,7 +127,11 @@ func AuthMiddleware(rdb *cache.Client, enableAuth bool) func(http.Handler) http.
 				incrementUsage(ctx, rdb, key)
 			}(apiKeyStr)
 
-			// Store API key in context for downstream middleware
+			// Store API key in context for downstream middleware, and hand it
+			// to the request log outside the route chain
+			if caller, ok := r.Context().Value(logCallerContextKey).(*logCaller); ok {
+				caller.apiKey = apiKey
+			}
 			ctx = context.WithValue(r.Context(), apiKeyContextKey, apiKey)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
@@ -164,6 +197,11 @@ func GetAPIKeyFromContext(ctx context.Context) (*APIKey, bool) {
 	return apiKey, ok
 }
 
+// WithAPIKey returns a context carrying the API key, as AuthMiddleware would set it
+func WithAPIKey(ctx context.Context, apiKey *APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey, apiKey)
+}
+
 // GetTokenCountFromContext returns the token count set by TokenCostLogger.
 func GetTokenCountFromContext(ctx context.Context) (int, bool) {
 	val, ok := ctx.Value(tokenCountContextKey).(int)
@@ -175,11 +213,3 @@ func GetTokenCostFromContext(ctx context.Context) (float64, bool) {
 	val, ok := ctx.Value(tokenCostContextKey).(float64)
 	return val, ok
 }
-
-func respondError(w http.ResponseWriter, message string, status int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{
-		"error": message,
-	})
-}
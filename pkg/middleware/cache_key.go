@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// CacheKeyConfig selects the components of a response cache key
+type CacheKeyConfig struct {
+	Namespace     string   `mapstructure:"namespace"`      // Upstream/provider the responses come from
+	IncludeTenant bool     `mapstructure:"include_tenant"` // Separate entries per team (or user) of the API key
+	IgnoreFields  []string `mapstructure:"ignore_fields"`  // Body fields left out of the key, dotted paths allowed
+}
+
+// buildCacheKey hashes the path, namespace, model, optional tenant and the
+// canonical JSON body (sorted keys, ignored fields removed). Bodies that are
+// not JSON are hashed as-is.
+func buildCacheKey(r *http.Request, body []byte, cfg CacheKeyConfig) string {
+	h := sha256.New()
+	writePart := func(s string) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+
+	writePart("v2")
+	writePart(r.Method + " " + r.URL.Path)
+	writePart(cfg.Namespace)
+
+	writePart(cacheTenant(r, cfg))
+
+	model, canonical := canonicalBody(body, cfg.IgnoreFields)
+	writePart(model)
+	h.Write(canonical)
+
+	return "cache:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// canonicalBody re-encodes a JSON object with sorted keys and without ignored fields
+func canonicalBody(body []byte, ignore []string) (string, []byte) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber() // Keep numbers exactly as sent
+
+	var data map[string]interface{}
+	if err := dec.Decode(&data); err != nil {
+		return "", body
+	}
+
+	model, _ := data["model"].(string)
+	for _, field := range ignore {
+		deleteValueAtPath(data, field)
+	}
+
+	// encoding/json writes map keys in sorted order
+	canonical, err := json.Marshal(data)
+	if err != nil {
+		return model, body
+	}
+	return model, canonical
+}
+
+// cacheTenant is the team (or user) of the request's API key when entries are
+// separated per tenant
+func cacheTenant(r *http.Request, cfg CacheKeyConfig) string {
+	if !cfg.IncludeTenant {
+		return ""
+	}
+	apiKey, ok := GetAPIKeyFromContext(r.Context())
+	if !ok {
+		return ""
+	}
+	if apiKey.TeamID != "" {
+		return apiKey.TeamID
+	}
+	return apiKey.UserID
+}
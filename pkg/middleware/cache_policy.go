@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/cache"
+)
+
+// CacheRoute overrides cache settings for paths matching a regex
+type CacheRoute struct {
+	Path                 string          `mapstructure:"path"`
+	Key                  *CacheKeyConfig `mapstructure:"key"`
+	TTL                  time.Duration   `mapstructure:"ttl"`
+	RequireDeterministic *bool           `mapstructure:"require_deterministic"`
+	Disabled             bool            `mapstructure:"disabled"`
+}
+
+// CacheConfig configures CachingMiddleware
+type CacheConfig struct {
+	Key    CacheKeyConfig `mapstructure:"key"`
+	Routes []CacheRoute   `mapstructure:"routes"`
+
+	TTL       time.Duration            `mapstructure:"ttl"`        // Default entry lifetime
+	ModelTTLs map[string]time.Duration `mapstructure:"model_ttls"` // Per-model lifetime, wins over route TTL
+
+	// Only cache requests whose output should be repeatable: temperature 0 or a fixed seed.
+	// A request can still opt in explicitly with X-Relay-Cache-TTL.
+	RequireDeterministic bool `mapstructure:"require_deterministic"`
+
+	Coalesce CoalesceConfig `mapstructure:"coalesce"`
+
+	// Upstream headers stored with and replayed from an entry ("X-Ratelimit-*" style
+	// prefixes allowed); empty uses defaultCacheHeaders
+	Headers []string `mapstructure:"headers"`
+
+	// Compressor shrinks entries before they are stored; nil stores them as is
+	Compressor *cache.Compressor `mapstructure:"-"`
+}
+
+type compiledCacheRoute struct {
+	CacheRoute
+	re *regexp.Regexp
+}
+
+// cacheRules resolves the effective cache settings for a request
+type cacheRules struct {
+	cfg    CacheConfig
+	routes []compiledCacheRoute
+}
+
+// cachePolicy is the decision for one request
+type cachePolicy struct {
+	key    CacheKeyConfig
+	lookup bool          // Serve from cache if present
+	store  bool          // Save the upstream response
+	ttl    time.Duration // Lifetime of a saved entry
+}
+
+func newCacheRules(cfg CacheConfig) *cacheRules {
+	if cfg.TTL <= 0 {
+		cfg.TTL = time.Hour
+	}
+
+	rules := &cacheRules{cfg: cfg}
+	for _, route := range cfg.Routes {
+		re, err := regexp.Compile(route.Path)
+		if err != nil {
+			continue
+		}
+		rules.routes = append(rules.routes, compiledCacheRoute{CacheRoute: route, re: re})
+	}
+	return rules
+}
+
+func (c *cacheRules) route(path string) *compiledCacheRoute {
+	for i := range c.routes {
+		if c.routes[i].re.MatchString(path) {
+			return &c.routes[i]
+		}
+	}
+	return nil
+}
+
+// policy combines route/model config with the request's own cache headers:
+//
+//	Cache-Control: no-store   never read or write the cache
+//	Cache-Control: no-cache   skip the lookup but store the fresh response
+//	X-Relay-Cache-TTL: 300    store for this long (seconds or a Go duration); 0 disables
+func (c *cacheRules) policy(r *http.Request, body []byte) cachePolicy {
+	p := cachePolicy{
+		key:    c.cfg.Key,
+		lookup: true,
+		store:  true,
+		ttl:    c.cfg.TTL,
+	}
+	requireDeterministic := c.cfg.RequireDeterministic
+
+	if route := c.route(r.URL.Path); route != nil {
+		if route.Disabled {
+			return cachePolicy{}
+		}
+		if route.Key != nil {
+			p.key = *route.Key
+		}
+		if route.TTL > 0 {
+			p.ttl = route.TTL
+		}
+		if route.RequireDeterministic != nil {
+			requireDeterministic = *route.RequireDeterministic
+		}
+	}
+
+	info := parseCacheRequest(body)
+	if ttl, ok := c.cfg.ModelTTLs[info.model]; ok && ttl > 0 {
+		p.ttl = ttl
+	}
+
+	if requireDeterministic && !info.deterministic() {
+		p.lookup = false
+		p.store = false
+	}
+
+	cacheControl := strings.ToLower(r.Header.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-store") {
+		return cachePolicy{key: p.key}
+	}
+	if strings.Contains(cacheControl, "no-cache") {
+		p.lookup = false
+	}
+
+	if header := r.Header.Get("X-Relay-Cache-TTL"); header != "" {
+		if ttl, ok := parseCacheTTL(header); ok {
+			if ttl <= 0 {
+				p.store = false
+			} else {
+				// An explicit TTL is an opt-in, even for non-deterministic requests
+				p.ttl = ttl
+				p.store = true
+				p.lookup = !strings.Contains(cacheControl, "no-cache")
+			}
+		}
+	}
+
+	return p
+}
+
+// CacheKey returns the content hash a request would be cached under (without the
+// cache: prefix), using the same key settings as CachingMiddleware
+func (c CacheConfig) CacheKey(r *http.Request, body []byte) string {
+	keyCfg := c.Key
+	if route := newCacheRules(c).route(r.URL.Path); route != nil && route.Key != nil {
+		keyCfg = *route.Key
+	}
+	return strings.TrimPrefix(buildCacheKey(r, body, keyCfg), "cache:")
+}
+
+func parseCacheTTL(v string) (time.Duration, bool) {
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return d, true
+	}
+	return 0, false
+}
+
+// cacheRequestInfo holds the body fields that affect caching
+type cacheRequestInfo struct {
+	model       string
+	temperature *float64
+	hasSeed     bool
+}
+
+func (i cacheRequestInfo) deterministic() bool {
+	return i.hasSeed || (i.temperature != nil && *i.temperature == 0)
+}
+
+func parseCacheRequest(body []byte) cacheRequestInfo {
+	var req struct {
+		Model       string          `json:"model"`
+		Temperature *float64        `json:"temperature"`
+		Seed        json.RawMessage `json:"seed"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return cacheRequestInfo{}
+	}
+	seed := bytes.TrimSpace(req.Seed)
+	return cacheRequestInfo{
+		model:       req.Model,
+		temperature: req.Temperature,
+		hasSeed:     len(seed) > 0 && !bytes.Equal(seed, []byte("null")),
+	}
+}
@@ -3,12 +3,12 @@ package middleware
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
-	"fmt"
+	"encoding/json"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ngoyal88/relay/pkg/cache"
@@ -46,8 +46,24 @@ func (rw *responseWrapper) Flush() {
 	}
 }
 
-// CachingMiddleware handles the Redis logic
-func CachingMiddleware(rdb *cache.Client) func(http.Handler) http.Handler {
+// CachingMiddleware serves repeated requests from the response cache backend
+func CachingMiddleware(backend cache.Backend, cfg CacheConfig) func(http.Handler) http.Handler {
+	rules := newCacheRules(cfg)
+	flights := newFlightGroup()
+
+	headers := cfg.Headers
+	if len(headers) == 0 {
+		headers = defaultCacheHeaders
+	}
+
+	coalesce := cfg.Coalesce
+	if coalesce.LockTTL <= 0 {
+		coalesce.LockTTL = time.Minute
+	}
+	if coalesce.MaxWait <= 0 {
+		coalesce.MaxWait = coalesce.LockTTL
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// 1. Only cache POST requests
@@ -56,51 +72,256 @@ func CachingMiddleware(rdb *cache.Client) func(http.Handler) http.Handler {
 				return
 			}
 
-			// 2. Hash the Body
+			// 2. Decide what this request may do with the cache, and build its key
+			// from the canonical body plus path, namespace and tenant
 			bodyBytes, _ := io.ReadAll(r.Body)
 			r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes)) // Refill
-			hash := sha256.Sum256(bodyBytes)
-			key := fmt.Sprintf("cache:%s", hex.EncodeToString(hash[:]))
+			policy := rules.policy(r, bodyBytes)
+			if !policy.lookup && !policy.store {
+				w.Header().Set("X-Cache", "BYPASS")
+				next.ServeHTTP(w, r)
+				return
+			}
+			hash := strings.TrimPrefix(buildCacheKey(r, bodyBytes, policy.key), "cache:")
+			w.Header().Set("X-Cache-Key", hash)
 
-			// 3. CHECK REDIS (With Timeout!)
-			// FIX: Don't wait forever. Give Redis 2 seconds max.
+			// 3. CHECK THE CACHE (With Timeout!)
+			// FIX: Don't wait forever. Give the backend 2 seconds max.
 			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 			defer cancel()
 
-			val, err := rdb.Get(ctx, key)
-			if err == nil {
-				cacheHits.Inc()
-				w.Header().Set("X-Cache", "HIT")
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusOK)
-				w.Write(val)
-				log.Printf("⚡ [CACHE] HIT for key %s", key[:8])
-				return
+			if policy.lookup {
+				entry, err := getEntry(ctx, backend, hash)
+				if err == nil {
+					cacheHits.Inc()
+					backend.RecordLookup(ctx, true)
+					writeCached(w, r, entry, "HIT")
+					log.Printf("⚡ [CACHE] HIT for key %s", hash[:8])
+					return
+				}
+
+				if err != cache.ErrMiss && err != context.DeadlineExceeded {
+					// Log actual backend errors (connection refused, etc)
+					log.Printf("⚠️ [CACHE] Backend error: %v", err)
+				}
 			}
 
-			cacheMisses.Inc()
-			if err != context.DeadlineExceeded && err.Error() != "redis: nil" {
-				// Log actual Redis errors (connection refused, etc)
-				log.Printf("⚠️ [CACHE] Redis error: %v", err)
+			// 4. COALESCE: identical requests already on their way upstream are
+			// waited on instead of sent again (only for plain cacheable requests)
+			var release func()
+			var f *flight
+			if coalesce.Enabled && policy.lookup && policy.store {
+				var leader bool
+				f, leader = flights.join(hash)
+				if !leader {
+					// Only a response worth caching is shared, so one upstream
+					// failure isn't handed to every waiter
+					if f.replay(r.Context(), w, "X-Cache", "COALESCED", isCacheable) {
+						cacheCoalesced.Inc()
+						recordLookup(backend, true)
+						return
+					}
+					// The leader produced nothing usable; fetch it ourselves
+					f = nil
+				} else {
+					defer f.finish()
+					if coalesce.Locker != nil {
+						rel, ok, err := coalesce.Locker.Acquire(ctx, hash, coalesce.LockTTL)
+						switch {
+						case ok:
+							release = rel
+						case err == nil:
+							// Another instance is fetching it; wait for its entry
+							if entry, found := waitForEntry(r.Context(), backend, coalesce.Locker, hash, coalesce.MaxWait); found {
+								cacheCoalesced.Inc()
+								recordLookup(backend, true)
+								writeCached(&flightWriter{ResponseWriter: w, f: f}, r, entry, "COALESCED")
+								flights.forget(hash, f)
+								return
+							}
+						}
+					}
+				}
 			}
 
-			// 4. MISS -> Proxy
-			spy := &responseWrapper{ResponseWriter: w}
+			cacheMisses.Inc()
+			recordLookup(backend, false)
+			w.Header().Set("X-Cache", "MISS")
+
+			// 5. MISS -> Proxy
+			out := w
+			if f != nil {
+				out = &flightWriter{ResponseWriter: w, f: f}
+			}
+			spy := &responseWrapper{ResponseWriter: out}
 			next.ServeHTTP(spy, r)
 
-			// 5. SAVE (Async with Timeout)
-			if spy.statusCode == http.StatusOK {
-				go func(k string, data []byte) {
-					ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-					defer cancel()
+			// 6. SAVE (Async with Timeout), then let go of the flight and lock
+			done := func() {
+				if release != nil {
+					release()
+				}
+				if f != nil {
+					flights.forget(hash, f)
+				}
+			}
+			// A semantic hit answered a different prompt; storing it under this
+			// request's exact key would replay it as an exact HIT and outlive
+			// purges of the semantic entry
+			if !policy.store || !isCacheable(spy.statusCode) || w.Header().Get("X-Cache") == "SEMANTIC-HIT" {
+				done()
+				return
+			}
 
-					if err := rdb.Set(ctx, k, data, time.Hour); err != nil {
-						log.Printf("⚠️ [CACHE] Failed to save: %v", err)
-					} else {
-						log.Printf("💾 [CACHE] Saved key %s", k[:8])
-					}
-				}(key, spy.body.Bytes())
+			meta := cache.EntryMeta{
+				Key:       hash,
+				Path:      r.URL.Path,
+				Model:     parseCacheRequest(bodyBytes).model,
+				Tenant:    cacheTenant(r, policy.key),
+				Namespace: policy.key.Namespace,
 			}
+			entry := newCacheEntry(r, spy, headers)
+			go func(meta cache.EntryMeta, entry *cache.Entry, ttl time.Duration) {
+				defer done()
+
+				data, err := entry.Encode()
+				if err != nil {
+					log.Printf("⚠️ [CACHE] Failed to encode entry: %v", err)
+					return
+				}
+				data = cfg.Compressor.Compress(data)
+				meta.CreatedAt = entry.CreatedAt
+
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+
+				if err := backend.Set(ctx, meta, data, ttl); err != nil {
+					log.Printf("⚠️ [CACHE] Failed to save: %v", err)
+				} else {
+					log.Printf("💾 [CACHE] Saved key %s", meta.Key[:8])
+				}
+			}(meta, entry, policy.ttl)
 		})
 	}
 }
+
+// isCacheable reports whether a response with status may be cached and
+// shared with coalesced requests
+func isCacheable(status int) bool {
+	return status == http.StatusOK
+}
+
+// defaultCacheHeaders are the upstream headers kept with a cached response.
+// A trailing * matches any header with that prefix.
+var defaultCacheHeaders = []string{
+	"Content-Type",
+	"Openai-*",
+	"Anthropic-*",
+	"X-Request-Id",
+	"Request-Id",
+	"X-Ratelimit-*",
+}
+
+// cacheSavings carries what a hit saved out to RequestLoggingMiddleware
+type cacheSavings struct {
+	found   bool
+	costUSD float64
+}
+
+const cacheSavingsContextKey contextKey = "cache_savings"
+
+// newCacheEntry captures the upstream response and what it cost
+func newCacheEntry(r *http.Request, spy *responseWrapper, patterns []string) *cache.Entry {
+	body := append([]byte(nil), spy.body.Bytes()...)
+	entry := &cache.Entry{
+		Status:    spy.statusCode,
+		Header:    selectHeaders(spy.Header(), patterns),
+		Body:      body,
+		CreatedAt: time.Now(),
+	}
+
+	if cost, ok := GetTokenCostFromContext(r.Context()); ok {
+		entry.CostUSD = cost
+	}
+	if tokens, ok := GetTokenCountFromContext(r.Context()); ok {
+		entry.TokensIn = tokens
+	}
+
+	var resp struct {
+		Usage *struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if json.Unmarshal(body, &resp) == nil && resp.Usage != nil {
+		entry.TokensIn = resp.Usage.PromptTokens
+		entry.TokensOut = resp.Usage.CompletionTokens
+	}
+	return entry
+}
+
+func selectHeaders(h http.Header, patterns []string) http.Header {
+	out := make(http.Header)
+	for name, values := range h {
+		for _, p := range patterns {
+			p = http.CanonicalHeaderKey(p)
+			if prefix, ok := strings.CutSuffix(p, "*"); ok {
+				if !strings.HasPrefix(name, prefix) {
+					continue
+				}
+			} else if name != p {
+				continue
+			}
+			out[name] = append([]string(nil), values...)
+			break
+		}
+	}
+	return out
+}
+
+// getEntry loads and decodes a cached response
+func getEntry(ctx context.Context, backend cache.Backend, key string) (*cache.Entry, error) {
+	meta, data, err := backend.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := cache.DecodeEntry(data)
+	if err != nil {
+		return nil, err
+	}
+	if entry.CreatedAt.IsZero() && meta != nil {
+		entry.CreatedAt = meta.CreatedAt
+	}
+	return entry, nil
+}
+
+// writeCached replays a stored response and reports its original cost as saved
+func writeCached(w http.ResponseWriter, r *http.Request, entry *cache.Entry, status string) {
+	w.Header().Set("X-Cache", status)
+	writeEntry(w, r, entry)
+}
+
+// writeEntry replays entry's status, headers and body, recording its cost as saved
+func writeEntry(w http.ResponseWriter, r *http.Request, entry *cache.Entry) {
+	for name, values := range entry.Header {
+		w.Header()[name] = values
+	}
+	if !entry.CreatedAt.IsZero() {
+		age := time.Since(entry.CreatedAt)
+		w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+	}
+	if savings, ok := r.Context().Value(cacheSavingsContextKey).(*cacheSavings); ok {
+		savings.found = true
+		savings.costUSD = entry.CostUSD
+	}
+	w.WriteHeader(entry.Status)
+	w.Write(entry.Body)
+}
+
+// recordLookup counts a hit or miss with its own deadline; coalesced requests
+// may have waited well past the lookup timeout
+func recordLookup(backend cache.Backend, hit bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	backend.RecordLookup(ctx, hit)
+}
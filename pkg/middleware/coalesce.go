@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/cache"
+)
+
+// CoalesceConfig collapses concurrent identical cacheable requests into one upstream call
+type CoalesceConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	LockTTL time.Duration `mapstructure:"lock_ttl"` // Cross-instance lock lifetime
+	MaxWait time.Duration `mapstructure:"max_wait"` // How long to wait for another instance
+
+	// Locker extends coalescing across instances; nil keeps it per-instance
+	Locker cache.Locker `mapstructure:"-"`
+}
+
+// flight is one upstream request that identical concurrent requests wait on.
+// The leader's response is recorded as it is written, so followers can replay
+// it as it arrives, streamed responses included.
+type flight struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	started bool // Status line written
+	done    bool
+	status  int
+	header  http.Header
+	body    []byte
+}
+
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// join returns the in-flight request for key, or starts one with the caller as leader
+func (g *flightGroup) join(key string) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+	f := &flight{}
+	f.cond = sync.NewCond(&f.mu)
+	g.flights[key] = f
+	return f, true
+}
+
+// forget stops new requests from joining f. Called once the response is
+// stored, so late arrivals hit the cache instead.
+func (g *flightGroup) forget(key string, f *flight) {
+	g.mu.Lock()
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+	g.mu.Unlock()
+}
+
+func (f *flight) start(status int, header http.Header) {
+	f.mu.Lock()
+	if !f.started {
+		f.started = true
+		f.status = status
+		f.header = header.Clone()
+	}
+	f.mu.Unlock()
+}
+
+func (f *flight) write(b []byte) {
+	f.mu.Lock()
+	f.body = append(f.body, b...)
+	f.cond.Broadcast()
+	f.mu.Unlock()
+}
+
+// finish wakes all followers; if the leader never wrote a response they retry on their own
+func (f *flight) finish() {
+	f.mu.Lock()
+	f.done = true
+	f.cond.Broadcast()
+	f.mu.Unlock()
+}
+
+// replay copies the leader's response to w as it arrives, setting the header
+// name to value to mark it. It returns false if the leader finished without a
+// response, answered with a status accept rejects (nil accepts any) or ctx
+// ended first, in which case nothing was written.
+func (f *flight) replay(ctx context.Context, w http.ResponseWriter, name, value string, accept func(status int) bool) bool {
+	stop := context.AfterFunc(ctx, func() {
+		f.mu.Lock()
+		f.cond.Broadcast()
+		f.mu.Unlock()
+	})
+	defer stop()
+
+	f.mu.Lock()
+	for !f.started && !f.done && ctx.Err() == nil {
+		f.cond.Wait()
+	}
+	if !f.started || (accept != nil && !accept(f.status)) {
+		f.mu.Unlock()
+		return false
+	}
+
+	// Headers already set by outer middleware (request IDs etc.) are kept
+	for k, v := range f.header {
+		if _, ok := w.Header()[k]; !ok {
+			w.Header()[k] = v
+		}
+	}
+	w.Header().Set(name, value)
+	w.WriteHeader(f.status)
+
+	flusher, _ := w.(http.Flusher)
+	sent := 0
+	for {
+		if sent < len(f.body) {
+			chunk := f.body[sent:] // Appends never touch bytes already written
+			sent = len(f.body)
+			f.mu.Unlock()
+			w.Write(chunk)
+			if flusher != nil {
+				flusher.Flush()
+			}
+			f.mu.Lock()
+			continue
+		}
+		if f.done || ctx.Err() != nil {
+			break
+		}
+		f.cond.Wait()
+	}
+	f.mu.Unlock()
+	return true
+}
+
+// flightWriter records the leader's response for its followers
+type flightWriter struct {
+	http.ResponseWriter
+	f *flight
+}
+
+func (fw *flightWriter) WriteHeader(code int) {
+	fw.f.start(code, fw.Header())
+	fw.ResponseWriter.WriteHeader(code)
+}
+
+func (fw *flightWriter) Write(b []byte) (int, error) {
+	fw.f.start(http.StatusOK, fw.Header())
+	fw.f.write(b)
+	return fw.ResponseWriter.Write(b)
+}
+
+func (fw *flightWriter) Flush() {
+	if f, ok := fw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// waitForEntry polls the cache while another instance holds the lock for key.
+// It gives up when the lock is released without an entry or maxWait passes.
+func waitForEntry(ctx context.Context, backend cache.Backend, locker cache.Locker, key string, maxWait time.Duration) (*cache.Entry, bool) {
+	ctx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-ticker.C:
+		}
+
+		if entry, err := getEntry(ctx, backend, key); err == nil {
+			return entry, true
+		}
+		if held, err := locker.Held(ctx, key); err != nil || !held {
+			// Leader finished (or died); it may have stored the entry just before unlocking
+			entry, err := getEntry(ctx, backend, key)
+			return entry, err == nil
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/cache"
+)
+
+const coalesceBody = `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+
+func newCoalescingHandler(rdb *cache.Client, locker cache.Locker, upstream http.Handler) http.Handler {
+	cfg := CacheConfig{
+		TTL:      time.Hour,
+		Coalesce: CoalesceConfig{Enabled: true, MaxWait: 5 * time.Second, Locker: locker},
+	}
+	return CachingMiddleware(cache.NewRedisBackend(rdb), cfg)(upstream)
+}
+
+func coalescedRequest(handler http.Handler) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(coalesceBody))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// sendConcurrently sends the first request, then the rest once it is
+// upstream, and lets the upstream answer after they have had time to queue
+func sendConcurrently(t *testing.T, upstream *testUpstream, handlers ...http.Handler) []*httptest.ResponseRecorder {
+	t.Helper()
+	results := make([]chan *httptest.ResponseRecorder, len(handlers))
+	for i, h := range handlers {
+		results[i] = make(chan *httptest.ResponseRecorder, 1)
+		go func(h http.Handler, out chan<- *httptest.ResponseRecorder) { out <- coalescedRequest(h) }(h, results[i])
+		if i == 0 {
+			<-upstream.started
+		}
+	}
+	time.Sleep(300 * time.Millisecond)
+	close(upstream.release)
+
+	recs := make([]*httptest.ResponseRecorder, len(handlers))
+	for i, ch := range results {
+		recs[i] = <-ch
+	}
+	return recs
+}
+
+func TestCoalescingSendsOneUpstreamCall(t *testing.T) {
+	const n = 5
+	upstream := &testUpstream{started: make(chan struct{}, n), release: make(chan struct{})}
+	handler := newCoalescingHandler(newTestRedis(t), nil, upstream)
+
+	handlers := make([]http.Handler, n)
+	for i := range handlers {
+		handlers[i] = handler
+	}
+	recs := sendConcurrently(t, upstream, handlers...)
+
+	if calls := upstream.calls.Load(); calls != 1 {
+		t.Errorf("upstream called %d times, want 1", calls)
+	}
+	leader := recs[0]
+	if leader.Code != http.StatusOK || leader.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("leader: status %d, X-Cache %q", leader.Code, leader.Header().Get("X-Cache"))
+	}
+	for i, rec := range recs[1:] {
+		if rec.Code != leader.Code || rec.Body.String() != leader.Body.String() {
+			t.Errorf("follower %d got %d %s, want %d %s", i, rec.Code, rec.Body, leader.Code, leader.Body)
+		}
+		if got := rec.Header().Get("X-Cache"); got != "COALESCED" {
+			t.Errorf("follower %d: X-Cache %q, want COALESCED", i, got)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("follower %d: Content-Type %q", i, got)
+		}
+	}
+}
+
+func TestCoalescingFollowersRetryWhenLeaderFails(t *testing.T) {
+	const n = 4
+	upstream := &testUpstream{statuses: []int{http.StatusBadGateway}, started: make(chan struct{}, n), release: make(chan struct{})}
+	handler := newCoalescingHandler(newTestRedis(t), nil, upstream)
+
+	handlers := make([]http.Handler, n)
+	for i := range handlers {
+		handlers[i] = handler
+	}
+	recs := sendConcurrently(t, upstream, handlers...)
+
+	if recs[0].Code != http.StatusBadGateway {
+		t.Fatalf("leader: status %d, want 502", recs[0].Code)
+	}
+	for i, rec := range recs[1:] {
+		if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") == "COALESCED" {
+			t.Errorf("follower %d: status %d, X-Cache %q; want its own 200", i, rec.Code, rec.Header().Get("X-Cache"))
+		}
+	}
+	if calls := upstream.calls.Load(); calls != n {
+		t.Errorf("upstream called %d times, want %d", calls, n)
+	}
+}
+
+func TestCoalescingAcrossInstances(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		calls    int32
+		coalesce bool
+	}{
+		{"leader succeeds", nil, 1, true},
+		{"leader fails", []int{http.StatusInternalServerError}, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb := newTestRedis(t)
+			locker := cache.NewRedisLocker(rdb)
+			upstream := &testUpstream{statuses: tt.statuses, started: make(chan struct{}, 2), release: make(chan struct{})}
+
+			recs := sendConcurrently(t, upstream,
+				newCoalescingHandler(rdb, locker, upstream),
+				newCoalescingHandler(rdb, locker, upstream))
+
+			if calls := upstream.calls.Load(); calls != tt.calls {
+				t.Errorf("upstream called %d times, want %d", calls, tt.calls)
+			}
+			follower := recs[1]
+			if follower.Code != http.StatusOK {
+				t.Errorf("follower: status %d, want 200", follower.Code)
+			}
+			if coalesced := follower.Header().Get("X-Cache") == "COALESCED"; coalesced != tt.coalesce {
+				t.Errorf("follower: X-Cache %q", follower.Header().Get("X-Cache"))
+			}
+			if tt.coalesce && follower.Body.String() != recs[0].Body.String() {
+				t.Errorf("follower body %s, want %s", follower.Body, recs[0].Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minCompressBytes skips responses whose declared length is too small to gain anything
+const minCompressBytes = 1024
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+// ResponseCompression gzips responses for clients that accept it. Upstream
+// responses reach the middleware decoded (see proxy.decodeResponse), so this is
+// where the client's Accept-Encoding is honoured; bodies that already carry a
+// Content-Encoding are passed through untouched.
+func ResponseCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding value allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "x-gzip" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// shouldCompress decides from the status and headers, before any body is written
+func shouldCompress(status int, h http.Header) bool {
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < minCompressBytes {
+		return false
+	}
+
+	// Streams stay uncompressed so every event is delivered the moment it's flushed
+	ct := strings.ToLower(h.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(ct, "text/event-stream"):
+		return false
+	case strings.HasPrefix(ct, "text/"),
+		strings.Contains(ct, "json"),
+		strings.Contains(ct, "xml"),
+		strings.Contains(ct, "javascript"):
+		return true
+	}
+	return false
+}
+
+// compressWriter gzips the body once the headers show it is worth it
+type compressWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	gz          *gzip.Writer
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	if code < 200 {
+		cw.ResponseWriter.WriteHeader(code) // Informational; the real status follows
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	if shouldCompress(code, h) {
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+		h.Del("Content-Length")
+		cw.gz = gzipWriterPool.Get().(*gzip.Writer)
+		cw.gz.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.gz != nil {
+		return cw.gz.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *compressWriter) Flush() {
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) close() {
+	if cw.gz == nil {
+		return
+	}
+	cw.gz.Close()
+	cw.gz.Reset(nil)
+	gzipWriterPool.Put(cw.gz)
+	cw.gz = nil
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 
 	"github.com/ngoyal88/relay/pkg/ai"
+	"github.com/ngoyal88/relay/pkg/apierror"
 	"github.com/ngoyal88/relay/pkg/config"
 )
 
@@ -28,7 +29,7 @@ func TokenCostLogger(cfgStore *config.Store) func(http.Handler) http.Handler {
 			// We read all bytes from the request body into a byte array
 			bodyBytes, err := io.ReadAll(r.Body)
 			if err != nil {
-				http.Error(w, "Failed to read body", http.StatusInternalServerError)
+				apierror.Write(w, r, apierror.CodeInvalidRequest, "Failed to read body")
 				return
 			}
 
@@ -46,15 +47,26 @@ func TokenCostLogger(cfgStore *config.Store) func(http.Handler) http.Handler {
 						fullText += msg.Content
 					}
 
+					// An alias is priced as its model unless it has a price of its own
+					alias, _ := GetModelAliasFromContext(r.Context())
+					priced := payload.Model
+					if _, ok := cfg.Models[priced]; !ok && alias != "" {
+						priced = alias
+					}
+
 					count, _ := ai.CountTokens(payload.Model, fullText)
-					cost := ai.EstimateCost(count, payload.Model, cfg.Models)
+					cost := ai.EstimateCost(count, priced, cfg.Models)
 
 					ctx := context.WithValue(r.Context(), tokenCountContextKey, count)
 					ctx = context.WithValue(ctx, tokenCostContextKey, cost)
 					r = r.WithContext(ctx)
 
 					requestTokenHistogram.Observe(float64(count))
-					log.Printf("💰 [COST] Model: %s | Tokens: %d | Est. Cost: $%.6f", payload.Model, count, cost)
+					if alias != "" {
+						log.Printf("💰 [COST] Model: %s (alias %s) | Tokens: %d | Est. Cost: $%.6f", payload.Model, alias, count, cost)
+					} else {
+						log.Printf("💰 [COST] Model: %s | Tokens: %d | Est. Cost: $%.6f", payload.Model, count, cost)
+					}
 				}
 			}
 
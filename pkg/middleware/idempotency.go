@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/apierror"
+	"github.com/ngoyal88/relay/pkg/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+// IdempotencyConfig makes retried POSTs carrying the same Idempotency-Key get
+// the first response back instead of reaching the upstream (and the bill) again
+type IdempotencyConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	TTL     time.Duration `mapstructure:"ttl"`      // How long a response can be replayed
+	LockTTL time.Duration `mapstructure:"lock_ttl"` // Upper bound on the first request
+	MaxWait time.Duration `mapstructure:"max_wait"` // How long a duplicate waits for it
+
+	// Upstream headers stored with the response; empty uses defaultCacheHeaders
+	Headers []string `mapstructure:"headers"`
+}
+
+// maxIdempotencyKeyLen bounds the client-chosen key
+const maxIdempotencyKeyLen = 255
+
+// IdempotencyMiddleware stores the response to each Idempotency-Key, scoped to
+// the caller's API key, in Redis. Duplicates that arrive while the first request
+// is running wait for it (on this instance or another); later ones get the
+// stored response with Idempotent-Replayed: true. Must run inside AuthMiddleware.
+func IdempotencyMiddleware(rdb *cache.Client, cfg IdempotencyConfig) func(http.Handler) http.Handler {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if cfg.LockTTL <= 0 {
+		cfg.LockTTL = 5 * time.Minute
+	}
+	if cfg.MaxWait <= 0 {
+		cfg.MaxWait = cfg.LockTTL
+	}
+	if len(cfg.Headers) == 0 {
+		cfg.Headers = defaultCacheHeaders
+	}
+
+	locker := cache.NewRedisLocker(rdb)
+	flights := newFlightGroup()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			idemKey := r.Header.Get("Idempotency-Key")
+			if r.Method != http.MethodPost || idemKey == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(idemKey) > maxIdempotencyKeyLen {
+				apierror.Write(w, r, apierror.CodeInvalidRequest, "Idempotency-Key must be at most 255 characters")
+				return
+			}
+
+			bodyBytes, _ := io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+			key := idempotencyKey(r, idemKey)
+			fingerprint := hashHex([]byte(r.URL.Path), bodyBytes)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			// 1. Already answered: replay it
+			if entry, err := getIdempotent(ctx, rdb, key); err == nil {
+				replayIdempotent(w, r, entry, fingerprint)
+				return
+			} else if err != cache.ErrMiss {
+				log.Printf("⚠️ [IDEMPOTENCY] Lookup failed: %v", err)
+			}
+
+			// 2. A duplicate is running on this instance: relay its response
+			f, leader := flights.join(key + ":" + fingerprint)
+			if !leader {
+				if !f.replay(r.Context(), w, "Idempotent-Replayed", "true", nil) {
+					apierror.Write(w, r, apierror.CodeIdempotencyConflict, "A request with this Idempotency-Key did not complete; retry it")
+				}
+				return
+			}
+			defer f.finish()
+			defer flights.forget(key+":"+fingerprint, f)
+			out := &flightWriter{ResponseWriter: w, f: f}
+
+			// 3. Claim the key, or wait for the instance that holds it
+			release, ok, err := locker.Acquire(ctx, key, cfg.LockTTL)
+			if err != nil {
+				// Without Redis there is nothing to deduplicate against
+				log.Printf("⚠️ [IDEMPOTENCY] Lock failed, serving without it: %v", err)
+				next.ServeHTTP(out, r)
+				return
+			}
+			if !ok {
+				entry, found := waitForIdempotent(r.Context(), rdb, locker, key, cfg.MaxWait)
+				if !found {
+					// Not relayed to local duplicates; they report it themselves
+					apierror.Write(w, r, apierror.CodeIdempotencyConflict, "A request with this Idempotency-Key is still in progress")
+					return
+				}
+				replayIdempotent(out, r, entry, fingerprint)
+				return
+			}
+			defer release()
+
+			// 4. First request: serve it and keep the response
+			spy := &responseWrapper{ResponseWriter: out}
+			next.ServeHTTP(spy, r)
+
+			if !shouldStoreIdempotent(spy.statusCode) || r.Context().Err() != nil {
+				// Failed or cut short; the client should be able to retry it
+				return
+			}
+			entry := newCacheEntry(r, spy, cfg.Headers)
+			entry.RequestHash = fingerprint
+			data, err := entry.Encode()
+			if err != nil {
+				log.Printf("⚠️ [IDEMPOTENCY] Failed to encode response: %v", err)
+				return
+			}
+
+			saveCtx, saveCancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer saveCancel()
+			if err := rdb.Set(saveCtx, key, data, cfg.TTL); err != nil {
+				log.Printf("⚠️ [IDEMPOTENCY] Failed to save response: %v", err)
+			}
+		})
+	}
+}
+
+// idempotencyKey scopes the client's key to its API key (or upstream
+// credential when auth is off), so callers can't replay each other's responses
+func idempotencyKey(r *http.Request, idemKey string) string {
+	owner := r.Header.Get("Authorization")
+	if apiKey, ok := GetAPIKeyFromContext(r.Context()); ok {
+		owner = apiKey.Key
+	}
+	return "idem:" + hashHex([]byte(owner), []byte(idemKey))
+}
+
+func hashHex(parts ...[]byte) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// shouldStoreIdempotent keeps final answers; server errors and rate limits
+// are worth retrying, so those are not pinned to the key
+func shouldStoreIdempotent(status int) bool {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return status < http.StatusInternalServerError && status != http.StatusTooManyRequests
+}
+
+func getIdempotent(ctx context.Context, rdb *cache.Client, key string) (*cache.Entry, error) {
+	data, err := rdb.Get(ctx, key)
+	if err != nil {
+		if err == redis.Nil {
+			return nil, cache.ErrMiss
+		}
+		return nil, err
+	}
+	return cache.DecodeEntry(data)
+}
+
+// replayIdempotent writes a stored response, refusing reuse of the key for a different request
+func replayIdempotent(w http.ResponseWriter, r *http.Request, entry *cache.Entry, fingerprint string) {
+	if entry.RequestHash != fingerprint {
+		apierror.Write(w, r, apierror.CodeIdempotencyKeyReused, "Idempotency-Key was already used for a different request")
+		return
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	writeEntry(w, r, entry)
+}
+
+// waitForIdempotent polls for the response while another instance holds the key
+func waitForIdempotent(ctx context.Context, rdb *cache.Client, locker cache.Locker, key string, maxWait time.Duration) (*cache.Entry, bool) {
+	ctx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-ticker.C:
+		}
+
+		if entry, err := getIdempotent(ctx, rdb, key); err == nil {
+			return entry, true
+		}
+		if held, err := locker.Held(ctx, key); err != nil || !held {
+			// Released: either stored just before unlocking, or failed and retryable
+			entry, err := getIdempotent(ctx, rdb, key)
+			return entry, err == nil
+		}
+	}
+}
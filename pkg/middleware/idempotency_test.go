@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/cache"
+)
+
+// testUpstream counts its calls and answers each with the next status, or
+// 200, and a body naming the call. Calls block until release is closed when
+// it is set.
+type testUpstream struct {
+	calls    atomic.Int32
+	statuses []int
+	started  chan struct{}
+	release  chan struct{}
+}
+
+func (u *testUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int(u.calls.Add(1))
+	if u.started != nil {
+		u.started <- struct{}{}
+	}
+	if u.release != nil {
+		<-u.release
+	}
+	status := http.StatusOK
+	if n <= len(u.statuses) {
+		status = u.statuses[n-1]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(`{"call":` + strconv.Itoa(n) + `}`))
+}
+
+func idempotentRequest(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer sk-test")
+	req.Header.Set("Idempotency-Key", key)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func newIdempotentHandler(rdb *cache.Client, upstream http.Handler) http.Handler {
+	return IdempotencyMiddleware(rdb, IdempotencyConfig{MaxWait: 5 * time.Second})(upstream)
+}
+
+func TestIdempotencyReplaysTheFirstResponse(t *testing.T) {
+	upstream := &testUpstream{}
+	handler := newIdempotentHandler(newTestRedis(t), upstream)
+	const body = `{"model":"gpt-4o","messages":[]}`
+
+	first := idempotentRequest(handler, "order-1", body)
+	if first.Code != http.StatusOK || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first: status %d, replayed %q", first.Code, first.Header().Get("Idempotent-Replayed"))
+	}
+	retry := idempotentRequest(handler, "order-1", body)
+	if retry.Code != http.StatusOK || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry: status %d, replayed %q", retry.Code, retry.Header().Get("Idempotent-Replayed"))
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("retry body %s, want %s", retry.Body, first.Body)
+	}
+
+	// A different key is a new request
+	if rec := idempotentRequest(handler, "order-2", body); rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("another key was replayed: %s", rec.Body)
+	}
+	if n := upstream.calls.Load(); n != 2 {
+		t.Errorf("upstream called %d times, want 2", n)
+	}
+}
+
+func TestIdempotencyRejectsKeyReusedForAnotherBody(t *testing.T) {
+	upstream := &testUpstream{}
+	handler := newIdempotentHandler(newTestRedis(t), upstream)
+
+	idempotentRequest(handler, "order-1", `{"model":"gpt-4o","messages":[]}`)
+	rec := idempotentRequest(handler, "order-1", `{"model":"gpt-4o-mini","messages":[]}`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), `"idempotency_key_reused"`) {
+		t.Errorf("status %d, body %s; want 422 idempotency_key_reused", rec.Code, rec.Body)
+	}
+	if n := upstream.calls.Load(); n != 1 {
+		t.Errorf("upstream called %d times, want 1", n)
+	}
+}
+
+func TestIdempotencyDuplicateWaitsForTheFirstRequest(t *testing.T) {
+	const body = `{"model":"gpt-4o","messages":[]}`
+
+	tests := []struct {
+		name       string
+		duplicates func(rdb *cache.Client, upstream http.Handler) (first, second http.Handler)
+	}{
+		{"same instance", func(rdb *cache.Client, upstream http.Handler) (http.Handler, http.Handler) {
+			h := newIdempotentHandler(rdb, upstream)
+			return h, h
+		}},
+		{"other instance", func(rdb *cache.Client, upstream http.Handler) (http.Handler, http.Handler) {
+			return newIdempotentHandler(rdb, upstream), newIdempotentHandler(rdb, upstream)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &testUpstream{started: make(chan struct{}, 2), release: make(chan struct{})}
+			first, second := tt.duplicates(newTestRedis(t), upstream)
+
+			firstDone := make(chan *httptest.ResponseRecorder)
+			go func() { firstDone <- idempotentRequest(first, "order-1", body) }()
+			<-upstream.started
+
+			secondDone := make(chan *httptest.ResponseRecorder)
+			go func() { secondDone <- idempotentRequest(second, "order-1", body) }()
+			select {
+			case rec := <-secondDone:
+				t.Fatalf("duplicate answered before the first request finished: %d %s", rec.Code, rec.Body)
+			case <-time.After(300 * time.Millisecond):
+			}
+
+			close(upstream.release)
+			a, b := <-firstDone, <-secondDone
+			if b.Code != a.Code || b.Body.String() != a.Body.String() {
+				t.Errorf("duplicate got %d %s, want %d %s", b.Code, b.Body, a.Code, a.Body)
+			}
+			if b.Header().Get("Idempotent-Replayed") != "true" {
+				t.Errorf("duplicate not marked as replayed")
+			}
+			if n := upstream.calls.Load(); n != 1 {
+				t.Errorf("upstream called %d times, want 1", n)
+			}
+		})
+	}
+}
+
+func TestIdempotencyStoresOnlyFinalAnswers(t *testing.T) {
+	tests := []struct {
+		status int
+		stored bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusBadRequest, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			upstream := &testUpstream{statuses: []int{tt.status}}
+			handler := newIdempotentHandler(newTestRedis(t), upstream)
+			const body = `{"model":"gpt-4o","messages":[]}`
+
+			if rec := idempotentRequest(handler, "order-1", body); rec.Code != tt.status {
+				t.Fatalf("first: status %d, want %d", rec.Code, tt.status)
+			}
+			retry := idempotentRequest(handler, "order-1", body)
+			replayed := retry.Header().Get("Idempotent-Replayed") == "true"
+			if replayed != tt.stored {
+				t.Errorf("retry replayed %v, want %v", replayed, tt.stored)
+			}
+			if tt.stored && retry.Code != tt.status {
+				t.Errorf("retry status %d, want %d", retry.Code, tt.status)
+			}
+			if !tt.stored && retry.Code != http.StatusOK {
+				t.Errorf("retry status %d, want the upstream's 200", retry.Code)
+			}
+		})
+	}
+}
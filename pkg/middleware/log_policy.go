@@ -0,0 +1,279 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Body capture modes for stored request logs
+const (
+	CaptureMetadata  = "metadata"  // No bodies, only request metadata
+	CaptureTruncated = "truncated" // Bodies as text, cut at the size limit
+	CaptureFull      = "full"      // Bodies as JSON objects (text otherwise), cut only if over the limit
+	CaptureHashed    = "hashed"    // SHA-256 of each body only
+)
+
+// LogPolicyConfig controls what RequestLoggingMiddleware persists
+type LogPolicyConfig struct {
+	Mode           string          `mapstructure:"mode"`
+	MaxBodyBytes   int             `mapstructure:"max_body_bytes"` // 0 = unlimited
+	Redact         bool            `mapstructure:"redact"`
+	RedactPatterns []string        `mapstructure:"redact_patterns"`
+	Rules          []LogPolicyRule `mapstructure:"rules"`
+}
+
+// LogPolicyRule overrides the capture mode for matching requests.
+// Empty match fields match everything; the first matching rule wins.
+type LogPolicyRule struct {
+	Path         string `mapstructure:"path"`    // Regex on the request path
+	Model        string `mapstructure:"model"`   // Exact model name
+	APIKey       string `mapstructure:"api_key"` // Full key or its truncated log form
+	Mode         string `mapstructure:"mode"`
+	MaxBodyBytes int    `mapstructure:"max_body_bytes"`
+}
+
+// LogPolicy is a compiled LogPolicyConfig
+type LogPolicy struct {
+	mode         string
+	maxBodyBytes int
+	redact       bool
+	patterns     []*regexp.Regexp
+	rules        []compiledLogRule
+}
+
+type compiledLogRule struct {
+	LogPolicyRule
+	path *regexp.Regexp
+}
+
+// capturedBody is the persisted form of one request or response body
+type capturedBody struct {
+	Object    map[string]interface{}
+	Raw       string
+	Hash      string
+	Truncated bool
+}
+
+// Patterns redacted from stored bodies when redaction is enabled
+var defaultRedactPatterns = []string{
+	`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`,  // email
+	`\b\d{3}-\d{2}-\d{4}\b`,                           // ssn
+	`\b\d{4}[-\s]?\d{4}[-\s]?\d{4}[-\s]?\d{4}\b`,      // credit card
+	`\+\d{1,3}[-.\s]?\d{3}[-.\s]?\d{3}[-.\s]?\d{4}\b`, // phone: +1 555 123 4567
+	`\(\d{3}\)\s?\d{3}[-.\s]\d{4}\b`,                  // phone: (555) 123-4567
+	`\b\d{3}[-.]\d{3}[-.]\d{4}\b`,                     // phone: 555-123-4567; bare digit runs are IDs or timestamps
+	`\b(sk|relay|admin)[-_][a-zA-Z0-9_-]{16,}\b`,      // api keys
+	`(?i)\bbearer\s+[a-zA-Z0-9._~+/-]+=*`,             // bearer tokens
+}
+
+// Keys whose values are always redacted
+var sensitiveLogKeys = []string{"password", "secret", "api_key", "apikey", "authorization", "access_token"}
+
+const redactedValue = "***REDACTED***"
+
+// NewLogPolicy validates and compiles a logging policy
+func NewLogPolicy(cfg LogPolicyConfig) (*LogPolicy, error) {
+	p := &LogPolicy{
+		mode:         cfg.Mode,
+		maxBodyBytes: cfg.MaxBodyBytes,
+		redact:       cfg.Redact,
+	}
+	if p.mode == "" {
+		p.mode = CaptureFull
+	}
+	if !validCaptureMode(p.mode) {
+		return nil, fmt.Errorf("invalid capture mode %q", p.mode)
+	}
+
+	if cfg.Redact {
+		for _, pattern := range append(defaultRedactPatterns, cfg.RedactPatterns...) {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redact pattern %q: %w", pattern, err)
+			}
+			p.patterns = append(p.patterns, re)
+		}
+	}
+
+	for _, rule := range cfg.Rules {
+		if rule.Mode != "" && !validCaptureMode(rule.Mode) {
+			return nil, fmt.Errorf("invalid capture mode %q", rule.Mode)
+		}
+		compiled := compiledLogRule{LogPolicyRule: rule}
+		if rule.Path != "" {
+			re, err := regexp.Compile(rule.Path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rule path %q: %w", rule.Path, err)
+			}
+			compiled.path = re
+		}
+		p.rules = append(p.rules, compiled)
+	}
+
+	return p, nil
+}
+
+func validCaptureMode(mode string) bool {
+	switch mode {
+	case CaptureMetadata, CaptureTruncated, CaptureFull, CaptureHashed:
+		return true
+	}
+	return false
+}
+
+// resolve returns the capture mode and size limit for a request
+func (p *LogPolicy) resolve(path, model, apiKey string) (string, int) {
+	if p == nil {
+		return CaptureFull, 0
+	}
+
+	for _, rule := range p.rules {
+		if rule.path != nil && !rule.path.MatchString(path) {
+			continue
+		}
+		if rule.Model != "" && rule.Model != model {
+			continue
+		}
+		if rule.APIKey != "" && rule.APIKey != apiKey && rule.APIKey != TruncateKey(apiKey) {
+			continue
+		}
+
+		mode, max := p.mode, p.maxBodyBytes
+		if rule.Mode != "" {
+			mode = rule.Mode
+		}
+		if rule.MaxBodyBytes != 0 {
+			max = rule.MaxBodyBytes
+		}
+		return mode, max
+	}
+
+	return p.mode, p.maxBodyBytes
+}
+
+// capture converts a raw body into what gets persisted under the given mode.
+// Redaction runs before truncation so a cut never exposes half a secret.
+func (p *LogPolicy) capture(body []byte, mode string, max int) capturedBody {
+	if len(body) == 0 {
+		return capturedBody{}
+	}
+
+	switch mode {
+	case CaptureMetadata:
+		return capturedBody{}
+	case CaptureHashed:
+		sum := sha256.Sum256(body)
+		return capturedBody{Hash: hex.EncodeToString(sum[:])}
+	case CaptureFull:
+		var obj map[string]interface{}
+		if err := json.Unmarshal(body, &obj); err == nil {
+			obj = p.redactValue(obj).(map[string]interface{})
+			if max <= 0 {
+				return capturedBody{Object: obj}
+			}
+			if encoded, err := json.Marshal(obj); err == nil && len(encoded) <= max {
+				return capturedBody{Object: obj}
+			} else if err == nil {
+				return capturedBody{Raw: truncateUTF8(string(encoded), max), Truncated: true}
+			}
+		}
+	}
+
+	// Truncated mode, or a full-mode body that isn't a JSON object
+	raw := p.redactRaw(body)
+	if max > 0 && len(raw) > max {
+		return capturedBody{Raw: truncateUTF8(raw, max), Truncated: true}
+	}
+	return capturedBody{Raw: raw}
+}
+
+func (p *LogPolicy) redactValue(v interface{}) interface{} {
+	if p == nil || !p.redact {
+		return v
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for key, item := range val {
+			if isSensitiveLogKey(key) {
+				val[key] = redactedValue
+				continue
+			}
+			val[key] = p.redactValue(item)
+		}
+		return val
+	case []interface{}:
+		for i, item := range val {
+			val[i] = p.redactValue(item)
+		}
+		return val
+	case string:
+		return p.redactString(val)
+	default:
+		return v
+	}
+}
+
+func (p *LogPolicy) redactString(s string) string {
+	if p == nil || !p.redact {
+		return s
+	}
+	for _, re := range p.patterns {
+		s = re.ReplaceAllString(s, redactedValue)
+	}
+	return s
+}
+
+// redactRaw redacts a body stored as text. JSON is decoded so sensitive keys
+// are redacted as in full mode, then re-encoded with its keys sorted; only
+// string values go through the patterns, so timestamps, token counts and
+// numeric IDs are kept.
+func (p *LogPolicy) redactRaw(body []byte) string {
+	if p == nil || !p.redact {
+		return string(body)
+	}
+	if !json.Valid(body) {
+		return p.redactString(string(body))
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return p.redactString(string(body))
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(p.redactValue(v)); err != nil {
+		return p.redactString(string(body))
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// truncateUTF8 cuts s to at most max bytes without splitting a character
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+func isSensitiveLogKey(key string) bool {
+	lower := strings.ToLower(key)
+	for _, k := range sensitiveLogKeys {
+		if lower == k {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ngoyal88/relay/pkg/storage"
+)
+
+func TestLogPolicyTruncatesOnCharacterBoundary(t *testing.T) {
+	policy, err := NewLogPolicy(LogPolicyConfig{Mode: CaptureTruncated})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"content":"héllo wörld ✓✓✓"}`)
+	for max := 1; max < len(body); max++ {
+		got := policy.capture(body, CaptureTruncated, max)
+		if !utf8.ValidString(got.Raw) {
+			t.Fatalf("max %d: %q is not valid UTF-8", max, got.Raw)
+		}
+		if len(got.Raw) > max || !got.Truncated {
+			t.Fatalf("max %d: got %d bytes, truncated %v", max, len(got.Raw), got.Truncated)
+		}
+	}
+
+	// Full mode falls back to text when the encoded object is too large
+	got := policy.capture(body, CaptureFull, 17)
+	if !utf8.ValidString(got.Raw) || !got.Truncated {
+		t.Errorf("full mode: %q truncated %v", got.Raw, got.Truncated)
+	}
+}
+
+func TestLogPolicyRedactsPhoneNumbersButNotNumbers(t *testing.T) {
+	policy, err := NewLogPolicy(LogPolicyConfig{Redact: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"id":"chatcmpl-1","created":1718000000,"usage":{"total_tokens":5551234567},` +
+		`"content":"Call 555-123-4567, (555) 123-4567 or +1 555 123 4567. Order 5551234567 shipped."}`)
+	for _, mode := range []string{CaptureTruncated, CaptureFull} {
+		got := policy.capture(body, mode, 0)
+		text := got.Raw
+		if got.Object != nil {
+			text = got.Object["content"].(string)
+			if got.Object["created"].(float64) != 1718000000 {
+				t.Errorf("%s: created = %v", mode, got.Object["created"])
+			}
+		} else {
+			if !strings.Contains(text, `"created":1718000000`) || !strings.Contains(text, `"total_tokens":5551234567`) {
+				t.Errorf("%s: numeric values were redacted: %s", mode, text)
+			}
+		}
+
+		if n := strings.Count(text, redactedValue); n != 3 {
+			t.Errorf("%s: %d phone numbers redacted, want 3: %s", mode, n, text)
+		}
+		if !strings.Contains(text, "Order 5551234567 shipped") {
+			t.Errorf("%s: bare digit run was redacted: %s", mode, text)
+		}
+	}
+}
+
+func TestLogPolicyRedactsSensitiveKeysInText(t *testing.T) {
+	policy, err := NewLogPolicy(LogPolicyConfig{Redact: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"model":"gpt-4o","api_key":"sk-live-abc123","max_tokens":256,` +
+		`"metadata":{"Authorization":"Bearer sk-live-abc123"},"messages":[{"role":"user","content":"<hi> & bye"}]}`)
+	got := policy.capture(body, CaptureTruncated, 0)
+	if strings.Contains(got.Raw, "sk-live") {
+		t.Errorf("truncated mode stored a sensitive value: %s", got.Raw)
+	}
+	for _, want := range []string{`"api_key":"` + redactedValue + `"`, `"max_tokens":256`, `"content":"<hi> & bye"`} {
+		if !strings.Contains(got.Raw, want) {
+			t.Errorf("truncated mode: %s missing from %s", want, got.Raw)
+		}
+	}
+
+	// Full mode stores a non-object body as text the same way
+	got = policy.capture([]byte(`[{"password":"hunter2"}]`), CaptureFull, 0)
+	if got.Raw != `[{"password":"`+redactedValue+`"}]` {
+		t.Errorf("full mode array: %s", got.Raw)
+	}
+}
+
+func TestLogPolicyRuleMatchesAuthenticatedKey(t *testing.T) {
+	rdb := newTestRedis(t)
+	store := storage.NewRedisStore(rdb, time.Hour)
+	addTestKey(t, rdb, APIKey{Key: "relay_private_key_001", UserID: "private", Active: true})
+	addTestKey(t, rdb, APIKey{Key: "relay_regular_key_001", UserID: "regular", Active: true})
+
+	policy, err := NewLogPolicy(LogPolicyConfig{
+		Mode:  CaptureFull,
+		Rules: []LogPolicyRule{{APIKey: TruncateKey("relay_private_key_001"), Mode: CaptureHashed}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	})
+	handler := RequestLoggingMiddleware(store, true, policy)(AuthMiddleware(rdb, true)(upstream))
+
+	for _, key := range []string{"relay_private_key_001", "relay_regular_key_001"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+		req.Header.Set("Authorization", "Bearer "+key)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	private := waitForLog(t, store, storage.LogFilters{UserID: "private", Limit: 1})
+	if private.BodyCapture != CaptureHashed || private.RequestBody != nil || private.RequestBodyHash == "" {
+		t.Errorf("private key: capture %q, body %v, hash %q", private.BodyCapture, private.RequestBody, private.RequestBodyHash)
+	}
+	regular := waitForLog(t, store, storage.LogFilters{UserID: "regular", Limit: 1})
+	if regular.BodyCapture != CaptureFull || regular.RequestBody["model"] != "gpt-4o" {
+		t.Errorf("regular key: capture %q, body %v", regular.BodyCapture, regular.RequestBody)
+	}
+}
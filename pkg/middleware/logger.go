@@ -4,6 +4,8 @@ import (
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/ngoyal88/relay/pkg/apierror"
 )
 
 func RequestLogger(next http.Handler) http.Handler {
@@ -15,11 +17,12 @@ func RequestLogger(next http.Handler) http.Handler {
 
 		// Logic runs AFTER the request is finished
 		log.Printf(
-			"[%s] %s %s -> %v",
+			"[%s] %s %s -> %v (%s)",
 			r.Method,
 			r.URL.Path,
 			r.RemoteAddr,
 			time.Since(start),
+			apierror.RequestID(r.Context()),
 		)
 	})
 }
\ No newline at end of file
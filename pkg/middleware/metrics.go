@@ -14,6 +14,18 @@ var (
 		Name: "relay_cache_misses_total",
 		Help: "Number of cache misses that required upstream fetch",
 	})
+	cacheCoalesced = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "relay_cache_coalesced_total",
+		Help: "Number of requests served by waiting on an identical in-flight request",
+	})
+	semanticCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "relay_semantic_cache_hits_total",
+		Help: "Number of responses served from the semantic cache",
+	})
+	semanticCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "relay_semantic_cache_misses_total",
+		Help: "Number of semantic cache lookups without a close enough match",
+	})
 	requestTokenHistogram = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "relay_request_tokens",
 		Help:    "Token count per request payload",
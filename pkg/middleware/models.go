@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ngoyal88/relay/pkg/apierror"
+	"github.com/ngoyal88/relay/pkg/config"
+)
+
+// ListedModel is a model some upstream serves
+type ListedModel struct {
+	ID      string
+	OwnedBy string
+	Created int64 // Unix time, when the upstream reports it
+}
+
+// modelObject is an entry of GET /v1/models, priced from the models config
+type modelObject struct {
+	ID         string   `json:"id"`
+	Object     string   `json:"object"`
+	Created    int64    `json:"created"`
+	OwnedBy    string   `json:"owned_by"`
+	AliasFor   string   `json:"alias_for,omitempty"` // Model an alias resolves to
+	PricePer1K *float64 `json:"price_per_1k_tokens,omitempty"`
+}
+
+// ModelsEndpoint answers GET /v1/models and /v1/models/{id} from the models of
+// every upstream (see proxy.LoadBalancer.Models) instead of whichever one the
+// request would reach, plus the configured aliases. Models the caller's API key
+// may not use are left out. When list is nil or knows no models (a single
+// target, or targets Relay can't list with its own credentials), the upstream's
+// own GET /v1/models is fetched with the caller's credentials instead.
+// Must run inside AuthMiddleware.
+func ModelsEndpoint(list func() []ListedModel, cfgStore *config.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, isModels := modelsPath(r.URL.Path)
+			if r.Method != http.MethodGet || !isModels {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var listed []ListedModel
+			if list != nil {
+				listed = list()
+			}
+			if len(listed) == 0 {
+				var ok bool
+				if listed, ok = fetchModels(w, r, next); !ok {
+					return
+				}
+			}
+
+			apiKey, _ := GetAPIKeyFromContext(r.Context())
+			var prices map[string]float64
+			var aliases map[string]config.ModelAlias
+			if cfg := cfgStore.Get(); cfg != nil {
+				prices, aliases = cfg.Models, cfg.Aliases
+			}
+
+			objects := make([]modelObject, 0)
+			for _, m := range listed {
+				if _, ok := aliases[m.ID]; ok {
+					continue // The alias shadows it
+				}
+				objects = append(objects, modelObject{ID: m.ID, Object: "model", Created: m.Created, OwnedBy: m.OwnedBy})
+			}
+			for name, alias := range aliases {
+				objects = append(objects, modelObject{ID: name, Object: "model", OwnedBy: "relay", AliasFor: alias.Model})
+			}
+			sort.Slice(objects, func(i, j int) bool { return objects[i].ID < objects[j].ID })
+
+			data := make([]modelObject, 0, len(objects))
+			for _, obj := range objects {
+				if apiKey != nil && !apiKey.AllowsModel(obj.ID) && (obj.AliasFor == "" || !apiKey.AllowsModel(obj.AliasFor)) {
+					continue
+				}
+				if price, ok := prices[obj.ID]; ok {
+					obj.PricePer1K = &price
+				} else if price, ok := prices[obj.AliasFor]; ok && obj.AliasFor != "" {
+					obj.PricePer1K = &price
+				}
+				if id == "" {
+					data = append(data, obj)
+				} else if obj.ID == id {
+					writeJSON(w, obj)
+					return
+				}
+			}
+
+			if id != "" {
+				apierror.Write(w, r, apierror.CodeModelNotFound, "The model \""+id+"\" does not exist or you do not have access to it")
+				return
+			}
+			writeJSON(w, map[string]interface{}{"object": "list", "data": data})
+		})
+	}
+}
+
+// fetchModels asks the upstream for GET /v1/models, whatever model was
+// requested, so aliases and the API key's filter apply to the result. An
+// error or a reply that isn't a model list is passed on to the client as is.
+func fetchModels(w http.ResponseWriter, r *http.Request, next http.Handler) ([]ListedModel, bool) {
+	req := r.Clone(r.Context())
+	req.URL.Path = "/v1/models"
+	req.URL.RawPath = ""
+	req.URL.RawQuery = ""
+	rec := &bufferedResponse{header: make(http.Header)}
+	next.ServeHTTP(rec, req)
+
+	var list struct {
+		Data []struct {
+			ID      string `json:"id"`
+			OwnedBy string `json:"owned_by"`
+			Created int64  `json:"created"`
+		} `json:"data"`
+	}
+	if rec.status == http.StatusOK && json.Unmarshal(rec.body.Bytes(), &list) == nil && list.Data != nil {
+		models := make([]ListedModel, 0, len(list.Data))
+		for _, m := range list.Data {
+			models = append(models, ListedModel{ID: m.ID, OwnedBy: m.OwnedBy, Created: m.Created})
+		}
+		return models, true
+	}
+
+	for name, values := range rec.header {
+		w.Header()[name] = values
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+	return nil, false
+}
+
+// bufferedResponse holds a response so it can be inspected before it is sent
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// modelsPath matches /v1/models and /v1/models/{id}, returning the id
+func modelsPath(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, "/v1/models")
+	if !ok {
+		return "", false
+	}
+	if rest == "" || rest == "/" {
+		return "", true
+	}
+	id, ok := strings.CutPrefix(rest, "/")
+	return id, ok
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// requestModel reads the model from a JSON request body, restoring the body
+func requestModel(r *http.Request) string {
+	if r.Body == nil || r.Method != http.MethodPost || !strings.Contains(r.Header.Get("Content-Type"), "json") {
+		return ""
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	var req struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(body, &req)
+	return req.Model
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ngoyal88/relay/pkg/config"
+)
+
+// fakeModelsUpstream answers GET /v1/models like OpenAI, rejecting requests
+// without the caller's credential
+func fakeModelsUpstream(t *testing.T, calls *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path != "/v1/models" {
+			t.Errorf("upstream asked for %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "Bearer sk-caller" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key","param":null}}`))
+			return
+		}
+		w.Write([]byte(`{"object":"list","data":[` +
+			`{"id":"gpt-4o","object":"model","created":1715367049,"owned_by":"system"},` +
+			`{"id":"gpt-4o-mini","object":"model","created":1721172741,"owned_by":"system"}]}`))
+	})
+}
+
+func listModels(t *testing.T, handler http.Handler, path, auth string, apiKey *APIKey) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", auth)
+	if apiKey != nil {
+		req = req.WithContext(WithAPIKey(context.Background(), apiKey))
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestModelsEndpointAsksSingleTarget(t *testing.T) {
+	var calls atomic.Int32
+	price := 0.00015
+	cfgStore := config.NewStore(&config.Config{
+		Models:  map[string]float64{"gpt-4o-mini": price},
+		Aliases: map[string]config.ModelAlias{"fast": {Model: "gpt-4o-mini"}},
+	})
+	handler := ModelsEndpoint(nil, cfgStore)(fakeModelsUpstream(t, &calls))
+	apiKey := &APIKey{Key: "relay_key", AllowedModels: []string{"gpt-4o-mini"}}
+
+	rec := listModels(t, handler, "/v1/models", "Bearer sk-caller", apiKey)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var list struct {
+		Data []modelObject `json:"data"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	want := []modelObject{
+		{ID: "fast", Object: "model", OwnedBy: "relay", AliasFor: "gpt-4o-mini", PricePer1K: &price},
+		{ID: "gpt-4o-mini", Object: "model", Created: 1721172741, OwnedBy: "system", PricePer1K: &price},
+	}
+	if !reflect.DeepEqual(list.Data, want) {
+		t.Errorf("models = %s", rec.Body)
+	}
+
+	// Aliases resolve by ID too, though the upstream doesn't know them
+	rec = listModels(t, handler, "/v1/models/fast", "Bearer sk-caller", apiKey)
+	if rec.Code != http.StatusOK {
+		t.Errorf("alias lookup: status %d: %s", rec.Code, rec.Body)
+	}
+	rec = listModels(t, handler, "/v1/models/gpt-4o", "Bearer sk-caller", apiKey)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("model outside allowed_models: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// Upstream errors are passed on
+	rec = listModels(t, handler, "/v1/models", "Bearer sk-wrong", nil)
+	if rec.Code != http.StatusUnauthorized || !json.Valid(rec.Body.Bytes()) {
+		t.Errorf("bad credential: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestModelsEndpointPrefersCatalogue(t *testing.T) {
+	var calls atomic.Int32
+	var catalogue []ListedModel
+	handler := ModelsEndpoint(func() []ListedModel { return catalogue }, config.NewStore(&config.Config{}))(fakeModelsUpstream(t, &calls))
+
+	// Nothing listed yet: the upstream is asked
+	listModels(t, handler, "/v1/models", "Bearer sk-caller", nil)
+	if calls.Load() != 1 {
+		t.Fatalf("upstream asked %d times with an empty catalogue, want 1", calls.Load())
+	}
+
+	catalogue = []ListedModel{{ID: "llama3", OwnedBy: "ollama"}}
+	rec := listModels(t, handler, "/v1/models", "Bearer sk-caller", nil)
+	if calls.Load() != 1 {
+		t.Errorf("upstream asked although the catalogue has models")
+	}
+	var list struct {
+		Data []modelObject `json:"data"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list.Data) != 1 || list.Data[0].ID != "llama3" {
+		t.Errorf("models = %s", rec.Body)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/go-redis/redis_rate/v10"
+	"github.com/ngoyal88/relay/pkg/apierror"
 	"github.com/ngoyal88/relay/pkg/cache"
 	"github.com/ngoyal88/relay/pkg/config"
 	"golang.org/x/time/rate"
@@ -24,7 +25,34 @@ func NewRateLimiter(rdb *cache.Client, cfgStore *config.Store) func(http.Handler
 	if cfgStore == nil {
 		return func(next http.Handler) http.Handler { return next }
 	}
+	return newRateLimiter(rdb, "", func() *config.RateLimitConfig {
+		if cfg := cfgStore.Get(); cfg != nil {
+			return &cfg.RateLimit
+		}
+		return nil
+	})
+}
+
+// NewRouteRateLimiter enforces a route's own ratelimit settings, read live from
+// the route of that name, with buckets separate from the global limiter's
+func NewRouteRateLimiter(rdb *cache.Client, cfgStore *config.Store, route string) func(http.Handler) http.Handler {
+	return newRateLimiter(rdb, "route:"+route+":", func() *config.RateLimitConfig {
+		cfg := cfgStore.Get()
+		if cfg == nil {
+			return nil
+		}
+		for _, rc := range cfg.Routes {
+			if rc.RouteName() == route {
+				return rc.RateLimit
+			}
+		}
+		return nil
+	})
+}
 
+// newRateLimiter builds a limiter whose settings come from limits on every
+// request; a nil result disables it. prefix separates its Redis buckets.
+func newRateLimiter(rdb *cache.Client, prefix string, limits func() *config.RateLimitConfig) func(http.Handler) http.Handler {
 	// In-memory (per-instance) limiter with dynamic updates
 	if rdb == nil {
 		var (
@@ -36,33 +64,33 @@ func NewRateLimiter(rdb *cache.Client, cfgStore *config.Store) func(http.Handler
 
 		return func(next http.Handler) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				cfg := cfgStore.Get()
-				if cfg == nil || !cfg.RateLimit.Enabled {
+				rl := limits()
+				if rl == nil || !rl.Enabled {
 					next.ServeHTTP(w, r)
 					return
 				}
 
-				if cfg.RateLimit.RPS <= 0 {
-					http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				if rl.RPS <= 0 {
+					apierror.Write(w, r, apierror.CodeRateLimitExceeded, "Too Many Requests")
 					return
 				}
 
-				burst := cfg.RateLimit.Burst
+				burst := rl.Burst
 				if burst < 1 {
 					burst = 1
 				}
 
 				mu.Lock()
-				if limiter == nil || cfg.RateLimit.RPS != lastRPS || burst != lastB {
-					limiter = rate.NewLimiter(rate.Limit(cfg.RateLimit.RPS), burst)
-					lastRPS = cfg.RateLimit.RPS
+				if limiter == nil || rl.RPS != lastRPS || burst != lastB {
+					limiter = rate.NewLimiter(rate.Limit(rl.RPS), burst)
+					lastRPS = rl.RPS
 					lastB = burst
 				}
 				l := limiter
 				mu.Unlock()
 
 				if !l.Allow() {
-					http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+					apierror.Write(w, r, apierror.CodeRateLimitExceeded, "Too Many Requests")
 					return
 				}
 				next.ServeHTTP(w, r)
@@ -75,19 +103,19 @@ func NewRateLimiter(rdb *cache.Client, cfgStore *config.Store) func(http.Handler
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cfg := cfgStore.Get()
-			if cfg == nil || !cfg.RateLimit.Enabled {
+			rl := limits()
+			if rl == nil || !rl.Enabled {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			limit, ok := buildLimit(cfg.RateLimit.RPS, cfg.RateLimit.Burst)
+			limit, ok := buildLimit(rl.RPS, rl.Burst)
 			if !ok {
-				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				apierror.Write(w, r, apierror.CodeRateLimitExceeded, "Too Many Requests")
 				return
 			}
 
-			key := clientKey(r)
+			key := prefix + clientKey(r)
 			ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
 			defer cancel()
 
@@ -106,7 +134,7 @@ func NewRateLimiter(rdb *cache.Client, cfgStore *config.Store) func(http.Handler
 					}
 					w.Header().Set("Retry-After", strconv.FormatInt(int64(retrySeconds), 10))
 				}
-				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				apierror.Write(w, r, apierror.CodeRateLimitExceeded, "Too Many Requests")
 				return
 			}
 
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/ngoyal88/relay/pkg/apierror"
+)
+
+// maxRequestIDLen bounds an ID supplied by the client
+const maxRequestIDLen = 128
+
+// RequestID tags each request with an ID, returned in X-Relay-Request-Id and in
+// error bodies and recorded in the request log. A well-formed ID sent by the
+// client in the same header is kept, so callers can trace their own IDs. A
+// request already tagged by an outer RequestID passes through unchanged.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apierror.RequestID(r.Context()) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id := r.Header.Get(apierror.Header)
+		if !validRequestID(id) {
+			id = apierror.NewRequestID()
+		}
+		w.Header().Set(apierror.Header, id)
+		next.ServeHTTP(w, r.WithContext(apierror.WithRequestID(r.Context(), id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -8,13 +8,25 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/ngoyal88/relay/pkg/apierror"
 	"github.com/ngoyal88/relay/pkg/storage"
 )
 
+// pendingLogs counts entries still being saved, so shutdown can wait for them
+var pendingLogs sync.WaitGroup
+
+// WaitForRequestLogs blocks until every entry handed to the store has been
+// saved or given up on; call it after the server stops and before closing the store.
+func WaitForRequestLogs() {
+	pendingLogs.Wait()
+}
+
 // RequestLoggingMiddleware logs requests into the configured store.
-func RequestLoggingMiddleware(store storage.Store, enableLogging bool) func(http.Handler) http.Handler {
+// The policy decides how much of each body is persisted; nil stores full bodies.
+func RequestLoggingMiddleware(store storage.Store, enableLogging bool, policy *LogPolicy) func(http.Handler) http.Handler {
 	if !enableLogging || store == nil {
 		return func(next http.Handler) http.Handler { return next }
 	}
@@ -24,12 +36,13 @@ func RequestLoggingMiddleware(store storage.Store, enableLogging bool) func(http
 			start := time.Now()
 
 			var requestBody map[string]interface{}
+			var requestBytes []byte
 			if r.Method == http.MethodPost || r.Method == http.MethodPut {
-				bodyBytes, _ := io.ReadAll(r.Body)
-				r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+				requestBytes, _ = io.ReadAll(r.Body)
+				r.Body = io.NopCloser(bytes.NewBuffer(requestBytes))
 
-				if len(bodyBytes) > 0 {
-					json.Unmarshal(bodyBytes, &requestBody)
+				if len(requestBytes) > 0 {
+					json.Unmarshal(requestBytes, &requestBody)
 				}
 			}
 
@@ -38,6 +51,14 @@ func RequestLoggingMiddleware(store storage.Store, enableLogging bool) func(http
 				statusCode:     http.StatusOK,
 			}
 
+			// Filled in by CachingMiddleware with the original cost of a replayed response
+			savings := &cacheSavings{}
+			r = r.WithContext(context.WithValue(r.Context(), cacheSavingsContextKey, savings))
+
+			// Filled in by AuthMiddleware, which runs inside the route chain
+			caller := &logCaller{}
+			r = r.WithContext(context.WithValue(r.Context(), logCallerContextKey, caller))
+
 			next.ServeHTTP(wrapper, r)
 
 			var responseBody map[string]interface{}
@@ -45,17 +66,31 @@ func RequestLoggingMiddleware(store storage.Store, enableLogging bool) func(http
 				json.Unmarshal(wrapper.body.Bytes(), &responseBody)
 			}
 
-			var apiKeyStr, userID string
-			if apiKey, ok := GetAPIKeyFromContext(r.Context()); ok {
-				apiKeyStr = truncateKey(apiKey.Key)
+			var apiKeyStr, fullKey, userID, teamID string
+			if apiKey := caller.apiKey; apiKey != nil {
+				fullKey = apiKey.Key
+				apiKeyStr = TruncateKey(apiKey.Key)
 				userID = apiKey.UserID
+				teamID = apiKey.TeamID
 			}
 
-			cacheHit := wrapper.Header().Get("X-Cache") == "HIT"
+			cacheStatus := wrapper.Header().Get("X-Cache")
+			cacheHit := cacheStatus == "HIT" || cacheStatus == "SEMANTIC-HIT" || cacheStatus == "COALESCED"
 			model, _ := requestBody["model"].(string)
 
+			// Usage is read before capture so redaction never hides it
+			var usage map[string]interface{}
+			if u, ok := responseBody["usage"].(map[string]interface{}); ok {
+				usage = u
+			}
+
+			mode, maxBytes := policy.resolve(r.URL.Path, model, fullKey)
+			reqCapture := policy.capture(requestBytes, mode, maxBytes)
+			respCapture := policy.capture(wrapper.body.Bytes(), mode, maxBytes)
+
 			entry := storage.RequestLog{
 				ID:           generateLogID(),
+				RequestID:    apierror.RequestID(r.Context()),
 				Timestamp:    start,
 				Method:       r.Method,
 				Path:         r.URL.Path,
@@ -63,23 +98,56 @@ func RequestLoggingMiddleware(store storage.Store, enableLogging bool) func(http
 				RemoteAddr:   r.RemoteAddr,
 				APIKey:       apiKeyStr,
 				UserID:       userID,
-				RequestBody:  requestBody,
-				ResponseBody: responseBody,
+				TeamID:       teamID,
+				RequestBody:  reqCapture.Object,
+				ResponseBody: respCapture.Object,
 				StatusCode:   wrapper.statusCode,
 				Duration:     time.Since(start),
 				Model:        model,
 				CacheHit:     cacheHit,
+
+				BodyCapture:      mode,
+				RequestBodyRaw:   reqCapture.Raw,
+				ResponseBodyRaw:  respCapture.Raw,
+				RequestBodyHash:  reqCapture.Hash,
+				ResponseBodyHash: respCapture.Hash,
+				BodyTruncated:    reqCapture.Truncated || respCapture.Truncated,
 			}
 
+			if alias, ok := GetModelAliasFromContext(r.Context()); ok {
+				entry.ModelAlias = alias
+			}
 			if tokens, ok := GetTokenCountFromContext(r.Context()); ok {
-				entry.TokensUsed = tokens
+				entry.TokensIn = tokens
 			}
 
+			// Prefer the upstream's own usage report when present
+			if usage != nil {
+				if n, ok := usage["prompt_tokens"].(float64); ok {
+					entry.TokensIn = int(n)
+				}
+				if n, ok := usage["completion_tokens"].(float64); ok {
+					entry.TokensOut = int(n)
+				}
+			}
+			entry.TokensUsed = entry.TokensIn + entry.TokensOut
+
 			if costUSD, ok := GetTokenCostFromContext(r.Context()); ok {
 				entry.CostUSD = costUSD
 			}
 
+			// Cached and idempotent replays cost nothing; what the original request cost is saved
+			if cacheHit || wrapper.Header().Get("Idempotent-Replayed") == "true" {
+				entry.CostSaved = entry.CostUSD
+				if savings.found {
+					entry.CostSaved = savings.costUSD
+				}
+				entry.CostUSD = 0
+			}
+
+			pendingLogs.Add(1)
 			go func(logEntry storage.RequestLog) {
+				defer pendingLogs.Done()
 				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 				defer cancel()
 
@@ -91,6 +159,14 @@ func RequestLoggingMiddleware(store storage.Store, enableLogging bool) func(http
 	}
 }
 
+// logCaller carries the key AuthMiddleware accepted out to
+// RequestLoggingMiddleware, which can't see the context auth passes on
+type logCaller struct {
+	apiKey *APIKey
+}
+
+const logCallerContextKey contextKey = "log_caller"
+
 type loggingResponseWrapper struct {
 	http.ResponseWriter
 	body       bytes.Buffer
@@ -112,7 +188,8 @@ func generateLogID() string {
 	return fmt.Sprintf("log_%d", time.Now().UnixNano())
 }
 
-func truncateKey(key string) string {
+// TruncateKey shortens an API key to the identifier stored in logs and stats.
+func TruncateKey(key string) string {
 	if len(key) == 0 {
 		return ""
 	}
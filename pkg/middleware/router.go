@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Route is one entry of a route table
+type Route struct {
+	Name    string
+	Prefix  string         // Matches the prefix itself and paths below it
+	Pattern *regexp.Regexp // Used when Prefix is empty
+	Handler http.Handler
+}
+
+func (rt Route) matches(path string) bool {
+	if rt.Prefix != "" {
+		prefix := strings.TrimSuffix(rt.Prefix, "/")
+		return path == prefix || strings.HasPrefix(path, prefix+"/")
+	}
+	return rt.Pattern != nil && rt.Pattern.MatchString(path)
+}
+
+// RouteTable sends each request to the first route matching its path, and the
+// rest to fallback
+func RouteTable(routes []Route, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rt := range routes {
+			if rt.matches(r.URL.Path) {
+				rt.Handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
+
+// StripPrefix removes prefix from the path when present, unlike http.StripPrefix
+// which rejects requests without it
+func StripPrefix(prefix string) func(http.Handler) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path, ok := strings.CutPrefix(r.URL.Path, prefix)
+			if !ok || (path != "" && path[0] != '/') {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if path == "" {
+				path = "/"
+			}
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = path
+			r2.URL.RawPath = ""
+			next.ServeHTTP(w, r2)
+		})
+	}
+}
+
+// Timeout bounds the whole request, streaming included; the proxy reports an
+// expired deadline as upstream_timeout
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/ai"
+	"github.com/ngoyal88/relay/pkg/cache"
+)
+
+// semanticRequest is the subset of a chat completion request the semantic cache needs
+type semanticRequest struct {
+	Model    string            `json:"model"`
+	Stream   bool              `json:"stream"`
+	Messages []semanticMessage `json:"messages"`
+
+	// Change what an answer may look like, so they are part of the partition
+	Tools          json.RawMessage `json:"tools,omitempty"`
+	ResponseFormat json.RawMessage `json:"response_format,omitempty"`
+}
+
+type semanticMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// SemanticCacheMiddleware serves responses cached for similar (not identical) prompts.
+// The final user message is embedded and compared against earlier prompts for the
+// same model, tenant and conversation (system prompt, earlier turns and tools);
+// matches at or above threshold are replayed as SEMANTIC-HIT. The same cache
+// policy as CachingMiddleware decides whether a request may be served or stored.
+func SemanticCacheMiddleware(index *cache.SemanticIndex, embedder *ai.EmbeddingClient, threshold float64, cfg CacheConfig) func(http.Handler) http.Handler {
+	rules := newCacheRules(cfg)
+	headers := cfg.Headers
+	if len(headers) == 0 {
+		headers = defaultCacheHeaders
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			bodyBytes, _ := io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes)) // Refill
+
+			policy := rules.policy(r, bodyBytes)
+			if !policy.lookup && !policy.store {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var req semanticRequest
+			if err := json.Unmarshal(bodyBytes, &req); err != nil || req.Stream {
+				next.ServeHTTP(w, r)
+				return
+			}
+			prompt, at := lastUserMessage(req)
+			if prompt == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+			defer cancel()
+
+			vector, err := embedder.Embed(ctx, prompt)
+			if err != nil {
+				log.Printf("⚠️ [SEMANTIC CACHE] embedding failed: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			partition := semanticPartition(r, req, at, policy.key.Namespace)
+			if policy.lookup {
+				if match, ok := index.Search(ctx, partition, vector, threshold); ok {
+					entry, err := cache.DecodeEntry(match.Body)
+					if err == nil {
+						semanticCacheHits.Inc()
+						w.Header().Set("X-Cache-Similarity", fmt.Sprintf("%.4f", match.Similarity))
+						writeCached(w, r, entry, "SEMANTIC-HIT")
+						log.Printf("⚡ [SEMANTIC CACHE] HIT (similarity %.4f)", match.Similarity)
+						return
+					}
+					log.Printf("⚠️ [SEMANTIC CACHE] Skipping unreadable entry: %v", err)
+				}
+			}
+			semanticCacheMisses.Inc()
+
+			spy := &responseWrapper{ResponseWriter: w}
+			next.ServeHTTP(spy, r)
+
+			if !policy.store || spy.statusCode != http.StatusOK {
+				return
+			}
+			entry := newCacheEntry(r, spy, headers)
+			go func(entry *cache.Entry, ttl time.Duration) {
+				data, err := entry.Encode()
+				if err != nil {
+					log.Printf("⚠️ [SEMANTIC CACHE] Failed to encode entry: %v", err)
+					return
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+
+				if err := index.Add(ctx, partition, vector, cfg.Compressor.Compress(data), ttl); err != nil {
+					log.Printf("⚠️ [SEMANTIC CACHE] Failed to save: %v", err)
+				}
+			}(entry, policy.ttl)
+		})
+	}
+}
+
+// lastUserMessage returns the text of the final user message and its index.
+// Content may be a plain string or an array of typed parts.
+func lastUserMessage(req semanticRequest) (string, int) {
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		msg := req.Messages[i]
+		if msg.Role != "user" {
+			continue
+		}
+
+		var text string
+		if err := json.Unmarshal(msg.Content, &text); err == nil {
+			return text, i
+		}
+
+		var parts []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(msg.Content, &parts); err == nil {
+			texts := make([]string, 0, len(parts))
+			for _, p := range parts {
+				if p.Type == "text" {
+					texts = append(texts, p.Text)
+				}
+			}
+			return strings.Join(texts, "\n"), i
+		}
+		return "", i
+	}
+	return "", -1
+}
+
+// semanticPartition keeps tenants, models, cache namespaces and conversations
+// from sharing cached answers. Only the final user message is compared by
+// embedding; everything around it (system prompt, earlier turns, tools) has to
+// match exactly, so "yes" in one conversation never answers "yes" in another.
+func semanticPartition(r *http.Request, req semanticRequest, prompt int, namespace string) string {
+	tenant := ""
+	if apiKey, ok := GetAPIKeyFromContext(r.Context()); ok {
+		tenant = apiKey.TeamID
+		if tenant == "" {
+			tenant = apiKey.UserID
+		}
+	}
+
+	conversation := append(slices.Clone(req.Messages[:prompt]), req.Messages[prompt+1:]...)
+	h := sha256.New()
+	json.NewEncoder(h).Encode(struct {
+		Namespace      string
+		Messages       []semanticMessage
+		Tools          json.RawMessage
+		ResponseFormat json.RawMessage
+	}{namespace, conversation, req.Tools, req.ResponseFormat})
+	return fmt.Sprintf("%s:%s:%x", req.Model, tenant, h.Sum(nil)[:8])
+}
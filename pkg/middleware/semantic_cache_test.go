@@ -0,0 +1,217 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/ai"
+	"github.com/ngoyal88/relay/pkg/cache"
+)
+
+// newTestEmbedder serves embeddings where prompts differing only in case and
+// punctuation are identical and other prompts are orthogonal
+func newTestEmbedder(t *testing.T) *ai.EmbeddingClient {
+	t.Helper()
+	dims := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		text := strings.Trim(strings.ToLower(req.Input), "?!. ")
+		dim, ok := dims[text]
+		if !ok {
+			dim = len(dims)
+			dims[text] = dim
+		}
+		vec := make([]float32, 16)
+		vec[dim%16] = 1
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": []map[string]interface{}{{"embedding": vec}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return ai.NewEmbeddingClient(srv.URL, "test-embedding", "")
+}
+
+type semanticTest struct {
+	t        *testing.T
+	rdb      *cache.Client
+	handler  http.Handler
+	upstream atomic.Int32
+}
+
+func newSemanticTest(t *testing.T, cfg CacheConfig) *semanticTest {
+	st := &semanticTest{t: t, rdb: newTestRedis(t)}
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := st.upstream.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Openai-Processing-Ms", "42")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": n})
+	})
+	index := cache.NewSemanticIndex(st.rdb, time.Hour, 100)
+	st.handler = SemanticCacheMiddleware(index, newTestEmbedder(t), 0.9, cfg)(upstream)
+	return st
+}
+
+// send posts a chat request and waits for a miss to be stored
+func (st *semanticTest) send(body string) *httptest.ResponseRecorder {
+	st.t.Helper()
+	stored := st.stored()
+	before := st.upstream.Load()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	st.handler.ServeHTTP(rec, req)
+
+	if st.upstream.Load() != before {
+		deadline := time.Now().Add(time.Second)
+		for st.stored() == stored && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return rec
+}
+
+// stored counts the semantic index entries
+func (st *semanticTest) stored() int {
+	keys, _ := st.rdb.Redis().Keys(context.Background(), "semcache:idx:*").Result()
+	n := 0
+	for _, key := range keys {
+		n += int(st.rdb.Redis().HLen(context.Background(), key).Val())
+	}
+	return n
+}
+
+func chatBody(system string, prompts ...string) string {
+	var messages []map[string]string
+	if system != "" {
+		messages = append(messages, map[string]string{"role": "system", "content": system})
+	}
+	for i, p := range prompts {
+		if i > 0 {
+			messages = append(messages, map[string]string{"role": "assistant", "content": "ok"})
+		}
+		messages = append(messages, map[string]string{"role": "user", "content": p})
+	}
+	data, _ := json.Marshal(map[string]interface{}{"model": "gpt-4o", "temperature": 0, "messages": messages})
+	return string(data)
+}
+
+func TestSemanticCacheReplaysStoredEntry(t *testing.T) {
+	st := newSemanticTest(t, CacheConfig{})
+
+	st.send(chatBody("", "What is Go?"))
+	rec := st.send(chatBody("", "what is go"))
+
+	if got := rec.Header().Get("X-Cache"); got != "SEMANTIC-HIT" {
+		t.Fatalf("X-Cache = %q, want SEMANTIC-HIT", got)
+	}
+	if st.upstream.Load() != 1 {
+		t.Errorf("upstream called %d times, want 1", st.upstream.Load())
+	}
+	if got := rec.Header().Get("Openai-Processing-Ms"); got != "42" {
+		t.Errorf("stored upstream header not replayed: %q", got)
+	}
+	if !strings.Contains(rec.Body.String(), `"id":1`) {
+		t.Errorf("body = %s", rec.Body)
+	}
+}
+
+func TestSemanticCacheComparesWholeConversation(t *testing.T) {
+	st := newSemanticTest(t, CacheConfig{})
+
+	st.send(chatBody("You are a travel agent.", "Book me a flight", "yes"))
+	for _, body := range []string{
+		chatBody("You are a bank teller.", "Book me a flight", "yes"), // Other system prompt
+		chatBody("You are a travel agent.", "Cancel my hotel", "yes"), // Other earlier turn
+	} {
+		if rec := st.send(body); rec.Header().Get("X-Cache") == "SEMANTIC-HIT" {
+			t.Errorf("answer reused across conversations for %s", body)
+		}
+	}
+
+	if rec := st.send(chatBody("You are a travel agent.", "Book me a flight", "Yes!")); rec.Header().Get("X-Cache") != "SEMANTIC-HIT" {
+		t.Errorf("same conversation: X-Cache = %q, want SEMANTIC-HIT", rec.Header().Get("X-Cache"))
+	}
+}
+
+func TestSemanticCacheFollowsCachePolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    CacheConfig
+		body   string
+		header http.Header
+	}{
+		{
+			name:   "no-store",
+			header: http.Header{"Cache-Control": {"no-store"}},
+		},
+		{
+			name:   "ttl zero",
+			header: http.Header{"X-Relay-Cache-Ttl": {"0"}},
+		},
+		{
+			name: "not deterministic",
+			cfg:  CacheConfig{RequireDeterministic: true},
+			body: `{"model":"gpt-4o","temperature":1,"messages":[{"role":"user","content":"Tell me a joke"}]}`,
+		},
+		{
+			name: "route disabled",
+			cfg:  CacheConfig{Routes: []CacheRoute{{Path: "^/v1/chat", Disabled: true}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newSemanticTest(t, tt.cfg)
+			body := tt.body
+			if body == "" {
+				body = chatBody("", "Tell me a joke")
+			}
+
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+				for name, values := range tt.header {
+					req.Header[name] = values
+				}
+				rec := httptest.NewRecorder()
+				st.handler.ServeHTTP(rec, req)
+				if rec.Header().Get("X-Cache") == "SEMANTIC-HIT" {
+					t.Fatalf("request %d served from the semantic cache", i+1)
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			if keys, _ := st.rdb.Redis().Keys(context.Background(), "semcache:*").Result(); len(keys) != 0 {
+				t.Errorf("stored %v", keys)
+			}
+		})
+	}
+}
+
+// The exact-match cache wraps the semantic one in the gateway
+func TestSemanticHitsAreNotStoredAsExactHits(t *testing.T) {
+	st := newSemanticTest(t, CacheConfig{})
+	semantic := st.handler
+	st.handler = CachingMiddleware(cache.NewRedisBackend(st.rdb), CacheConfig{TTL: time.Hour})(semantic)
+
+	st.send(chatBody("", "What is Go?"))
+	for i := 0; i < 2; i++ {
+		rec := st.send(chatBody("", "what is go"))
+		if got := rec.Header().Get("X-Cache"); got != "SEMANTIC-HIT" {
+			t.Fatalf("similar request %d: X-Cache = %q, want SEMANTIC-HIT", i+1, got)
+		}
+		time.Sleep(100 * time.Millisecond) // Exact-cache saves are asynchronous
+	}
+	if rec := st.send(chatBody("", "What is Go?")); rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("original request: X-Cache = %q, want HIT", rec.Header().Get("X-Cache"))
+	}
+	if st.upstream.Load() != 1 {
+		t.Errorf("upstream called %d times, want 1", st.upstream.Load())
+	}
+}
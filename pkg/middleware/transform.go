@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+
+	"github.com/ngoyal88/relay/pkg/apierror"
 )
 
 // TransformConfig defines transformation rules
@@ -47,7 +49,7 @@ func TransformMiddleware(config TransformConfig) func(http.Handler) http.Handler
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Check path filtering
 			if !isPathAllowed(r.URL.Path, allowedPatterns, blockedPatterns) {
-				http.Error(w, "Path not allowed", http.StatusForbidden)
+				apierror.Write(w, r, apierror.CodePathNotAllowed, "Path not allowed")
 				return
 			}
 
@@ -57,7 +59,7 @@ func TransformMiddleware(config TransformConfig) func(http.Handler) http.Handler
 			// Transform request body
 			if r.Method == http.MethodPost || r.Method == http.MethodPut {
 				if err := transformRequestBody(r, config); err != nil {
-					http.Error(w, "Request transformation failed", http.StatusBadRequest)
+					apierror.Write(w, r, apierror.CodeInvalidRequest, "Request transformation failed")
 					return
 				}
 			}
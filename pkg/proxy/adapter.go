@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ngoyal88/relay/pkg/apierror"
+)
+
+type contextKey string
+
+// Adapter lets a target speak a provider's native API while clients keep
+// using the OpenAI format
+type Adapter interface {
+	// PrepareRequest rewrites an OpenAI-style request for the provider.
+	// Errors are the client's fault and are returned as 4xx responses.
+	PrepareRequest(r *http.Request) (*http.Request, error)
+	// ModifyResponse converts the provider's response back to the OpenAI format
+	ModifyResponse(resp *http.Response) error
+}
+
+// Prober is implemented by adapters whose backends have their own health and
+// model listing endpoints
+type Prober interface {
+	// Probe checks the backend at base and returns the models it serves
+	Probe(ctx context.Context, base *url.URL) ([]string, error)
+}
+
+// newAdapter returns the adapter for a target's provider; OpenAI itself needs none
+func newAdapter(cfg TargetConfig, target *url.URL) (Adapter, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "", "openai":
+		if cfg.APIKey == "" {
+			return nil, nil
+		}
+		return &openAIAdapter{apiKey: cfg.APIKey}, nil
+	case "gemini":
+		return newGeminiAdapter(target, cfg.APIKey), nil
+	case "ollama":
+		return &ollamaAdapter{apiKey: cfg.APIKey}, nil
+	case "openai-compatible":
+		return &compatAdapter{apiKey: cfg.APIKey}, nil
+	case "azure":
+		return newAzureAdapter(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+	}
+}
+
+// providerName is the provider reported as a model's owner
+func providerName(provider string) string {
+	if provider == "" {
+		return "openai"
+	}
+	return strings.ToLower(provider)
+}
+
+// openAIAdapter sends a configured key to OpenAI in place of the client's.
+// Requests are otherwise untouched; the key also lets Relay list the models.
+type openAIAdapter struct {
+	apiKey string
+}
+
+// PrepareRequest implements Adapter
+func (a *openAIAdapter) PrepareRequest(r *http.Request) (*http.Request, error) {
+	pr := r.Clone(r.Context())
+	pr.Header.Set("Authorization", "Bearer "+a.apiKey)
+	return pr, nil
+}
+
+// ModifyResponse implements Adapter
+func (a *openAIAdapter) ModifyResponse(resp *http.Response) error {
+	return nil
+}
+
+// listModels implements modelLister using GET /v1/models
+func (a *openAIAdapter) listModels(ctx context.Context, base *url.URL) ([]string, error) {
+	return listOpenAIModels(ctx, base, a.apiKey)
+}
+
+// compatAdapter is for self-hosted OpenAI-compatible servers (vLLM,
+// llama.cpp, LM Studio...): requests pass through, and the model list doubles
+// as the health check
+type compatAdapter struct {
+	apiKey string // Replaces the client's credential when set
+}
+
+// PrepareRequest implements Adapter
+func (a *compatAdapter) PrepareRequest(r *http.Request) (*http.Request, error) {
+	if a.apiKey == "" {
+		return r, nil
+	}
+	pr := r.Clone(r.Context())
+	pr.Header.Set("Authorization", "Bearer "+a.apiKey)
+	return pr, nil
+}
+
+// ModifyResponse implements Adapter
+func (a *compatAdapter) ModifyResponse(resp *http.Response) error {
+	return nil
+}
+
+// Probe implements Prober using GET /v1/models
+func (a *compatAdapter) Probe(ctx context.Context, base *url.URL) ([]string, error) {
+	return listOpenAIModels(ctx, base, a.apiKey)
+}
+
+func listOpenAIModels(ctx context.Context, base *url.URL, apiKey string) ([]string, error) {
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := probeJSON(ctx, base, "/v1/models", apiKey, &list); err != nil {
+		return nil, err
+	}
+	models := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		models = append(models, m.ID)
+	}
+	return models, nil
+}
+
+// probeJSON GETs path on the backend's host and decodes the JSON reply
+func probeJSON(ctx context.Context, base *url.URL, path, apiKey string, out interface{}) error {
+	u := url.URL{Scheme: base.Scheme, Host: base.Host, Path: path}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	return fetchJSON(req, out)
+}
+
+// fetchJSON sends req and decodes a 200 reply into out
+func fetchJSON(req *http.Request, out interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", req.URL.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// modifyResponse chains the gzip decoding every target needs with the
+// adapter's conversion and the error envelope
+func modifyResponse(adapter Adapter) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if err := decodeResponse(resp); err != nil {
+			return err
+		}
+		if adapter != nil {
+			if err := adapter.ModifyResponse(resp); err != nil {
+				return err
+			}
+		}
+		return normalizeError(resp)
+	}
+}
+
+// rewriteRequest clones r for the provider with a new path and JSON body,
+// keeping call in the context for the response conversion
+func rewriteRequest(r *http.Request, key contextKey, call interface{}, path, query string, body []byte) *http.Request {
+	pr := r.Clone(context.WithValue(r.Context(), key, call))
+	pr.URL.Path = path
+	pr.URL.RawPath = ""
+	pr.URL.RawQuery = query
+	pr.Header.Set("Content-Type", "application/json")
+	pr.Header.Del("Content-Length")
+	pr.Body = io.NopCloser(bytes.NewReader(body))
+	pr.ContentLength = int64(len(body))
+	return pr
+}
+
+// adapterError is a problem with the client's request found while translating it
+type adapterError struct {
+	code    apierror.Code
+	message string
+}
+
+func (e *adapterError) Error() string {
+	return e.message
+}
+
+// writeAdapterError reports a translation failure in the OpenAI error format
+func writeAdapterError(w http.ResponseWriter, r *http.Request, err error) {
+	code := apierror.CodeInvalidRequest
+	if ae, ok := err.(*adapterError); ok {
+		code = ae.code
+	}
+	apierror.Write(w, r, code, err.Error())
+}
+
+// bearerToken returns the credential from an Authorization: Bearer header
+func bearerToken(h http.Header) string {
+	auth := h.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// newResponseID returns an OpenAI-style identifier such as chatcmpl-1a2b...
+func newResponseID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
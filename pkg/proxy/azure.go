@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ngoyal88/relay/pkg/apierror"
+)
+
+// defaultAzureAPIVersion is used when a target doesn't set api_version
+const defaultAzureAPIVersion = "2024-10-21"
+
+// azureAdapter routes OpenAI-style /v1/... requests to Azure OpenAI
+// deployments: /openai/deployments/{deployment}/...?api-version=...
+type azureAdapter struct {
+	deployments map[string]string // Model -> deployment; empty uses the model name
+	apiVersion  string
+	apiKey      string // Sent as api-key; defaults to the client's bearer token
+}
+
+// configuredModels is implemented by adapters whose models are fixed by configuration
+type configuredModels interface {
+	models() []string
+}
+
+func newAzureAdapter(cfg TargetConfig) *azureAdapter {
+	version := cfg.APIVersion
+	if version == "" {
+		version = defaultAzureAPIVersion
+	}
+	return &azureAdapter{deployments: cfg.Deployments, apiVersion: version, apiKey: cfg.APIKey}
+}
+
+// models implements configuredModels; without a deployment map any model may be deployed
+func (a *azureAdapter) models() []string {
+	if len(a.deployments) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(a.deployments))
+	for m := range a.deployments {
+		out = append(out, m)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// PrepareRequest implements Adapter
+func (a *azureAdapter) PrepareRequest(r *http.Request) (*http.Request, error) {
+	endpoint, ok := strings.CutPrefix(r.URL.Path, "/v1/")
+	if !ok || endpoint == "" {
+		return nil, &adapterError{apierror.CodeUnsupportedEndpoint, r.URL.Path + " is not supported by the azure provider"}
+	}
+
+	model := requestModel(r)
+	if model == "" {
+		return nil, &adapterError{apierror.CodeInvalidRequest, "model is required to pick an Azure deployment"}
+	}
+	deployment := model
+	if len(a.deployments) > 0 {
+		if deployment, ok = a.deployments[model]; !ok {
+			return nil, &adapterError{apierror.CodeModelNotFound, "no Azure deployment is configured for model " + model}
+		}
+	}
+
+	pr := r.Clone(r.Context())
+	pr.URL.Path = "/openai/deployments/" + deployment + "/" + endpoint
+	pr.URL.RawPath = ""
+	q := pr.URL.Query()
+	q.Set("api-version", a.apiVersion)
+	pr.URL.RawQuery = q.Encode()
+
+	key := a.apiKey
+	if key == "" {
+		key = bearerToken(r.Header)
+	}
+	pr.Header.Del("Authorization")
+	pr.Header.Set("api-key", key)
+	return pr, nil
+}
+
+// ModifyResponse implements Adapter; Azure already answers in the OpenAI format
+func (a *azureAdapter) ModifyResponse(resp *http.Response) error {
+	return nil
+}
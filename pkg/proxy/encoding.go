@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Middleware inspects upstream responses (usage, transforms, cache, logs), so
+// they must arrive as plain bytes. Upstreams are only offered gzip, which is
+// decoded here; compression towards the client is negotiated separately by
+// middleware.ResponseCompression.
+
+// setUpstreamEncoding replaces the client's Accept-Encoding (which may list
+// br or zstd) with the one encoding decodeResponse understands
+func setUpstreamEncoding(req *http.Request) {
+	req.Header.Set("Accept-Encoding", "gzip")
+}
+
+// decodeResponse is a ReverseProxy ModifyResponse hook that unwraps gzip bodies.
+// Reads are streamed, so server-sent events still arrive as they are produced.
+func decodeResponse(resp *http.Response) error {
+	if !strings.EqualFold(strings.TrimSpace(resp.Header.Get("Content-Encoding")), "gzip") {
+		return nil
+	}
+	if resp.Body == nil || resp.Body == http.NoBody {
+		resp.Header.Del("Content-Encoding")
+		return nil
+	}
+
+	resp.Body = &gzipBody{src: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// gzipBody opens the gzip stream on first read, so a slow upstream doesn't
+// block the status line and headers
+type gzipBody struct {
+	src io.ReadCloser
+	zr  *gzip.Reader
+	err error
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.zr == nil && b.err == nil {
+		b.zr, b.err = gzip.NewReader(b.src)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.zr.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	return b.src.Close()
+}
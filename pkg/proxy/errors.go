@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ngoyal88/relay/pkg/apierror"
+)
+
+// maxErrorBody bounds how much of an upstream error body is read
+const maxErrorBody = 1 << 20
+
+// maxErrorMessage bounds a message taken from a plain-text error body
+const maxErrorMessage = 512
+
+// writeUpstreamError is the ErrorHandler of every reverse proxy. Log upstream
+// errors so network/DNS/TLS issues are visible.
+func writeUpstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("[PROXY] upstream error: %v", err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		apierror.Write(w, r, apierror.CodeUpstreamTimeout, "The upstream did not respond in time")
+		return
+	}
+	apierror.Write(w, r, apierror.CodeUpstreamError, "upstream error")
+}
+
+// normalizeError rewrites an upstream error response into the OpenAI
+// envelope, keeping the upstream's message, type and code where it has them,
+// and tags it with the request ID
+func normalizeError(resp *http.Response) error {
+	if resp.StatusCode < 400 || strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	e := upstreamError(resp.StatusCode, body)
+	if resp.Request != nil {
+		e.RequestID = apierror.RequestID(resp.Request.Context())
+	}
+	setBody(resp, apierror.Marshal(e), "application/json")
+	return nil
+}
+
+// upstreamError reads the error shapes providers use: OpenAI's and Anthropic's
+// {"error":{...}}, {"error":"..."}, vLLM's flat {"message","type","code"},
+// FastAPI's {"detail":"..."}, or plain text
+func upstreamError(status int, body []byte) *apierror.Error {
+	e := &apierror.Error{}
+
+	var raw map[string]interface{}
+	if json.Unmarshal(body, &raw) == nil {
+		fields := raw
+		switch v := raw["error"].(type) {
+		case map[string]interface{}:
+			fields = v
+		case string:
+			e.Message = v
+		}
+		if e.Message == "" {
+			e.Message, _ = fields["message"].(string)
+		}
+		if e.Message == "" {
+			e.Message, _ = raw["detail"].(string)
+		}
+		if fields["object"] != "error" {
+			// vLLM's top-level type is the object's, not the error's
+			e.Type, _ = fields["type"].(string)
+		}
+		e.Code = fields["code"]
+		e.Param = fields["param"]
+	} else if text := strings.TrimSpace(string(body)); !strings.HasPrefix(text, "<") {
+		// HTML pages from load balancers and gateways are left out
+		if len(text) > maxErrorMessage {
+			text = text[:maxErrorMessage] + "..."
+		}
+		e.Message = text
+	}
+
+	if e.Message == "" {
+		e.Message = http.StatusText(status)
+	}
+	if e.Type == "" {
+		e.Type = apierror.TypeForStatus(status)
+	}
+	if e.Code == nil {
+		e.Code = string(apierror.CodeUpstreamError)
+	}
+	return e
+}
@@ -0,0 +1,799 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/apierror"
+)
+
+// geminiAdapter maps OpenAI chat completions onto Gemini's generateContent
+// and streamGenerateContent endpoints
+type geminiAdapter struct {
+	basePath string // API version prefix, "/v1beta" unless the target URL has one
+	apiKey   string // Falls back to the client's bearer token when empty
+}
+
+func newGeminiAdapter(target *url.URL, apiKey string) *geminiAdapter {
+	base := strings.TrimSuffix(target.Path, "/")
+	if base == "" {
+		base = "/v1beta"
+	}
+	return &geminiAdapter{basePath: base, apiKey: apiKey}
+}
+
+// listModels implements modelLister using GET {basePath}/models; without a
+// configured key there is nothing to list with
+func (a *geminiAdapter) listModels(ctx context.Context, base *url.URL) ([]string, error) {
+	if a.apiKey == "" {
+		return nil, nil
+	}
+	u := url.URL{Scheme: base.Scheme, Host: base.Host, Path: a.basePath + "/models", RawQuery: "pageSize=1000"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-goog-api-key", a.apiKey)
+
+	var list struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := fetchJSON(req, &list); err != nil {
+		return nil, err
+	}
+	models := make([]string, 0, len(list.Models))
+	for _, m := range list.Models {
+		models = append(models, strings.TrimPrefix(m.Name, "models/"))
+	}
+	return models, nil
+}
+
+// geminiCall is what the response conversion needs to know about the request
+type geminiCall struct {
+	model        string
+	stream       bool
+	includeUsage bool
+}
+
+const geminiCallContextKey contextKey = "gemini_call"
+
+// Gemini generateContent request and response
+
+type geminiRequest struct {
+	Contents          []geminiContent         `json:"contents"`
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	ToolConfig        *geminiToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	InlineData       *geminiBlob             `json:"inlineData,omitempty"`
+	FileData         *geminiFileData         `json:"fileData,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFileData struct {
+	MimeType string `json:"mimeType"`
+	FileURI  string `json:"fileUri"`
+}
+
+type geminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	ID       string          `json:"id,omitempty"`
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type geminiToolConfig struct {
+	FunctionCallingConfig struct {
+		Mode                 string   `json:"mode"`
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	} `json:"functionCallingConfig"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature      *float64        `json:"temperature,omitempty"`
+	TopP             *float64        `json:"topP,omitempty"`
+	MaxOutputTokens  *int            `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string        `json:"stopSequences,omitempty"`
+	CandidateCount   *int            `json:"candidateCount,omitempty"`
+	Seed             *int64          `json:"seed,omitempty"`
+	PresencePenalty  *float64        `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float64        `json:"frequencyPenalty,omitempty"`
+	ResponseMimeType string          `json:"responseMimeType,omitempty"`
+	ResponseSchema   json.RawMessage `json:"responseSchema,omitempty"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+		Index        int           `json:"index"`
+	} `json:"candidates"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	ModelVersion string `json:"modelVersion"`
+	ResponseID   string `json:"responseId"`
+}
+
+// PrepareRequest implements Adapter
+func (a *geminiAdapter) PrepareRequest(r *http.Request) (*http.Request, error) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+		return nil, &adapterError{apierror.CodeUnsupportedEndpoint, fmt.Sprintf("%s %s is not supported by the gemini provider", r.Method, r.URL.Path)}
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, &adapterError{apierror.CodeInvalidRequest, "failed to read request body"}
+	}
+	var req openAIChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, &adapterError{apierror.CodeInvalidRequest, "invalid JSON body: " + err.Error()}
+	}
+	model := strings.TrimPrefix(req.Model, "models/")
+	if model == "" {
+		return nil, &adapterError{apierror.CodeInvalidRequest, "model is required"}
+	}
+
+	greq, err := toGeminiRequest(&req)
+	if err != nil {
+		return nil, &adapterError{apierror.CodeInvalidRequest, err.Error()}
+	}
+	out, err := json.Marshal(greq)
+	if err != nil {
+		return nil, err
+	}
+
+	call := &geminiCall{model: model, stream: req.Stream}
+	if req.StreamOptions != nil {
+		call.includeUsage = req.StreamOptions.IncludeUsage
+	}
+
+	method, query := ":generateContent", ""
+	if req.Stream {
+		method, query = ":streamGenerateContent", "alt=sse"
+	}
+	pr := rewriteRequest(r, geminiCallContextKey, call, a.basePath+"/models/"+model+method, query, out)
+
+	key := a.apiKey
+	if key == "" {
+		key = bearerToken(r.Header)
+	}
+	pr.Header.Del("Authorization")
+	pr.Header.Set("x-goog-api-key", key)
+	return pr, nil
+}
+
+func toGeminiRequest(req *openAIChatRequest) (*geminiRequest, error) {
+	out := &geminiRequest{}
+
+	// Tool results only carry the call ID; Gemini wants the function name
+	callNames := make(map[string]string)
+	for _, m := range req.Messages {
+		for _, tc := range m.ToolCalls {
+			callNames[tc.ID] = tc.Function.Name
+		}
+	}
+
+	var system []geminiPart
+	for i, m := range req.Messages {
+		switch m.Role {
+		case "system", "developer":
+			parts, err := geminiParts(m.Content)
+			if err != nil {
+				return nil, fmt.Errorf("messages[%d]: %w", i, err)
+			}
+			system = append(system, parts...)
+
+		case "user":
+			parts, err := geminiParts(m.Content)
+			if err != nil {
+				return nil, fmt.Errorf("messages[%d]: %w", i, err)
+			}
+			out.Contents = appendContent(out.Contents, "user", parts)
+
+		case "assistant":
+			parts, err := geminiParts(m.Content)
+			if err != nil {
+				return nil, fmt.Errorf("messages[%d]: %w", i, err)
+			}
+			for _, tc := range m.ToolCalls {
+				args := json.RawMessage(tc.Function.Arguments)
+				if len(bytes.TrimSpace(args)) == 0 {
+					args = json.RawMessage("{}")
+				} else if !json.Valid(args) {
+					return nil, fmt.Errorf("messages[%d]: tool call %s has invalid JSON arguments", i, tc.ID)
+				}
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: tc.Function.Name, Args: args}})
+			}
+			out.Contents = appendContent(out.Contents, "model", parts)
+
+		case "tool", "function":
+			name := callNames[m.ToolCallID]
+			if name == "" {
+				name = m.Name
+			}
+			if name == "" {
+				return nil, fmt.Errorf("messages[%d]: tool result %q does not match any tool call", i, m.ToolCallID)
+			}
+			text, err := contentText(m.Content)
+			if err != nil {
+				return nil, fmt.Errorf("messages[%d]: %w", i, err)
+			}
+			part := geminiPart{FunctionResponse: &geminiFunctionResponse{Name: name, Response: toolResponse(text)}}
+			out.Contents = appendContent(out.Contents, "user", []geminiPart{part})
+
+		default:
+			return nil, fmt.Errorf("messages[%d]: unsupported role %q", i, m.Role)
+		}
+	}
+	if len(system) > 0 {
+		out.SystemInstruction = &geminiContent{Parts: system}
+	}
+	if len(out.Contents) == 0 {
+		return nil, fmt.Errorf("messages must include at least one user message")
+	}
+
+	if len(req.Tools) > 0 {
+		var decls []geminiFunctionDeclaration
+		for _, t := range req.Tools {
+			if t.Type != "" && t.Type != "function" {
+				return nil, fmt.Errorf("unsupported tool type %q", t.Type)
+			}
+			decls = append(decls, geminiFunctionDeclaration{
+				Name:        t.Function.Name,
+				Description: t.Function.Description,
+				Parameters:  geminiSchema(t.Function.Parameters),
+			})
+		}
+		out.Tools = []geminiTool{{FunctionDeclarations: decls}}
+	}
+	if len(req.ToolChoice) > 0 {
+		tc, err := geminiToolChoice(req.ToolChoice)
+		if err != nil {
+			return nil, err
+		}
+		out.ToolConfig = tc
+	}
+
+	gc := &geminiGenerationConfig{
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		MaxOutputTokens:  req.maxTokens(),
+		CandidateCount:   req.N,
+		Seed:             req.Seed,
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+	}
+	stop, err := req.stopSequences()
+	if err != nil {
+		return nil, err
+	}
+	gc.StopSequences = stop
+	if rf := req.ResponseFormat; rf != nil {
+		switch rf.Type {
+		case "json_object":
+			gc.ResponseMimeType = "application/json"
+		case "json_schema":
+			gc.ResponseMimeType = "application/json"
+			if rf.JSONSchema != nil {
+				gc.ResponseSchema = geminiSchema(rf.JSONSchema.Schema)
+			}
+		}
+	}
+	out.GenerationConfig = gc
+	return out, nil
+}
+
+// appendContent merges consecutive turns of the same role, which Gemini requires
+func appendContent(contents []geminiContent, role string, parts []geminiPart) []geminiContent {
+	if len(parts) == 0 {
+		return contents
+	}
+	if n := len(contents); n > 0 && contents[n-1].Role == role {
+		contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+		return contents
+	}
+	return append(contents, geminiContent{Role: role, Parts: parts})
+}
+
+// geminiParts converts message content: a string, or text and image_url parts
+func geminiParts(content json.RawMessage) ([]geminiPart, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		if text == "" {
+			return nil, nil
+		}
+		return []geminiPart{{Text: text}}, nil
+	}
+
+	var parts []openAIContentPart
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return nil, fmt.Errorf("content must be a string or an array of parts")
+	}
+	out := make([]geminiPart, 0, len(parts))
+	for _, p := range parts {
+		switch p.Type {
+		case "text":
+			if p.Text != "" {
+				out = append(out, geminiPart{Text: p.Text})
+			}
+		case "image_url":
+			if p.ImageURL == nil || p.ImageURL.URL == "" {
+				return nil, fmt.Errorf("image_url part without a url")
+			}
+			part, err := geminiImage(p.ImageURL.URL)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, part)
+		default:
+			return nil, fmt.Errorf("unsupported content part type %q", p.Type)
+		}
+	}
+	return out, nil
+}
+
+// geminiImage turns a data: URL into inline data and anything else into a file reference
+func geminiImage(rawURL string) (geminiPart, error) {
+	if rest, ok := strings.CutPrefix(rawURL, "data:"); ok {
+		meta, data, found := strings.Cut(rest, ",")
+		mimeType, isBase64 := strings.CutSuffix(meta, ";base64")
+		if !found || !isBase64 {
+			return geminiPart{}, fmt.Errorf("image data URLs must be base64 encoded")
+		}
+		return geminiPart{InlineData: &geminiBlob{MimeType: mimeType, Data: data}}, nil
+	}
+
+	mimeType := "image/jpeg"
+	if u, err := url.Parse(rawURL); err == nil {
+		if t := mime.TypeByExtension(path.Ext(u.Path)); t != "" {
+			mimeType = t
+		}
+	}
+	return geminiPart{FileData: &geminiFileData{MimeType: mimeType, FileURI: rawURL}}, nil
+}
+
+// contentText flattens content to text, for tool results
+func contentText(content json.RawMessage) (string, error) {
+	parts, err := geminiParts(content)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, p := range parts {
+		sb.WriteString(p.Text)
+	}
+	return sb.String(), nil
+}
+
+// toolResponse wraps a tool result as the JSON object Gemini expects
+func toolResponse(text string) json.RawMessage {
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+	var value interface{} = text
+	if json.Valid([]byte(trimmed)) && trimmed != "" {
+		value = json.RawMessage(trimmed)
+	}
+	out, _ := json.Marshal(map[string]interface{}{"content": value})
+	return out
+}
+
+// geminiSchema drops JSON Schema keywords Gemini's OpenAPI subset rejects
+func geminiSchema(schema json.RawMessage) json.RawMessage {
+	if len(schema) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(schema, &v); err != nil {
+		return schema
+	}
+	out, _ := json.Marshal(stripSchemaKeywords(v))
+	return out
+}
+
+func stripSchemaKeywords(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, k := range []string{"$schema", "additionalProperties", "strict"} {
+			delete(t, k)
+		}
+		for k, child := range t {
+			if k == "properties" {
+				// Property names are not keywords
+				if props, ok := child.(map[string]interface{}); ok {
+					for name, p := range props {
+						props[name] = stripSchemaKeywords(p)
+					}
+					continue
+				}
+			}
+			t[k] = stripSchemaKeywords(child)
+		}
+		return t
+	case []interface{}:
+		for i := range t {
+			t[i] = stripSchemaKeywords(t[i])
+		}
+		return t
+	}
+	return v
+}
+
+func geminiToolChoice(raw json.RawMessage) (*geminiToolConfig, error) {
+	tc := &geminiToolConfig{}
+	var mode string
+	if err := json.Unmarshal(raw, &mode); err == nil {
+		switch mode {
+		case "none":
+			tc.FunctionCallingConfig.Mode = "NONE"
+		case "auto":
+			tc.FunctionCallingConfig.Mode = "AUTO"
+		case "required":
+			tc.FunctionCallingConfig.Mode = "ANY"
+		default:
+			return nil, fmt.Errorf("unsupported tool_choice %q", mode)
+		}
+		return tc, nil
+	}
+
+	var named struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(raw, &named); err != nil || named.Function.Name == "" {
+		return nil, fmt.Errorf("tool_choice must be none, auto, required or a function")
+	}
+	tc.FunctionCallingConfig.Mode = "ANY"
+	tc.FunctionCallingConfig.AllowedFunctionNames = []string{named.Function.Name}
+	return tc, nil
+}
+
+// ModifyResponse implements Adapter
+func (a *geminiAdapter) ModifyResponse(resp *http.Response) error {
+	call, ok := resp.Request.Context().Value(geminiCallContextKey).(*geminiCall)
+	if !ok {
+		return nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		setBody(resp, geminiError(resp.StatusCode, body), "application/json")
+		return nil
+	}
+
+	if call.stream {
+		pr, pw := io.Pipe()
+		go convertGeminiStream(resp.Body, pw, call)
+		resp.Body = &pipeBody{PipeReader: pr, src: resp.Body}
+		resp.Header.Set("Content-Type", "text/event-stream")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	var gresp geminiResponse
+	if err := json.Unmarshal(body, &gresp); err != nil {
+		return fmt.Errorf("invalid gemini response: %w", err)
+	}
+	out, err := json.Marshal(toOpenAICompletion(&gresp, call))
+	if err != nil {
+		return err
+	}
+	setBody(resp, out, "application/json")
+	return nil
+}
+
+// geminiError converts {"error": {"code", "message", "status"}} to the OpenAI error shape
+func geminiError(status int, body []byte) []byte {
+	var gerr struct {
+		Error struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+		} `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	var code interface{}
+	if json.Unmarshal(body, &gerr) == nil && gerr.Error.Message != "" {
+		message = gerr.Error.Message
+		if gerr.Error.Status != "" {
+			code = gerr.Error.Status
+		}
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+
+	return apierror.Marshal(&apierror.Error{Message: message, Type: apierror.TypeForStatus(status), Code: code})
+}
+
+// geminiFinishReason maps Gemini's finish reasons onto OpenAI's
+func geminiFinishReason(reason string, toolCalls bool) string {
+	switch reason {
+	case "":
+		return ""
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return "content_filter"
+	}
+	if toolCalls {
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+func geminiUsage(g *geminiResponse) *openAIUsage {
+	if g.UsageMetadata == nil {
+		return nil
+	}
+	u := g.UsageMetadata
+	completion := u.CandidatesTokenCount + u.ThoughtsTokenCount
+	total := u.TotalTokenCount
+	if total == 0 {
+		total = u.PromptTokenCount + completion
+	}
+	return &openAIUsage{PromptTokens: u.PromptTokenCount, CompletionTokens: completion, TotalTokens: total}
+}
+
+// splitParts separates a candidate's visible text from its function calls;
+// thought summaries are not part of the answer
+func splitParts(parts []geminiPart, nextIndex int) (string, []openAIToolCall) {
+	var text strings.Builder
+	var calls []openAIToolCall
+	for _, p := range parts {
+		switch {
+		case p.FunctionCall != nil:
+			// OpenAI sends arguments as compact JSON
+			args := "{}"
+			var compact bytes.Buffer
+			if json.Compact(&compact, p.FunctionCall.Args) == nil && compact.Len() > 0 {
+				args = compact.String()
+			}
+			idx := nextIndex + len(calls)
+			tc := openAIToolCall{Index: &idx, ID: p.FunctionCall.ID, Type: "function"}
+			if tc.ID == "" {
+				tc.ID = newResponseID("call_")
+			}
+			tc.Function.Name = p.FunctionCall.Name
+			tc.Function.Arguments = args
+			calls = append(calls, tc)
+		case !p.Thought:
+			text.WriteString(p.Text)
+		}
+	}
+	return text.String(), calls
+}
+
+func toOpenAICompletion(g *geminiResponse, call *geminiCall) map[string]interface{} {
+	choices := make([]map[string]interface{}, 0, len(g.Candidates))
+	for _, c := range g.Candidates {
+		text, calls := splitParts(c.Content.Parts, 0)
+		for i := range calls {
+			calls[i].Index = nil
+		}
+		msg := openAIChoiceMessage{Role: "assistant", ToolCalls: calls}
+		if text != "" || len(calls) == 0 {
+			msg.Content = &text
+		}
+		choices = append(choices, map[string]interface{}{
+			"index":         c.Index,
+			"message":       msg,
+			"finish_reason": geminiFinishReason(c.FinishReason, len(calls) > 0),
+		})
+	}
+	if len(choices) == 0 && g.PromptFeedback != nil && g.PromptFeedback.BlockReason != "" {
+		// The prompt itself was blocked
+		empty := ""
+		choices = append(choices, map[string]interface{}{
+			"index":         0,
+			"message":       openAIChoiceMessage{Role: "assistant", Content: &empty},
+			"finish_reason": "content_filter",
+		})
+	}
+
+	out := map[string]interface{}{
+		"id":      completionID(g),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   responseModel(g, call),
+		"choices": choices,
+	}
+	if usage := geminiUsage(g); usage != nil {
+		out["usage"] = usage
+	}
+	return out
+}
+
+func completionID(g *geminiResponse) string {
+	if g.ResponseID != "" {
+		return "chatcmpl-" + g.ResponseID
+	}
+	return newResponseID("chatcmpl-")
+}
+
+func responseModel(g *geminiResponse, call *geminiCall) string {
+	if g.ModelVersion != "" {
+		return g.ModelVersion
+	}
+	return call.model
+}
+
+// convertGeminiStream rewrites Gemini's SSE events (each a partial
+// GenerateContentResponse) as chat.completion.chunk events
+func convertGeminiStream(src io.Reader, dst *io.PipeWriter, call *geminiCall) {
+	id := newResponseID("chatcmpl-")
+	created := time.Now().Unix()
+	model := call.model
+
+	type choiceState struct {
+		started   bool
+		toolCalls int
+	}
+	states := make(map[int]*choiceState)
+	var usage *openAIUsage
+
+	emit := func(v interface{}) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(dst, "data: %s\n\n", data)
+		return err
+	}
+	chunk := func(choices []map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": choices,
+		}
+	}
+
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var g geminiResponse
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &g); err != nil {
+			continue
+		}
+		if g.ModelVersion != "" {
+			model = g.ModelVersion
+		}
+		if u := geminiUsage(&g); u != nil {
+			usage = u
+		}
+
+		var choices []map[string]interface{}
+		for _, c := range g.Candidates {
+			st := states[c.Index]
+			if st == nil {
+				st = &choiceState{}
+				states[c.Index] = st
+			}
+			text, calls := splitParts(c.Content.Parts, st.toolCalls)
+			st.toolCalls += len(calls)
+
+			delta := map[string]interface{}{}
+			if !st.started {
+				delta["role"] = "assistant"
+				st.started = true
+			}
+			if text != "" {
+				delta["content"] = text
+			}
+			if len(calls) > 0 {
+				delta["tool_calls"] = calls
+			}
+			var finish interface{}
+			if reason := geminiFinishReason(c.FinishReason, st.toolCalls > 0); reason != "" {
+				finish = reason
+			}
+			if len(delta) == 0 && finish == nil {
+				continue
+			}
+			choices = append(choices, map[string]interface{}{
+				"index":         c.Index,
+				"delta":         delta,
+				"finish_reason": finish,
+			})
+		}
+		if len(choices) == 0 && len(g.Candidates) == 0 && g.PromptFeedback != nil && g.PromptFeedback.BlockReason != "" {
+			choices = append(choices, map[string]interface{}{
+				"index":         0,
+				"delta":         map[string]interface{}{"role": "assistant", "content": ""},
+				"finish_reason": "content_filter",
+			})
+		}
+		if len(choices) == 0 {
+			continue
+		}
+		if err := emit(chunk(choices)); err != nil {
+			dst.CloseWithError(err)
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		dst.CloseWithError(err)
+		return
+	}
+
+	if call.includeUsage && usage != nil {
+		final := chunk([]map[string]interface{}{})
+		final["usage"] = usage
+		if err := emit(final); err != nil {
+			dst.CloseWithError(err)
+			return
+		}
+	}
+	io.WriteString(dst, "data: [DONE]\n\n")
+	dst.Close()
+}
@@ -0,0 +1,416 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testGeminiKey = "test-gemini-key"
+
+// fakeGemini replays recorded Gemini responses from testdata/gemini and
+// keeps the last generate request it was sent
+type fakeGemini struct {
+	t       *testing.T
+	fixture string // Served for generateContent and streamGenerateContent
+	status  int
+
+	mu   sync.Mutex
+	last *http.Request
+	body []byte
+}
+
+func newFakeGemini(t *testing.T, fixture string) (*fakeGemini, *LoadBalancer) {
+	t.Helper()
+	fg := &fakeGemini{t: t, fixture: fixture, status: http.StatusOK}
+	srv := httptest.NewServer(fg)
+	t.Cleanup(srv.Close)
+
+	lb, err := NewLoadBalancer([]TargetConfig{{URL: srv.URL, Provider: "gemini", APIKey: testGeminiKey}}, "round-robin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(lb.Close)
+	return fg, lb
+}
+
+func (fg *fakeGemini) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1beta/models" {
+		w.Write([]byte(`{"models":[{"name":"models/gemini-2.5-flash"},{"name":"models/gemini-2.0-flash"}]}`))
+		return
+	}
+	if !strings.Contains(r.URL.Path, ":") {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	fg.mu.Lock()
+	fg.last, fg.body = r, body
+	fg.mu.Unlock()
+
+	data := readFixture(fg.t, fg.fixture)
+	if strings.HasSuffix(fg.fixture, ".sse") {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(fg.status)
+		// Small writes split events and lines across reads
+		for len(data) > 0 {
+			n := min(7, len(data))
+			w.Write(data[:n])
+			w.(http.Flusher).Flush()
+			data = data[n:]
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(fg.status)
+	w.Write(data)
+}
+
+func (fg *fakeGemini) request() (*http.Request, []byte) {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	return fg.last, fg.body
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "gemini", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func sendChat(lb *LoadBalancer, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer client-key")
+	rec := httptest.NewRecorder()
+	lb.ServeHTTP(rec, req)
+	return rec
+}
+
+// assertJSONEqual compares two JSON documents ignoring formatting and key order
+func assertJSONEqual(t *testing.T, got, want []byte) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		t.Fatalf("invalid JSON %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		gotIndented, _ := json.MarshalIndent(g, "", "  ")
+		t.Errorf("got\n%s\nwant\n%s", gotIndented, want)
+	}
+}
+
+func TestGeminiTranslatesRequest(t *testing.T) {
+	fg, lb := newFakeGemini(t, "generate_text.json")
+
+	rec := sendChat(lb, string(readFixture(t, "chat_request.json")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	r, body := fg.request()
+	if r.URL.Path != "/v1beta/models/gemini-2.5-flash:generateContent" || r.URL.RawQuery != "" {
+		t.Errorf("sent to %s?%s", r.URL.Path, r.URL.RawQuery)
+	}
+	if got := r.Header.Get("x-goog-api-key"); got != testGeminiKey {
+		t.Errorf("x-goog-api-key = %q", got)
+	}
+	if got := r.Header.Get("Authorization"); got != "" {
+		t.Errorf("client credential forwarded: %q", got)
+	}
+	assertJSONEqual(t, body, readFixture(t, "generate_request.json"))
+}
+
+type testCompletion struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index   int `json:"index"`
+		Message struct {
+			Role      string           `json:"role"`
+			Content   *string          `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"message"`
+		Delta struct {
+			Role      string           `json:"role"`
+			Content   *string          `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+func TestGeminiConvertsCompletions(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		id        string
+		model     string
+		content   string
+		toolCalls []string // Arguments of each call, in order
+		finish    string
+		usage     openAIUsage
+	}{
+		{
+			// Thought summaries are dropped; thinking tokens count as completion tokens
+			fixture: "generate_text.json",
+			id:      "chatcmpl-x4UXaKWrFf-dz7IPo5qwgQ4",
+			model:   "gemini-2.5-flash",
+			content: "Tomorrow in Paris expect light rain with a high of 16°C.",
+			finish:  "stop",
+			usage:   openAIUsage{PromptTokens: 112, CompletionTokens: 75, TotalTokens: 187},
+		},
+		{
+			fixture:   "generate_tool_call.json",
+			id:        "chatcmpl-PIYXaJ7dJIHjz7IPyKOY8AY",
+			model:     "gemini-2.5-flash",
+			toolCalls: []string{`{"city":"Paris"}`, `{"city":"Lyon"}`},
+			finish:    "tool_calls",
+			usage:     openAIUsage{PromptTokens: 58, CompletionTokens: 24, TotalTokens: 82},
+		},
+		{
+			fixture: "generate_max_tokens.json",
+			id:      "chatcmpl-lYcXaPa3BpTkz7IP4bnSoQ8",
+			model:   "gemini-2.0-flash",
+			content: "Paris has a temperate oceanic climate, with",
+			finish:  "length",
+			usage:   openAIUsage{PromptTokens: 9, CompletionTokens: 10, TotalTokens: 19},
+		},
+		{
+			fixture: "generate_safety.json",
+			id:      "chatcmpl-9ocXaK_4F8Ldz7IP9Nb1-Ac",
+			model:   "gemini-2.0-flash",
+			finish:  "content_filter",
+			usage:   openAIUsage{PromptTokens: 21, TotalTokens: 21},
+		},
+		{
+			// The prompt itself was blocked, so there are no candidates
+			fixture: "generate_blocked.json",
+			id:      "chatcmpl-K4gXaOCxKJTkz7IP4bnSoQ8",
+			model:   "gemini-2.0-flash",
+			finish:  "content_filter",
+			usage:   openAIUsage{PromptTokens: 17, TotalTokens: 17},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(strings.TrimSuffix(tt.fixture, ".json"), func(t *testing.T) {
+			_, lb := newFakeGemini(t, tt.fixture)
+			rec := sendChat(lb, `{"model":"gemini-2.5-flash","messages":[{"role":"user","content":"Weather in Paris tomorrow?"}]}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+
+			var got testCompletion
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid completion %s: %v", rec.Body, err)
+			}
+			if got.ID != tt.id || got.Object != "chat.completion" || got.Model != tt.model {
+				t.Errorf("id %q, object %q, model %q", got.ID, got.Object, got.Model)
+			}
+			if got.Usage == nil || *got.Usage != tt.usage {
+				t.Errorf("usage = %+v, want %+v", got.Usage, tt.usage)
+			}
+			if len(got.Choices) != 1 {
+				t.Fatalf("%d choices: %s", len(got.Choices), rec.Body)
+			}
+			choice := got.Choices[0]
+			if choice.FinishReason == nil || *choice.FinishReason != tt.finish {
+				t.Errorf("finish_reason = %v, want %s", choice.FinishReason, tt.finish)
+			}
+
+			msg := choice.Message
+			if msg.Role != "assistant" {
+				t.Errorf("role = %q", msg.Role)
+			}
+			if len(tt.toolCalls) > 0 {
+				if msg.Content != nil {
+					t.Errorf("content = %q alongside tool calls", *msg.Content)
+				}
+			} else if msg.Content == nil || *msg.Content != tt.content {
+				t.Errorf("content = %v, want %q", msg.Content, tt.content)
+			}
+			assertToolCalls(t, msg.ToolCalls, tt.toolCalls, false)
+		})
+	}
+}
+
+// assertToolCalls checks calls are get_weather with the given arguments and
+// unique IDs, indexed only when streamed
+func assertToolCalls(t *testing.T, calls []openAIToolCall, args []string, indexed bool) {
+	t.Helper()
+	if len(calls) != len(args) {
+		t.Fatalf("%d tool calls, want %d", len(calls), len(args))
+	}
+	ids := map[string]bool{}
+	for i, tc := range calls {
+		if tc.Type != "function" || tc.Function.Name != "get_weather" || tc.Function.Arguments != args[i] {
+			t.Errorf("tool call %d = %+v", i, tc)
+		}
+		if !strings.HasPrefix(tc.ID, "call_") || ids[tc.ID] {
+			t.Errorf("tool call %d has ID %q", i, tc.ID)
+		}
+		ids[tc.ID] = true
+		if indexed && (tc.Index == nil || *tc.Index != i) {
+			t.Errorf("tool call %d has index %v", i, tc.Index)
+		}
+		if !indexed && tc.Index != nil {
+			t.Errorf("tool call %d has index %d outside a stream", i, *tc.Index)
+		}
+	}
+}
+
+func TestGeminiConvertsErrors(t *testing.T) {
+	fg, lb := newFakeGemini(t, "error_invalid_key.json")
+	fg.status = http.StatusBadRequest
+
+	rec := sendChat(lb, `{"model":"gemini-2.5-flash","messages":[{"role":"user","content":"Hi"}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	if got.Error.Message != "API key not valid. Please pass a valid API key." || got.Error.Code != "INVALID_ARGUMENT" || got.Error.Type != "invalid_request_error" {
+		t.Errorf("error = %+v", got.Error)
+	}
+}
+
+// readChunks parses an OpenAI SSE stream, checking it ends with [DONE]
+func readChunks(t *testing.T, body []byte) []testCompletion {
+	t.Helper()
+	var chunks []testCompletion
+	done := false
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || done {
+			t.Fatalf("unexpected line %q", line)
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk testCompletion
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %s: %v", data, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if !done {
+		t.Errorf("stream did not end with [DONE]:\n%s", body)
+	}
+	return chunks
+}
+
+func TestGeminiConvertsStreams(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		content   string
+		toolCalls []string
+		finish    string
+		usage     *openAIUsage
+	}{
+		{
+			fixture: "stream_text.sse",
+			content: "Tomorrow in Paris expect light rain with a high of 16°C.",
+			finish:  "stop",
+			usage:   &openAIUsage{PromptTokens: 112, CompletionTokens: 75, TotalTokens: 187},
+		},
+		{
+			fixture:   "stream_tool_call.sse",
+			toolCalls: []string{`{"city":"Paris"}`, `{"city":"Lyon"}`},
+			finish:    "tool_calls",
+			usage:     &openAIUsage{PromptTokens: 58, CompletionTokens: 24, TotalTokens: 82},
+		},
+		{
+			fixture: "stream_blocked.sse",
+			finish:  "content_filter",
+			usage:   &openAIUsage{PromptTokens: 17, TotalTokens: 17},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(strings.TrimSuffix(tt.fixture, ".sse"), func(t *testing.T) {
+			fg, lb := newFakeGemini(t, tt.fixture)
+			rec := sendChat(lb, `{"model":"gemini-2.5-flash","stream":true,"stream_options":{"include_usage":true},`+
+				`"messages":[{"role":"user","content":"Weather in Paris tomorrow?"}]}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+				t.Errorf("Content-Type = %q", got)
+			}
+			if r, _ := fg.request(); r.URL.Path != "/v1beta/models/gemini-2.5-flash:streamGenerateContent" || r.URL.RawQuery != "alt=sse" {
+				t.Errorf("sent to %s?%s", r.URL.Path, r.URL.RawQuery)
+			}
+
+			chunks := readChunks(t, rec.Body.Bytes())
+			if len(chunks) < 2 {
+				t.Fatalf("%d chunks:\n%s", len(chunks), rec.Body)
+			}
+
+			// The last chunk carries only the usage
+			last := chunks[len(chunks)-1]
+			if len(last.Choices) != 0 || last.Usage == nil || *last.Usage != *tt.usage {
+				t.Errorf("usage chunk = %+v", last)
+			}
+			chunks = chunks[:len(chunks)-1]
+
+			var content strings.Builder
+			var calls []openAIToolCall
+			var finishes []string
+			for i, chunk := range chunks {
+				if chunk.ID != chunks[0].ID || !strings.HasPrefix(chunk.ID, "chatcmpl-") || chunk.Object != "chat.completion.chunk" {
+					t.Errorf("chunk %d: id %q, object %q", i, chunk.ID, chunk.Object)
+				}
+				if chunk.Usage != nil {
+					t.Errorf("chunk %d carries usage", i)
+				}
+				for _, c := range chunk.Choices {
+					if (c.Delta.Role == "assistant") != (i == 0) {
+						t.Errorf("chunk %d: role %q", i, c.Delta.Role)
+					}
+					if c.Delta.Content != nil {
+						content.WriteString(*c.Delta.Content)
+					}
+					calls = append(calls, c.Delta.ToolCalls...)
+					if c.FinishReason != nil {
+						finishes = append(finishes, *c.FinishReason)
+					}
+				}
+			}
+			if content.String() != tt.content {
+				t.Errorf("content = %q, want %q", content.String(), tt.content)
+			}
+			assertToolCalls(t, calls, tt.toolCalls, true)
+			if len(finishes) != 1 || finishes[0] != tt.finish {
+				t.Errorf("finish reasons %v, want [%s]", finishes, tt.finish)
+			}
+		})
+	}
+}
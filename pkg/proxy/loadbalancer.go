@@ -1,45 +1,124 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"math/rand"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/ngoyal88/relay/pkg/apierror"
 	"github.com/sony/gobreaker"
 )
 
+// drainTimeout bounds how long a removed target's in-flight requests are
+// waited for before its connections are closed
+const drainTimeout = 10 * time.Minute
+
 // Target represents a backend target with its configuration
 type Target struct {
 	URL            *url.URL
 	Weight         int
 	Proxy          *httputil.ReverseProxy
+	Adapter        Adapter // Translates requests for non-OpenAI providers; nil passes them through
 	CircuitBreaker *gobreaker.CircuitBreaker
 	Healthy        atomic.Bool
 	LastCheck      time.Time
+	models         map[string]bool // Discovered by the adapter's Prober; nil if unknown
+	listed         []string        // For the model catalogue only, from a modelLister
+	provider       string
+	config         TargetConfig
+	state          TargetState // Guarded by the load balancer's mu, like Weight
+	source         string      // Guarded by the load balancer's mu, like Weight
+	transport      *http.Transport
+	latency        *LatencyTracker
+	inflight       atomic.Int64
 	mu             sync.RWMutex
 }
 
+// Models returns the models the target was found to serve, or nil if its
+// backend can't list them
+func (t *Target) Models() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.models == nil {
+		return nil
+	}
+	out := make([]string, 0, len(t.models))
+	for m := range t.models {
+		out = append(out, m)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (t *Target) setModels(models []string) {
+	set := make(map[string]bool, len(models))
+	for _, m := range models {
+		set[m] = true
+	}
+	t.mu.Lock()
+	t.models = set
+	t.mu.Unlock()
+}
+
+// servesModel reports whether the target's model list is known and includes model
+func (t *Target) servesModel(model string) (known, serves bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.models == nil {
+		return false, false
+	}
+	return true, t.models[model]
+}
+
 // LoadBalancer manages multiple targets with different strategies
 type LoadBalancer struct {
 	targets  []*Target
 	strategy string // "round-robin", "weighted", "least-latency", "random"
 	current  atomic.Uint64
-	latency  map[string]*LatencyTracker
 	mu       sync.RWMutex
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // TargetConfig represents target configuration
 type TargetConfig struct {
-	URL    string `mapstructure:"url"`
-	Weight int    `mapstructure:"weight"`
+	URL      string `mapstructure:"url" json:"url"`
+	Weight   int    `mapstructure:"weight" json:"weight,omitempty"`
+	Provider string `mapstructure:"provider" json:"provider,omitempty"` // openai (default), gemini, ollama, openai-compatible or azure
+	APIKey   string `mapstructure:"api_key" json:"api_key,omitempty"`   // Provider credential; defaults to the client's bearer token
+
+	// Azure only: model name -> deployment name, and the api-version to call
+	Deployments map[string]string `mapstructure:"deployments" json:"deployments,omitempty"`
+	APIVersion  string            `mapstructure:"api_version" json:"api_version,omitempty"`
+
+	// Set at runtime, never from the config file
+	State  TargetState `mapstructure:"-" json:"-"` // Through the admin API
+	Source string      `mapstructure:"-" json:"-"` // Where the target came from: config (default), discovery or admin
 }
 
+// TargetState takes a target out of rotation without removing it
+type TargetState string
+
+const (
+	TargetActive   TargetState = ""
+	TargetDraining TargetState = "draining" // No new requests; in-flight ones finish and health checks go on
+	TargetDisabled TargetState = "disabled" // No new requests and no health checks
+)
+
 // LatencyTracker tracks response times for a target
 type LatencyTracker struct {
 	mu      sync.Mutex
@@ -47,76 +126,288 @@ type LatencyTracker struct {
 	maxSize int
 }
 
-// NewLoadBalancer creates a new load balancer
+// NewLoadBalancer creates a new load balancer. Without targets it answers
+// every request with no_healthy_upstream until Update gives it some.
 func NewLoadBalancer(configs []TargetConfig, strategy string) (*LoadBalancer, error) {
-	if len(configs) == 0 {
-		return nil, fmt.Errorf("no targets configured")
-	}
-
 	lb := &LoadBalancer{
 		targets:  make([]*Target, 0, len(configs)),
 		strategy: strategy,
-		latency:  make(map[string]*LatencyTracker),
+		stop:     make(chan struct{}),
+	}
+
+	for _, cfg := range configs {
+		target, err := newTarget(cfg)
+		if err != nil {
+			return nil, err
+		}
+		lb.targets = append(lb.targets, target)
+	}
+
+	// Start health checks
+	go lb.healthCheckLoop()
+	go lb.catalogLoop()
+
+	return lb, nil
+}
+
+// TargetURL normalizes a target URL the way Status reports it, so URLs from
+// the config file, discovery and the admin API compare equal
+func TargetURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
 	}
+	return u.String()
+}
 
+// ValidateTargets reports the first target NewLoadBalancer or Update would reject
+func ValidateTargets(configs []TargetConfig) error {
 	for _, cfg := range configs {
 		parsedURL, err := url.Parse(cfg.URL)
 		if err != nil {
-			return nil, fmt.Errorf("invalid target URL %s: %w", cfg.URL, err)
+			return fmt.Errorf("invalid target URL %s: %w", cfg.URL, err)
 		}
+		if _, err := newAdapter(cfg, parsedURL); err != nil {
+			return fmt.Errorf("target %s: %w", cfg.URL, err)
+		}
+	}
+	return nil
+}
+
+func newTarget(cfg TargetConfig) (*Target, error) {
+	parsedURL, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target URL %s: %w", cfg.URL, err)
+	}
+
+	weight := cfg.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+
+	adapter, err := newAdapter(cfg, parsedURL)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: %w", cfg.URL, err)
+	}
+
+	// Own connection pool, so it can be closed once the target is removed and drained
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxy := httputil.NewSingleHostReverseProxy(parsedURL)
+	proxy.Director = func(req *http.Request) {
+		req.URL.Scheme = parsedURL.Scheme
+		req.URL.Host = parsedURL.Host
+		req.Header.Set("X-Relay", "True")
+		setUpstreamEncoding(req)
+	}
+	proxy.Transport = transport
+	proxy.ModifyResponse = modifyResponse(adapter)
+	proxy.ErrorHandler = writeUpstreamError
+
+	// Circuit breaker per target
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:    fmt.Sprintf("target-%s", parsedURL.Host),
+		Timeout: 30 * time.Second,
+		ReadyToTrip: func(c gobreaker.Counts) bool {
+			return c.ConsecutiveFailures >= 5
+		},
+	})
+
+	target := &Target{
+		URL:            parsedURL,
+		Weight:         weight,
+		state:          cfg.State,
+		source:         cfg.Source,
+		Proxy:          proxy,
+		Adapter:        adapter,
+		CircuitBreaker: cb,
+		provider:       providerName(cfg.Provider),
+		config:         cfg,
+		transport:      transport,
+		latency:        NewLatencyTracker(100),
+	}
+	target.Healthy.Store(true)
+	if configured, ok := adapter.(configuredModels); ok {
+		target.setModels(configured.models())
+	}
+	return target, nil
+}
+
+// sameTarget reports whether two configs describe the same backend; the
+// weight, state and source may differ
+func sameTarget(a, b TargetConfig) bool {
+	a.Weight, b.Weight = 0, 0
+	a.State, b.State = TargetActive, TargetActive
+	a.Source, b.Source = "", ""
+	return reflect.DeepEqual(a, b)
+}
+
+// Update replaces the targets and strategy in one step. Targets whose config
+// is unchanged apart from the weight and state are kept with their health, circuit
+// breaker, latency and model state. Removed targets finish their in-flight
+// requests before their connections are closed. On error nothing changes.
+func (lb *LoadBalancer) Update(configs []TargetConfig, strategy string) error {
+	update, err := lb.PrepareUpdate(configs, strategy)
+	if err != nil {
+		return err
+	}
+	update.Apply()
+	return nil
+}
+
+// TargetUpdate is an Update whose new targets are built but not yet in use,
+// so several load balancers can be changed together or not at all
+type TargetUpdate struct {
+	lb       *LoadBalancer
+	configs  []TargetConfig
+	strategy string
+	current  []*Target // Targets when the update was prepared
+	targets  []*Target
+	weights  []int
+	added    []*Target
+	kept     map[*Target]bool
+}
+
+// PrepareUpdate builds what Update would switch to without changing the load
+// balancer. Apply or Discard the result before updating it again.
+func (lb *LoadBalancer) PrepareUpdate(configs []TargetConfig, strategy string) (*TargetUpdate, error) {
+	lb.mu.RLock()
+	current := lb.targets
+	lb.mu.RUnlock()
+
+	u := &TargetUpdate{
+		lb:       lb,
+		configs:  configs,
+		strategy: strategy,
+		current:  current,
+		targets:  make([]*Target, 0, len(configs)),
+		weights:  make([]int, 0, len(configs)),
+		kept:     make(map[*Target]bool),
+	}
+	for _, cfg := range configs {
+		var target *Target
+		for _, t := range current {
+			if !u.kept[t] && sameTarget(t.config, cfg) {
+				target = t
+				break
+			}
+		}
+		if target == nil {
+			var err error
+			if target, err = newTarget(cfg); err != nil {
+				u.Discard()
+				return nil, err
+			}
+			u.added = append(u.added, target)
+		}
+		u.kept[target] = true
 
 		weight := cfg.Weight
 		if weight <= 0 {
 			weight = 1
 		}
+		u.targets = append(u.targets, target)
+		u.weights = append(u.weights, weight)
+	}
+	return u, nil
+}
 
-		proxy := httputil.NewSingleHostReverseProxy(parsedURL)
-		proxy.Director = func(req *http.Request) {
-			req.URL.Scheme = parsedURL.Scheme
-			req.URL.Host = parsedURL.Host
-			req.Header.Set("X-Relay", "True")
-		}
+// Apply switches the load balancer to the prepared targets
+func (u *TargetUpdate) Apply() {
+	lb := u.lb
 
-		// Circuit breaker per target
-		cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{
-			Name:    fmt.Sprintf("target-%s", parsedURL.Host),
-			Timeout: 30 * time.Second,
-			ReadyToTrip: func(c gobreaker.Counts) bool {
-				return c.ConsecutiveFailures >= 5
-			},
-		})
-
-		target := &Target{
-			URL:            parsedURL,
-			Weight:         weight,
-			Proxy:          proxy,
-			CircuitBreaker: cb,
+	// Weights and states are only read under lb.mu, so kept targets can be changed in place
+	lb.mu.Lock()
+	for i, t := range u.targets {
+		t.Weight = u.weights[i]
+		t.state = u.configs[i].State
+		t.source = u.configs[i].Source
+	}
+	lb.targets = u.targets
+	lb.strategy = u.strategy
+	lb.mu.Unlock()
+
+	for _, t := range u.added {
+		if t.state == TargetDisabled {
+			continue
 		}
-		target.Healthy.Store(true)
+		go lb.checkHealth(t)
+		if lister, ok := t.Adapter.(modelLister); ok {
+			go lb.listModels(t, lister)
+		}
+	}
+	for _, t := range u.current {
+		if !u.kept[t] {
+			go t.drain()
+		}
+	}
+}
 
-		lb.targets = append(lb.targets, target)
-		lb.latency[parsedURL.String()] = NewLatencyTracker(100)
+// Discard drops the prepared update, leaving the load balancer as it was
+func (u *TargetUpdate) Discard() {
+	for _, t := range u.added {
+		t.transport.CloseIdleConnections()
 	}
+}
 
-	// Start health checks
-	go lb.healthCheckLoop()
+// Close stops the background checks and drains every target. In-flight
+// requests still complete.
+func (lb *LoadBalancer) Close() {
+	lb.stopOnce.Do(func() {
+		close(lb.stop)
+		lb.mu.RLock()
+		targets := lb.targets
+		lb.mu.RUnlock()
+		for _, t := range targets {
+			go t.drain()
+		}
+	})
+}
 
-	return lb, nil
+// drain waits for the target's in-flight requests, then closes its connections
+func (t *Target) drain() {
+	deadline := time.Now().Add(drainTimeout)
+	for t.inflight.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+	}
+	if n := t.inflight.Load(); n > 0 {
+		log.Printf("⚠️ [LB] Closing removed target %s with %d requests still in flight", t.URL.Host, n)
+	}
+	t.transport.CloseIdleConnections()
 }
 
+// errModelNotServed means no target lists the requested model and every
+// target's model list is known
+var errModelNotServed = errors.New("model not served")
+
 // ServeHTTP implements http.Handler
 func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	target, err := lb.selectTarget()
+	model := requestModel(r)
+	target, err := lb.selectTarget(model)
+	if errors.Is(err, errModelNotServed) {
+		apierror.Write(w, r, apierror.CodeModelNotFound, fmt.Sprintf("The model %q is not served by any target", model))
+		return
+	}
 	if err != nil {
-		http.Error(w, "No healthy backends available", http.StatusServiceUnavailable)
+		apierror.Write(w, r, apierror.CodeNoHealthyUpstream, "No healthy backends available")
 		return
 	}
 
-	// Track latency
+	if target.Adapter != nil {
+		prepared, err := target.Adapter.PrepareRequest(r)
+		if err != nil {
+			writeAdapterError(w, r, err)
+			return
+		}
+		r = prepared
+	}
+
+	// Track in-flight requests (for draining) and latency
+	target.inflight.Add(1)
 	start := time.Now()
 	defer func() {
-		latency := time.Since(start)
-		lb.recordLatency(target.URL.String(), latency)
+		target.latency.Add(time.Since(start))
+		target.inflight.Add(-1)
 	}()
 
 	// Use circuit breaker
@@ -132,20 +423,61 @@ func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
-			http.Error(w, "Service Unavailable (circuit open)", http.StatusServiceUnavailable)
+			apierror.Write(w, r, apierror.CodeCircuitOpen, "Service Unavailable (circuit open)")
 		}
 	}
 }
 
-// selectTarget chooses a backend based on the configured strategy
-func (lb *LoadBalancer) selectTarget() (*Target, error) {
+// requestModel reads the model from a JSON request body, restoring the body
+func requestModel(r *http.Request) string {
+	if r.Body == nil || r.Method != http.MethodPost || !strings.Contains(r.Header.Get("Content-Type"), "json") {
+		return ""
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	var req struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(body, &req)
+	return req.Model
+}
+
+// selectTarget chooses a backend based on the configured strategy. With a
+// model, targets known to serve it are preferred; targets whose models are
+// unknown are used when none lists it.
+func (lb *LoadBalancer) selectTarget(model string) (*Target, error) {
 	lb.mu.RLock()
 	defer lb.mu.RUnlock()
 
+	candidates := lb.targets
+	if model != "" {
+		var serving, unknown []*Target
+		for _, t := range lb.targets {
+			known, serves := t.servesModel(model)
+			switch {
+			case serves:
+				serving = append(serving, t)
+			case !known:
+				unknown = append(unknown, t)
+			}
+		}
+		switch {
+		case len(serving) > 0:
+			candidates = serving
+		case len(unknown) > 0:
+			candidates = unknown
+		default:
+			return nil, errModelNotServed
+		}
+	}
+
 	// Filter healthy targets
-	healthy := make([]*Target, 0, len(lb.targets))
-	for _, t := range lb.targets {
-		if t.Healthy.Load() && t.CircuitBreaker.State() != gobreaker.StateOpen {
+	healthy := make([]*Target, 0, len(candidates))
+	for _, t := range candidates {
+		if t.state == TargetActive && t.Healthy.Load() && t.CircuitBreaker.State() != gobreaker.StateOpen {
 			healthy = append(healthy, t)
 		}
 	}
@@ -201,7 +533,7 @@ func (lb *LoadBalancer) leastLatency(targets []*Target) *Target {
 	var bestLatency time.Duration = time.Hour
 
 	for _, t := range targets {
-		avg := lb.getAverageLatency(t.URL.String())
+		avg := t.latency.Average()
 		if avg < bestLatency {
 			bestLatency = avg
 			best = t
@@ -215,31 +547,29 @@ func (lb *LoadBalancer) leastLatency(targets []*Target) *Target {
 	return best
 }
 
-// recordLatency stores latency measurement
-func (lb *LoadBalancer) recordLatency(targetURL string, latency time.Duration) {
-	if tracker, ok := lb.latency[targetURL]; ok {
-		tracker.Add(latency)
-	}
-}
-
-// getAverageLatency calculates average latency for a target
-func (lb *LoadBalancer) getAverageLatency(targetURL string) time.Duration {
-	tracker, ok := lb.latency[targetURL]
-	if !ok {
-		return time.Millisecond * 100 // Default
-	}
-	return tracker.Average()
-}
-
-// healthCheckLoop periodically checks target health
+// healthCheckLoop periodically checks target health, starting right away so
+// model lists are discovered before the first interval
 func (lb *LoadBalancer) healthCheckLoop() {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		for _, target := range lb.targets {
+	for {
+		lb.mu.RLock()
+		var targets []*Target
+		for _, t := range lb.targets {
+			if t.state != TargetDisabled {
+				targets = append(targets, t)
+			}
+		}
+		lb.mu.RUnlock()
+		for _, target := range targets {
 			go lb.checkHealth(target)
 		}
+		select {
+		case <-ticker.C:
+		case <-lb.stop:
+			return
+		}
 	}
 }
 
@@ -248,6 +578,19 @@ func (lb *LoadBalancer) checkHealth(target *Target) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// Backends with their own endpoints are probed there, which also lists their models
+	if prober, ok := target.Adapter.(Prober); ok {
+		models, err := prober.Probe(ctx, target.URL)
+		target.Healthy.Store(err == nil)
+		if err == nil {
+			target.setModels(models)
+		}
+		target.mu.Lock()
+		target.LastCheck = time.Now()
+		target.mu.Unlock()
+		return
+	}
+
 	// Simple HTTP GET to /health or root
 	healthURL := target.URL.String() + "/health"
 	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
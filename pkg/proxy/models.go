@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// catalogInterval is how often models are listed for the catalogue
+const catalogInterval = 5 * time.Minute
+
+// Model is an entry in the load balancer's model catalogue
+type Model struct {
+	ID      string
+	OwnedBy string // Provider of the first target that has it
+}
+
+// modelLister is implemented by adapters that can list a backend's models
+// for the catalogue. Unlike a Prober's, the list doesn't restrict routing.
+type modelLister interface {
+	listModels(ctx context.Context, base *url.URL) ([]string, error)
+}
+
+// Models merges the models of every target: those it was probed or configured
+// to serve, and those its provider lists
+func (lb *LoadBalancer) Models() []Model {
+	lb.mu.RLock()
+	targets := lb.targets
+	lb.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var out []Model
+	for _, t := range targets {
+		t.mu.RLock()
+		ids := make([]string, 0, len(t.models)+len(t.listed))
+		for m := range t.models {
+			ids = append(ids, m)
+		}
+		ids = append(ids, t.listed...)
+		t.mu.RUnlock()
+
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				out = append(out, Model{ID: id, OwnedBy: t.provider})
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// catalogLoop periodically lists the models of targets whose adapters can
+func (lb *LoadBalancer) catalogLoop() {
+	ticker := time.NewTicker(catalogInterval)
+	defer ticker.Stop()
+
+	for {
+		lb.mu.RLock()
+		targets := lb.targets
+		lb.mu.RUnlock()
+		for _, target := range targets {
+			if lister, ok := target.Adapter.(modelLister); ok {
+				go lb.listModels(target, lister)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-lb.stop:
+			return
+		}
+	}
+}
+
+// listModels refreshes a target's listed models, keeping the last list on failure
+func (lb *LoadBalancer) listModels(target *Target, lister modelLister) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	models, err := lister.listModels(ctx, target.URL)
+	if err != nil {
+		log.Printf("⚠️ [LB] Failed to list models of %s: %v", target.URL.Host, err)
+		return
+	}
+	target.mu.Lock()
+	target.listed = models
+	target.mu.Unlock()
+}
@@ -0,0 +1,533 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/apierror"
+)
+
+// ollamaAdapter maps OpenAI chat completions and embeddings onto Ollama's
+// native /api/chat and /api/embed endpoints
+type ollamaAdapter struct {
+	apiKey string // Sent as a bearer token, for Ollama behind an authenticating proxy
+}
+
+type ollamaCall struct {
+	model        string
+	stream       bool
+	includeUsage bool
+	embeddings   bool
+}
+
+const ollamaCallContextKey contextKey = "ollama_call"
+
+type ollamaChatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []ollamaMessage        `json:"messages"`
+	Tools    []openAITool           `json:"tools,omitempty"`
+	Format   json.RawMessage        `json:"format,omitempty"` // "json" or a JSON schema
+	Options  map[string]interface{} `json:"options,omitempty"`
+	Stream   bool                   `json:"stream"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaChatResponse struct {
+	Model           string        `json:"model"`
+	CreatedAt       time.Time     `json:"created_at"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+// PrepareRequest implements Adapter
+func (a *ollamaAdapter) PrepareRequest(r *http.Request) (*http.Request, error) {
+	if r.Method != http.MethodPost {
+		return nil, &adapterError{apierror.CodeUnsupportedEndpoint, fmt.Sprintf("%s %s is not supported by the ollama provider", r.Method, r.URL.Path)}
+	}
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, &adapterError{apierror.CodeInvalidRequest, "failed to read request body"}
+	}
+
+	var (
+		path string
+		body interface{}
+		call *ollamaCall
+	)
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/chat/completions"):
+		var req openAIChatRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return nil, &adapterError{apierror.CodeInvalidRequest, "invalid JSON body: " + err.Error()}
+		}
+		chat, err := toOllamaChat(&req)
+		if err != nil {
+			return nil, &adapterError{apierror.CodeInvalidRequest, err.Error()}
+		}
+		path, body = "/api/chat", chat
+		call = &ollamaCall{model: req.Model, stream: req.Stream}
+		if req.StreamOptions != nil {
+			call.includeUsage = req.StreamOptions.IncludeUsage
+		}
+
+	case strings.HasSuffix(r.URL.Path, "/embeddings"):
+		var req struct {
+			Model string          `json:"model"`
+			Input json.RawMessage `json:"input"`
+		}
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return nil, &adapterError{apierror.CodeInvalidRequest, "invalid JSON body: " + err.Error()}
+		}
+		path, body = "/api/embed", req
+		call = &ollamaCall{model: req.Model, embeddings: true}
+
+	default:
+		return nil, &adapterError{apierror.CodeUnsupportedEndpoint, fmt.Sprintf("%s %s is not supported by the ollama provider", r.Method, r.URL.Path)}
+	}
+	if call.model == "" {
+		return nil, &adapterError{apierror.CodeInvalidRequest, "model is required"}
+	}
+
+	out, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	pr := rewriteRequest(r, ollamaCallContextKey, call, path, "", out)
+
+	// Never hand the client's credentials to a self-hosted box
+	pr.Header.Del("Authorization")
+	if a.apiKey != "" {
+		pr.Header.Set("Authorization", "Bearer "+a.apiKey)
+	}
+	return pr, nil
+}
+
+func toOllamaChat(req *openAIChatRequest) (*ollamaChatRequest, error) {
+	if req.N != nil && *req.N > 1 {
+		return nil, fmt.Errorf("n > 1 is not supported by the ollama provider")
+	}
+	out := &ollamaChatRequest{Model: req.Model, Tools: req.Tools, Stream: req.Stream}
+
+	callNames := make(map[string]string)
+	for _, m := range req.Messages {
+		for _, tc := range m.ToolCalls {
+			callNames[tc.ID] = tc.Function.Name
+		}
+	}
+
+	for i, m := range req.Messages {
+		text, images, err := ollamaContent(m.Content)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		om := ollamaMessage{Role: m.Role, Content: text, Images: images}
+		switch m.Role {
+		case "system", "user", "assistant":
+		case "developer":
+			om.Role = "system"
+		case "tool", "function":
+			om.Role = "tool"
+			om.ToolName = callNames[m.ToolCallID]
+			if om.ToolName == "" {
+				om.ToolName = m.Name
+			}
+		default:
+			return nil, fmt.Errorf("messages[%d]: unsupported role %q", i, m.Role)
+		}
+		for _, tc := range m.ToolCalls {
+			var call ollamaToolCall
+			call.Function.Name = tc.Function.Name
+			call.Function.Arguments = json.RawMessage(tc.Function.Arguments)
+			if len(bytes.TrimSpace(call.Function.Arguments)) == 0 {
+				call.Function.Arguments = json.RawMessage("{}")
+			} else if !json.Valid(call.Function.Arguments) {
+				return nil, fmt.Errorf("messages[%d]: tool call %s has invalid JSON arguments", i, tc.ID)
+			}
+			om.ToolCalls = append(om.ToolCalls, call)
+		}
+		out.Messages = append(out.Messages, om)
+	}
+
+	// Ollama has no tool_choice; "none" is honoured by not offering the tools
+	var choice string
+	if json.Unmarshal(req.ToolChoice, &choice) == nil && choice == "none" {
+		out.Tools = nil
+	}
+
+	if rf := req.ResponseFormat; rf != nil {
+		switch rf.Type {
+		case "json_object":
+			out.Format = json.RawMessage(`"json"`)
+		case "json_schema":
+			out.Format = json.RawMessage(`"json"`)
+			if rf.JSONSchema != nil && len(rf.JSONSchema.Schema) > 0 {
+				out.Format = rf.JSONSchema.Schema
+			}
+		}
+	}
+
+	opts := make(map[string]interface{})
+	setOpt := func(name string, v interface{}, ok bool) {
+		if ok {
+			opts[name] = v
+		}
+	}
+	setOpt("temperature", req.Temperature, req.Temperature != nil)
+	setOpt("top_p", req.TopP, req.TopP != nil)
+	setOpt("num_predict", req.maxTokens(), req.maxTokens() != nil)
+	setOpt("seed", req.Seed, req.Seed != nil)
+	setOpt("presence_penalty", req.PresencePenalty, req.PresencePenalty != nil)
+	setOpt("frequency_penalty", req.FrequencyPenalty, req.FrequencyPenalty != nil)
+	stop, err := req.stopSequences()
+	if err != nil {
+		return nil, err
+	}
+	setOpt("stop", stop, len(stop) > 0)
+	if len(opts) > 0 {
+		out.Options = opts
+	}
+	return out, nil
+}
+
+// ollamaContent splits message content into text and base64 images
+func ollamaContent(content json.RawMessage) (string, []string, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return "", nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text, nil, nil
+	}
+
+	var parts []openAIContentPart
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return "", nil, fmt.Errorf("content must be a string or an array of parts")
+	}
+	var sb strings.Builder
+	var images []string
+	for _, p := range parts {
+		switch p.Type {
+		case "text":
+			sb.WriteString(p.Text)
+		case "image_url":
+			if p.ImageURL == nil {
+				return "", nil, fmt.Errorf("image_url part without a url")
+			}
+			rest, ok := strings.CutPrefix(p.ImageURL.URL, "data:")
+			_, data, found := strings.Cut(rest, ";base64,")
+			if !ok || !found {
+				return "", nil, fmt.Errorf("the ollama provider only accepts images as base64 data URLs")
+			}
+			images = append(images, data)
+		default:
+			return "", nil, fmt.Errorf("unsupported content part type %q", p.Type)
+		}
+	}
+	return sb.String(), images, nil
+}
+
+// ModifyResponse implements Adapter
+func (a *ollamaAdapter) ModifyResponse(resp *http.Response) error {
+	call, ok := resp.Request.Context().Value(ollamaCallContextKey).(*ollamaCall)
+	if !ok {
+		return nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		setBody(resp, ollamaError(resp.StatusCode, body), "application/json")
+		return nil
+	}
+
+	if call.stream {
+		pr, pw := io.Pipe()
+		go convertOllamaStream(resp.Body, pw, call)
+		resp.Body = &pipeBody{PipeReader: pr, src: resp.Body}
+		resp.Header.Set("Content-Type", "text/event-stream")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	var out interface{}
+	if call.embeddings {
+		out, err = toOpenAIEmbeddings(body, call)
+	} else {
+		var oresp ollamaChatResponse
+		if err = json.Unmarshal(body, &oresp); err == nil {
+			out = ollamaCompletion(&oresp, call)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid ollama response: %w", err)
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	setBody(resp, data, "application/json")
+	return nil
+}
+
+// ollamaError converts {"error": "..."} to the OpenAI error shape
+func ollamaError(status int, body []byte) []byte {
+	var oerr struct {
+		Error string `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &oerr) == nil && oerr.Error != "" {
+		message = oerr.Error
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	var code interface{}
+	if status == http.StatusNotFound && strings.Contains(message, "not found") {
+		code = "model_not_found"
+	}
+	return apierror.Marshal(&apierror.Error{Message: message, Type: apierror.TypeForStatus(status), Code: code})
+}
+
+func ollamaToolCalls(calls []ollamaToolCall, nextIndex int) []openAIToolCall {
+	out := make([]openAIToolCall, 0, len(calls))
+	for i, c := range calls {
+		idx := nextIndex + i
+		tc := openAIToolCall{Index: &idx, ID: newResponseID("call_"), Type: "function"}
+		tc.Function.Name = c.Function.Name
+		tc.Function.Arguments = string(c.Function.Arguments)
+		if tc.Function.Arguments == "" || tc.Function.Arguments == "null" {
+			tc.Function.Arguments = "{}"
+		}
+		out = append(out, tc)
+	}
+	return out
+}
+
+func ollamaFinishReason(reason string, toolCalls bool) string {
+	if reason == "length" {
+		return "length"
+	}
+	if toolCalls {
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+func ollamaUsage(o *ollamaChatResponse) *openAIUsage {
+	return &openAIUsage{
+		PromptTokens:     o.PromptEvalCount,
+		CompletionTokens: o.EvalCount,
+		TotalTokens:      o.PromptEvalCount + o.EvalCount,
+	}
+}
+
+func ollamaCreated(o *ollamaChatResponse) int64 {
+	if o.CreatedAt.IsZero() {
+		return time.Now().Unix()
+	}
+	return o.CreatedAt.Unix()
+}
+
+func ollamaCompletion(o *ollamaChatResponse, call *ollamaCall) map[string]interface{} {
+	calls := ollamaToolCalls(o.Message.ToolCalls, 0)
+	for i := range calls {
+		calls[i].Index = nil
+	}
+	msg := openAIChoiceMessage{Role: "assistant", ToolCalls: calls}
+	if o.Message.Content != "" || len(calls) == 0 {
+		msg.Content = &o.Message.Content
+	}
+
+	model := o.Model
+	if model == "" {
+		model = call.model
+	}
+	return map[string]interface{}{
+		"id":      newResponseID("chatcmpl-"),
+		"object":  "chat.completion",
+		"created": ollamaCreated(o),
+		"model":   model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"message":       msg,
+			"finish_reason": ollamaFinishReason(o.DoneReason, len(calls) > 0),
+		}},
+		"usage": ollamaUsage(o),
+	}
+}
+
+func toOpenAIEmbeddings(body []byte, call *ollamaCall) (map[string]interface{}, error) {
+	var oresp struct {
+		Model           string      `json:"model"`
+		Embeddings      [][]float64 `json:"embeddings"`
+		PromptEvalCount int         `json:"prompt_eval_count"`
+	}
+	if err := json.Unmarshal(body, &oresp); err != nil {
+		return nil, err
+	}
+
+	data := make([]map[string]interface{}, 0, len(oresp.Embeddings))
+	for i, e := range oresp.Embeddings {
+		data = append(data, map[string]interface{}{
+			"object":    "embedding",
+			"index":     i,
+			"embedding": e,
+		})
+	}
+	model := oresp.Model
+	if model == "" {
+		model = call.model
+	}
+	return map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"model":  model,
+		"usage": map[string]int{
+			"prompt_tokens": oresp.PromptEvalCount,
+			"total_tokens":  oresp.PromptEvalCount,
+		},
+	}, nil
+}
+
+// convertOllamaStream rewrites Ollama's newline-delimited JSON stream as
+// chat.completion.chunk server-sent events
+func convertOllamaStream(src io.Reader, dst *io.PipeWriter, call *ollamaCall) {
+	id := newResponseID("chatcmpl-")
+	started := false
+	toolCalls := 0
+
+	emit := func(v interface{}) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(dst, "data: %s\n\n", data)
+		return err
+	}
+
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var o ollamaChatResponse
+		if err := json.Unmarshal(line, &o); err != nil {
+			continue
+		}
+		model := o.Model
+		if model == "" {
+			model = call.model
+		}
+
+		delta := map[string]interface{}{}
+		if !started {
+			delta["role"] = "assistant"
+			started = true
+		}
+		if o.Message.Content != "" {
+			delta["content"] = o.Message.Content
+		}
+		if len(o.Message.ToolCalls) > 0 {
+			delta["tool_calls"] = ollamaToolCalls(o.Message.ToolCalls, toolCalls)
+			toolCalls += len(o.Message.ToolCalls)
+		}
+		var finish interface{}
+		if o.Done {
+			finish = ollamaFinishReason(o.DoneReason, toolCalls > 0)
+		}
+
+		chunk := func(choices []map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{
+				"id":      id,
+				"object":  "chat.completion.chunk",
+				"created": ollamaCreated(&o),
+				"model":   model,
+				"choices": choices,
+			}
+		}
+		if len(delta) > 0 || finish != nil {
+			err := emit(chunk([]map[string]interface{}{{
+				"index":         0,
+				"delta":         delta,
+				"finish_reason": finish,
+			}}))
+			if err != nil {
+				dst.CloseWithError(err)
+				return
+			}
+		}
+		if o.Done && call.includeUsage {
+			final := chunk([]map[string]interface{}{})
+			final["usage"] = ollamaUsage(&o)
+			if err := emit(final); err != nil {
+				dst.CloseWithError(err)
+				return
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		dst.CloseWithError(err)
+		return
+	}
+	io.WriteString(dst, "data: [DONE]\n\n")
+	dst.Close()
+}
+
+// Probe implements Prober using GET /api/tags
+func (a *ollamaAdapter) Probe(ctx context.Context, base *url.URL) ([]string, error) {
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := probeJSON(ctx, base, "/api/tags", a.apiKey, &tags); err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		models = append(models, m.Name)
+		// Ollama resolves "llama3" to "llama3:latest"
+		if name, ok := strings.CutSuffix(m.Name, ":latest"); ok {
+			models = append(models, name)
+		}
+	}
+	return models, nil
+}
@@ -2,12 +2,12 @@ package proxy
 
 import (
 	"errors"
-	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"time"
 
+	"github.com/ngoyal88/relay/pkg/apierror"
 	"github.com/sony/gobreaker"
 )
 
@@ -30,13 +30,10 @@ func New(targetURL string) (*Relay, error) {
 		req.URL.Scheme = parsedURL.Scheme
 		req.URL.Host = parsedURL.Host
 		req.Header.Set("X-Relay", "True")
+		setUpstreamEncoding(req)
 	}
-
-	// Log upstream errors so network/DNS/TLS issues are visible.
-	p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("[PROXY] upstream error: %v", err)
-		http.Error(w, "upstream error", http.StatusBadGateway)
-	}
+	p.ModifyResponse = modifyResponse(nil)
+	p.ErrorHandler = writeUpstreamError
 
 	settings := gobreaker.Settings{
 		Name:    "relay-upstream",
@@ -62,7 +59,7 @@ func (g *Relay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if g.cb.State() == gobreaker.StateOpen {
-		http.Error(w, "Service Unavailable (circuit open)", http.StatusServiceUnavailable)
+		apierror.Write(w, r, apierror.CodeCircuitOpen, "Service Unavailable (circuit open)")
 		return
 	}
 
@@ -78,14 +75,14 @@ func (g *Relay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
-			http.Error(w, "Service Unavailable (circuit open)", http.StatusServiceUnavailable)
+			apierror.Write(w, r, apierror.CodeCircuitOpen, "Service Unavailable (circuit open)")
 			return
 		}
 		// If the proxy already wrote a response, avoid double-writing.
 		if rec.wrote {
 			return
 		}
-		http.Error(w, "upstream error", http.StatusBadGateway)
+		apierror.Write(w, r, apierror.CodeUpstreamError, "upstream error")
 	}
 }
 
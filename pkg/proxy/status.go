@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"sort"
+	"time"
+)
+
+// TargetStatus is the live state of a load balancer target
+type TargetStatus struct {
+	URL       string       `json:"url"`
+	Provider  string       `json:"provider"`
+	Weight    int          `json:"weight"`
+	State     string       `json:"state"` // active, draining or disabled
+	Healthy   bool         `json:"healthy"`
+	Circuit   string       `json:"circuit"` // closed, half-open or open
+	InFlight  int64        `json:"in_flight"`
+	Latency   LatencyStats `json:"latency"`
+	LastCheck *time.Time   `json:"last_check,omitempty"`
+	Source    string       `json:"source"` // config, discovery or admin
+}
+
+// LatencyStats summarises a target's recent response times in milliseconds
+type LatencyStats struct {
+	Samples int     `json:"samples"`
+	AvgMs   float64 `json:"avg_ms"`
+	P50Ms   float64 `json:"p50_ms"`
+	P95Ms   float64 `json:"p95_ms"`
+}
+
+// Status returns the strategy and the live state of every target
+func (lb *LoadBalancer) Status() (string, []TargetStatus) {
+	lb.mu.RLock()
+	strategy := lb.strategy
+	out := make([]TargetStatus, 0, len(lb.targets))
+	for _, t := range lb.targets {
+		state := string(t.state)
+		if state == "" {
+			state = "active"
+		}
+		source := t.source
+		if source == "" {
+			source = "config"
+		}
+		var lastCheck *time.Time
+		t.mu.RLock()
+		if !t.LastCheck.IsZero() {
+			checked := t.LastCheck
+			lastCheck = &checked
+		}
+		t.mu.RUnlock()
+
+		out = append(out, TargetStatus{
+			URL:       t.URL.String(),
+			Provider:  t.provider,
+			Weight:    t.Weight,
+			State:     state,
+			Healthy:   t.Healthy.Load(),
+			Circuit:   t.CircuitBreaker.State().String(),
+			InFlight:  t.inflight.Load(),
+			Latency:   t.latency.Stats(),
+			LastCheck: lastCheck,
+			Source:    source,
+		})
+	}
+	lb.mu.RUnlock()
+	return strategy, out
+}
+
+// Stats summarises the recorded samples
+func (lt *LatencyTracker) Stats() LatencyStats {
+	lt.mu.Lock()
+	samples := append([]time.Duration(nil), lt.samples...)
+	lt.mu.Unlock()
+
+	if len(samples) == 0 {
+		return LatencyStats{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	var total time.Duration
+	for _, d := range samples {
+		total += d
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	percentile := func(p float64) time.Duration {
+		return samples[int(p*float64(len(samples)-1))]
+	}
+	return LatencyStats{
+		Samples: len(samples),
+		AvgMs:   ms(total / time.Duration(len(samples))),
+		P50Ms:   ms(percentile(0.50)),
+		P95Ms:   ms(percentile(0.95)),
+	}
+}
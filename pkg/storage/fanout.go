@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+)
+
+// LogSink receives a copy of every saved request log (files, archives, pipelines)
+type LogSink interface {
+	SaveRequestLog(ctx context.Context, log *RequestLog) error
+}
+
+// FanOutStore writes to a primary Store and copies each log to secondary sinks.
+// Reads and analytics are always served by the primary.
+type FanOutStore struct {
+	Store
+	sinks []LogSink
+}
+
+// NewFanOutStore wraps primary so that every saved log is also sent to sinks
+func NewFanOutStore(primary Store, sinks ...LogSink) *FanOutStore {
+	return &FanOutStore{Store: primary, sinks: sinks}
+}
+
+// SaveRequestLog saves to the primary; sink failures are logged but not returned
+func (s *FanOutStore) SaveRequestLog(ctx context.Context, entry *RequestLog) error {
+	err := s.Store.SaveRequestLog(ctx, entry)
+
+	for _, sink := range s.sinks {
+		if serr := sink.SaveRequestLog(ctx, entry); serr != nil {
+			log.Printf("[REQUEST LOG] sink %T failed for entry %s: %v", sink, entry.ID, serr)
+		}
+	}
+
+	return err
+}
+
+// Close closes the primary and every sink that holds resources, flushing
+// buffered logs
+func (s *FanOutStore) Close() error {
+	var errs []error
+	if c, ok := s.Store.(io.Closer); ok {
+		errs = append(errs, c.Close())
+	}
+	for _, sink := range s.sinks {
+		if c, ok := sink.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
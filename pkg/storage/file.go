@@ -0,0 +1,650 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileLogPrefix     = "requests-"
+	fileLogTimeLayout = "20060102T150405"
+)
+
+// FileStoreOptions configures the JSONL file store
+type FileStoreOptions struct {
+	Dir         string
+	MaxSize     int64         // Rotate once the active file reaches this many bytes
+	RotateEvery time.Duration // Rotate once the active file is this old
+	Compress    bool          // Gzip rotated files
+	Retention   time.Duration // Delete rotated files older than this
+}
+
+// FileStore implements Store by appending RequestLog records as JSON lines.
+// Files are named requests-<start>.jsonl and become requests-<start>.jsonl.gz once rotated.
+type FileStore struct {
+	opts FileStoreOptions
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// NewFileStore creates the log directory and opens a fresh active file
+func NewFileStore(opts FileStoreOptions) (*FileStore, error) {
+	if opts.Dir == "" {
+		opts.Dir = "./logs"
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 100 << 20 // 100MB
+	}
+	if opts.RotateEvery <= 0 {
+		opts.RotateEvery = 24 * time.Hour
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 30 * 24 * time.Hour
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log dir: %w", err)
+	}
+
+	s := &FileStore{opts: opts}
+
+	// Compress anything left uncompressed by a previous run before opening a new file
+	if opts.Compress {
+		if files, err := s.logFiles(); err == nil {
+			for _, f := range files {
+				if !strings.HasSuffix(f, ".gz") {
+					go s.compressFile(f)
+				}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.openLocked(time.Now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SaveRequestLog appends the log as a single JSON line, rotating first if needed
+func (s *FileStore) SaveRequestLog(ctx context.Context, entry *RequestLog) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.size+int64(len(data)) > s.opts.MaxSize || now.Sub(s.openedAt) >= s.opts.RotateEvery {
+		if err := s.rotateLocked(now); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	return err
+}
+
+// GetRequestLog scans the retained files for a log ID
+func (s *FileStore) GetRequestLog(ctx context.Context, id string) (*RequestLog, error) {
+	var found *RequestLog
+	err := s.scan(ctx, time.Time{}, time.Time{}, func(entry *RequestLog) bool {
+		if entry.ID == id {
+			found = entry
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("log %s not found", id)
+	}
+	return found, nil
+}
+
+// ListRequestLogs returns matching logs newest first
+func (s *FileStore) ListRequestLogs(ctx context.Context, filters LogFilters) ([]*RequestLog, error) {
+	limit := filters.Limit
+	if limit == 0 {
+		limit = 100 // Default limit
+	}
+
+	var logs []*RequestLog
+	skipped := 0
+	err := s.scan(ctx, filters.From, filters.To, func(entry *RequestLog) bool {
+		if !matchesFilters(entry, filters) {
+			return true
+		}
+		if skipped < filters.Offset {
+			skipped++
+			return true
+		}
+		logs = append(logs, entry)
+		return len(logs) < limit
+	})
+	return logs, err
+}
+
+// GetUsageStats aggregates the retained files on read
+func (s *FileStore) GetUsageStats(ctx context.Context, query StatsQuery) (*UsageStats, error) {
+	totals, err := s.aggregate(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return totals.usageStats(), nil
+}
+
+// GetCostStats aggregates the retained files on read
+func (s *FileStore) GetCostStats(ctx context.Context, query StatsQuery) (*CostStats, error) {
+	totals, err := s.aggregate(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return totals.costStats(), nil
+}
+
+// Ping checks that the active file is still writable
+func (s *FileStore) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("file store closed")
+	}
+	_, err := s.file.Stat()
+	return err
+}
+
+// Close closes the active file
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileStore) aggregate(ctx context.Context, query StatsQuery) (*rollupTotals, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	totals := newRollupTotals()
+	err := s.scan(ctx, query.From, query.To, func(entry *RequestLog) bool {
+		if query.UserID != "" && entry.UserID != query.UserID {
+			return true
+		}
+		if query.APIKey != "" && entry.APIKey != query.APIKey {
+			return true
+		}
+		if query.Model != "" && entry.Model != query.Model {
+			return true
+		}
+		if query.TeamID != "" && entry.TeamID != query.TeamID {
+			return true
+		}
+		totals.addLog(entry)
+		return true
+	})
+	return totals, err
+}
+
+// scan visits logs in [from, to] newest first until fn returns false.
+// A zero from/to leaves that side of the range open.
+func (s *FileStore) scan(ctx context.Context, from, to time.Time, fn func(*RequestLog) bool) error {
+	return s.walk(ctx, from, to, false, nil, func(entry *RequestLog, _ fileCursor) bool {
+		return fn(entry)
+	})
+}
+
+// fileCursor is a line position within a log file, identified by its stem.
+// Every line counts, parsed or not, so positions don't shift as the file grows.
+type fileCursor struct {
+	File string `json:"f"`
+	Line int    `json:"l"`
+}
+
+// QueryRequestLogs returns one page of matching logs in file order
+func (s *FileStore) QueryRequestLogs(ctx context.Context, filters LogFilters) (*LogPage, error) {
+	var start *fileCursor
+	if filters.Cursor != "" {
+		start = &fileCursor{}
+		if err := decodeCursor(filters.Cursor, start); err != nil {
+			return nil, err
+		}
+	}
+
+	limit := queryLimit(filters)
+	page := &LogPage{Logs: make([]*RequestLog, 0, limit)}
+	scanned := 0
+
+	err := s.walk(ctx, filters.From, filters.To, ascending(filters), start, func(entry *RequestLog, pos fileCursor) bool {
+		scanned++
+		if matchesFilters(entry, filters) {
+			page.Logs = append(page.Logs, entry)
+		}
+		if len(page.Logs) == limit || scanned >= maxQueryScan {
+			page.NextCursor = encodeCursor(pos)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// walk visits logs in [from, to] in file order (newest first unless asc),
+// starting just past the start cursor when one is given, until fn returns false.
+func (s *FileStore) walk(ctx context.Context, from, to time.Time, asc bool, start *fileCursor, fn func(*RequestLog, fileCursor) bool) error {
+	files, err := s.logFiles()
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-s.opts.Retention)
+	if from.Before(cutoff) {
+		from = cutoff
+	}
+
+	order := make([]int, len(files))
+	for i := range files {
+		if asc {
+			order[i] = i
+		} else {
+			order[i] = len(files) - 1 - i
+		}
+	}
+
+	// Each file covers [its start, next file's start)
+	for _, i := range order {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		stem := fileStem(files[i])
+		if start != nil {
+			if c := compareStems(stem, start.File); (!asc && c > 0) || (asc && c < 0) {
+				continue
+			}
+		}
+
+		if startTime, ok := fileStartTime(files[i]); ok && !to.IsZero() && startTime.After(to) {
+			if asc {
+				break
+			}
+			continue
+		}
+		if i+1 < len(files) {
+			if next, ok := fileStartTime(files[i+1]); ok && next.Before(from) {
+				if asc {
+					continue
+				}
+				break
+			}
+		}
+
+		stopped := false
+		visit := func(line int, entry *RequestLog) bool {
+			if start != nil && stem == start.File {
+				if (!asc && line >= start.Line) || (asc && line <= start.Line) {
+					return true
+				}
+			}
+			if entry.Timestamp.Before(from) || (!to.IsZero() && entry.Timestamp.After(to)) {
+				return true
+			}
+			if !fn(entry, fileCursor{File: stem, Line: line}) {
+				stopped = true
+				return false
+			}
+			return true
+		}
+
+		read := readLogLines
+		if !asc {
+			read = readLogLinesReverse
+		}
+		if err := read(filepath.Join(s.opts.Dir, files[i]), visit); err != nil {
+			log.Printf("[FILE STORE] failed to read %s: %v", files[i], err)
+		}
+		if stopped {
+			return nil
+		}
+	}
+	return nil
+}
+
+// logFiles lists log files in the directory, oldest first
+func (s *FileStore) logFiles() ([]string, error) {
+	dirEntries, err := os.ReadDir(s.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, e := range dirEntries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, fileLogPrefix) {
+			continue
+		}
+		if !strings.HasSuffix(name, ".jsonl") && !strings.HasSuffix(name, ".jsonl.gz") {
+			continue
+		}
+		// Skip a plain file whose compressed twin already exists (compression in progress)
+		if strings.HasSuffix(name, ".jsonl") {
+			if _, err := os.Stat(filepath.Join(s.opts.Dir, name+".gz")); err == nil {
+				continue
+			}
+		}
+		files = append(files, name)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return compareStems(fileStem(files[i]), fileStem(files[j])) < 0
+	})
+	return files, nil
+}
+
+// compareStems orders files by start time, then "-1", "-2"... sequels by number
+// (so "-10" follows "-9"). The timestamp layout sorts lexically.
+func compareStems(a, b string) int {
+	aStamp, aSeq := splitStem(a)
+	bStamp, bSeq := splitStem(b)
+	if c := strings.Compare(aStamp, bStamp); c != 0 {
+		return c
+	}
+	return cmp.Compare(aSeq, bSeq)
+}
+
+// splitStem returns a stem's start timestamp and the sequence number used when
+// several files open within the same second (0 for the first)
+func splitStem(stem string) (string, int) {
+	stamp, seq, found := strings.Cut(strings.TrimPrefix(stem, fileLogPrefix), "-")
+	if !found {
+		return stamp, 0
+	}
+	n, _ := strconv.Atoi(seq)
+	return stamp, n
+}
+
+// fileStem strips the extensions, so a file keeps its identity once compressed
+func fileStem(name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".jsonl")
+}
+
+func fileStartTime(name string) (time.Time, bool) {
+	stamp, _ := splitStem(fileStem(name))
+	t, err := time.ParseInLocation(fileLogTimeLayout, stamp, time.UTC)
+	return t, err == nil
+}
+
+// logBlockSize bounds the lines held in memory while a file is read newest first
+var logBlockSize int64 = 4 << 20
+
+// openLogFile opens a log file positioned offset bytes into its (decompressed) lines
+func openLogFile(path string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := &gzipFile{Reader: gz, f: f}
+	if _, err := io.CopyN(io.Discard, gz, offset); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// gzipFile closes a compressed log file with its decompressor
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	return scanner
+}
+
+// parseLogLine decodes one line; the active file may end with a partially written one
+func parseLogLine(data []byte) (*RequestLog, bool) {
+	var entry RequestLog
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// readLogLines streams a file's logs oldest first until fn returns false.
+// Lines are numbered as they appear in the file, so cursors stay valid while
+// the active file grows.
+func readLogLines(path string, fn func(line int, entry *RequestLog) bool) error {
+	r, err := openLogFile(path, 0)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	scanner := newLineScanner(r)
+	for line := 0; scanner.Scan(); line++ {
+		entry, ok := parseLogLine(scanner.Bytes())
+		if !ok {
+			continue
+		}
+		if !fn(line, entry) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// readLogLinesReverse streams a file's logs newest first until fn returns
+// false. A first pass splits the file into blocks of about logBlockSize bytes;
+// each block is then read and visited backwards, so memory stays bounded by
+// the block size rather than the file size.
+func readLogLinesReverse(path string, fn func(line int, entry *RequestLog) bool) error {
+	type block struct {
+		line   int
+		offset int64
+	}
+
+	r, err := openLogFile(path, 0)
+	if err != nil {
+		return err
+	}
+	var blocks []block
+	var offset, size int64
+	lines := 0
+	scanner := newLineScanner(r)
+	for ; scanner.Scan(); lines++ {
+		if len(blocks) == 0 || size >= logBlockSize {
+			blocks = append(blocks, block{line: lines, offset: offset})
+			size = 0
+		}
+		n := int64(len(scanner.Bytes())) + 1 // Lines end in \n
+		offset += n
+		size += n
+	}
+	err = scanner.Err()
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	for b := len(blocks) - 1; b >= 0; b-- {
+		end := lines
+		if b+1 < len(blocks) {
+			end = blocks[b+1].line
+		}
+
+		r, err := openLogFile(path, blocks[b].offset)
+		if err != nil {
+			return err
+		}
+		raw := make([][]byte, 0, end-blocks[b].line)
+		scanner := newLineScanner(r)
+		for len(raw) < cap(raw) && scanner.Scan() {
+			raw = append(raw, bytes.Clone(scanner.Bytes()))
+		}
+		err = scanner.Err()
+		r.Close()
+		if err != nil {
+			return err
+		}
+
+		for k := len(raw) - 1; k >= 0; k-- {
+			entry, ok := parseLogLine(raw[k])
+			if !ok {
+				continue
+			}
+			if !fn(blocks[b].line+k, entry) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) openLocked(now time.Time) error {
+	base := fileLogPrefix + now.UTC().Format(fileLogTimeLayout)
+	name := base + ".jsonl"
+	for seq := 1; ; seq++ {
+		_, errPlain := os.Stat(filepath.Join(s.opts.Dir, name))
+		_, errGz := os.Stat(filepath.Join(s.opts.Dir, name+".gz"))
+		if os.IsNotExist(errPlain) && os.IsNotExist(errGz) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d.jsonl", base, seq)
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.opts.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	s.file = f
+	s.size = 0
+	s.openedAt = now
+	return nil
+}
+
+func (s *FileStore) rotateLocked(now time.Time) error {
+	if s.file != nil {
+		closed := s.file.Name()
+		if err := s.file.Close(); err != nil {
+			log.Printf("[FILE STORE] failed to close %s: %v", closed, err)
+		}
+		s.file = nil
+		if s.opts.Compress {
+			go s.compressFile(filepath.Base(closed))
+		}
+	}
+
+	go s.removeExpired()
+	return s.openLocked(now)
+}
+
+// compressFile gzips a closed log file and removes the original
+func (s *FileStore) compressFile(name string) {
+	src := filepath.Join(s.opts.Dir, name)
+	dst := src + ".gz"
+	tmp := dst + ".tmp"
+
+	in, err := os.Open(src)
+	if err != nil {
+		log.Printf("[FILE STORE] failed to open %s for compression: %v", name, err)
+		return
+	}
+	defer in.Close()
+
+	out, err := os.Create(tmp)
+	if err != nil {
+		log.Printf("[FILE STORE] failed to create %s: %v", tmp, err)
+		return
+	}
+
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		log.Printf("[FILE STORE] failed to compress %s: %v", name, err)
+		return
+	}
+
+	os.Remove(src)
+}
+
+// removeExpired deletes rotated files that ended before the retention window
+func (s *FileStore) removeExpired() {
+	files, err := s.logFiles()
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-s.opts.Retention)
+	// A file is expired once the next file (its end) started before the cutoff
+	for i := 0; i+1 < len(files); i++ {
+		next, ok := fileStartTime(files[i+1])
+		if !ok || !next.Before(cutoff) {
+			break
+		}
+		if err := os.Remove(filepath.Join(s.opts.Dir, files[i])); err != nil {
+			log.Printf("[FILE STORE] failed to remove %s: %v", files[i], err)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFileStore(t *testing.T, opts FileStoreOptions) *FileStore {
+	t.Helper()
+	if opts.Dir == "" {
+		opts.Dir = t.TempDir()
+	}
+	store, err := NewFileStore(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// saveTestLogs saves logs log-00, log-01... one millisecond apart
+func saveTestLogs(t *testing.T, store *FileStore, n int) []string {
+	t.Helper()
+	start := time.Now().Add(-time.Minute)
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("log-%02d", i)
+		entry := &RequestLog{ID: ids[i], Timestamp: start.Add(time.Duration(i) * time.Millisecond), Model: "gpt-4o", StatusCode: 200}
+		if err := store.SaveRequestLog(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ids
+}
+
+// waitForCompression waits until every file but the active one is gzipped
+func waitForCompression(t *testing.T, store *FileStore) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		files, err := store.logFiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain := 0
+		for _, f := range files {
+			if !strings.HasSuffix(f, ".gz") {
+				plain++
+			}
+		}
+		if plain == 1 {
+			return files
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d files left uncompressed: %v", plain, files)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func reversed(ids []string) []string {
+	out := make([]string, len(ids))
+	for i, id := range ids {
+		out[len(ids)-1-i] = id
+	}
+	return out
+}
+
+func logIDs(logs []*RequestLog) []string {
+	ids := make([]string, len(logs))
+	for i, l := range logs {
+		ids[i] = l.ID
+	}
+	return ids
+}
+
+func TestFileStoreRotatesAndReadsCompressedFiles(t *testing.T) {
+	// Each file holds one log, so more than ten rotate within the same second
+	store := newTestFileStore(t, FileStoreOptions{MaxSize: 200, Compress: true})
+	ids := saveTestLogs(t, store, 25)
+
+	files := waitForCompression(t, store)
+	if len(files) != 25 {
+		t.Errorf("%d files, want 25: %v", len(files), files)
+	}
+	if active := filepath.Base(store.file.Name()); files[len(files)-1] != active {
+		t.Errorf("newest file %s, want the active %s", files[len(files)-1], active)
+	}
+
+	logs, err := store.ListRequestLogs(context.Background(), LogFilters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(logIDs(logs), ","), strings.Join(reversed(ids), ","); got != want {
+		t.Errorf("logs newest first = %s\nwant %s", got, want)
+	}
+
+	entry, err := store.GetRequestLog(context.Background(), "log-03")
+	if err != nil || entry.ID != "log-03" {
+		t.Errorf("get from a compressed file: %+v, %v", entry, err)
+	}
+}
+
+func TestFileStoreCursorsPageThroughEveryFile(t *testing.T) {
+	// Small blocks make newest-first reads cross block boundaries within a file
+	defer func(size int64) { logBlockSize = size }(logBlockSize)
+	logBlockSize = 150
+
+	store := newTestFileStore(t, FileStoreOptions{MaxSize: 600, Compress: true})
+	ids := saveTestLogs(t, store, 20)
+	waitForCompression(t, store)
+
+	for _, order := range []string{"desc", "asc"} {
+		t.Run(order, func(t *testing.T) {
+			want := reversed(ids)
+			if order == "asc" {
+				want = ids
+			}
+
+			var got []string
+			filters := LogFilters{Limit: 3, Order: order}
+			for pages := 0; pages < 10; pages++ {
+				page, err := store.QueryRequestLogs(context.Background(), filters)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, logIDs(page.Logs)...)
+				if page.NextCursor == "" {
+					break
+				}
+				filters.Cursor = page.NextCursor
+			}
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("paged logs = %v\nwant %v", got, want)
+			}
+		})
+	}
+
+	// A cursor into the active file stays valid as it grows
+	page, err := store.QueryRequestLogs(context.Background(), LogFilters{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveRequestLog(context.Background(), &RequestLog{ID: "late", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	next, err := store.QueryRequestLogs(context.Background(), LogFilters{Limit: 1, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(next.Logs) != 1 || next.Logs[0].ID != ids[len(ids)-2] {
+		t.Errorf("page after %s = %v, want [%s]", page.Logs[0].ID, logIDs(next.Logs), ids[len(ids)-2])
+	}
+}
+
+func TestFileStoreRemovesExpiredFiles(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	old := func(age time.Duration, id string) string {
+		name := fileLogPrefix + now.Add(-age).Format(fileLogTimeLayout) + ".jsonl"
+		line := fmt.Sprintf(`{"id":%q,"timestamp":%q}`+"\n", id, now.Add(-age).Format(time.RFC3339Nano))
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(line), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	// The first file ended when the second began, before the retention window;
+	// the second runs into it, so it stays until the store's own files age out
+	expired := old(40*24*time.Hour, "expired")
+	kept := old(35*24*time.Hour, "too-old")
+
+	store := newTestFileStore(t, FileStoreOptions{Dir: dir, MaxSize: 200, Retention: 30 * 24 * time.Hour})
+	saveTestLogs(t, store, 3) // Rotates, which removes expired files
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, expired)); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s was not removed", expired)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(filepath.Join(dir, kept)); err != nil {
+		t.Errorf("%s: %v", kept, err)
+	}
+
+	// Logs older than the retention are not returned even while their file is kept
+	logs, err := store.ListRequestLogs(context.Background(), LogFilters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(logIDs(logs), ","); got != "log-02,log-01,log-00" {
+		t.Errorf("logs = %s, want the retained ones", got)
+	}
+}
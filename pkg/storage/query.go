@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	maxQueryLimit = 1000
+	// Upper bound on index entries examined per query, so selective filters can't scan forever.
+	// When reached, the page is returned early with a cursor to continue.
+	maxQueryScan = 20000
+)
+
+func queryLimit(filters LogFilters) int {
+	switch {
+	case filters.Limit <= 0:
+		return 100
+	case filters.Limit > maxQueryLimit:
+		return maxQueryLimit
+	default:
+		return filters.Limit
+	}
+}
+
+func ascending(filters LogFilters) bool {
+	return strings.EqualFold(filters.Order, "asc")
+}
+
+// matchesFilters applies every non-index filter to a log entry
+func matchesFilters(entry *RequestLog, filters LogFilters) bool {
+	if filters.UserID != "" && entry.UserID != filters.UserID {
+		return false
+	}
+	if filters.APIKey != "" && entry.APIKey != filters.APIKey {
+		return false
+	}
+	if filters.Model != "" && entry.Model != filters.Model {
+		return false
+	}
+	if filters.StatusCode != 0 && entry.StatusCode != filters.StatusCode {
+		return false
+	}
+	if filters.StatusMin != 0 && entry.StatusCode < filters.StatusMin {
+		return false
+	}
+	if filters.StatusMax != 0 && entry.StatusCode > filters.StatusMax {
+		return false
+	}
+	if filters.Path != "" && !strings.HasPrefix(entry.Path, filters.Path) {
+		return false
+	}
+	if filters.CacheHit != nil && entry.CacheHit != *filters.CacheHit {
+		return false
+	}
+	if filters.MinDuration > 0 && entry.Duration < filters.MinDuration {
+		return false
+	}
+	if filters.MinCost > 0 && entry.CostUSD < filters.MinCost {
+		return false
+	}
+	if filters.Search != "" && !matchesSearch(entry, filters.Search) {
+		return false
+	}
+	return true
+}
+
+// matchesSearch looks for the term in the stored prompt and response
+func matchesSearch(entry *RequestLog, term string) bool {
+	term = strings.ToLower(term)
+
+	texts := []string{entry.RequestBodyRaw, entry.ResponseBodyRaw, entry.Error}
+	if entry.RequestBody != nil {
+		if b, err := json.Marshal(entry.RequestBody); err == nil {
+			texts = append(texts, string(b))
+		}
+	}
+	if entry.ResponseBody != nil {
+		if b, err := json.Marshal(entry.ResponseBody); err == nil {
+			texts = append(texts, string(b))
+		}
+	}
+
+	for _, text := range texts {
+		if text != "" && strings.Contains(strings.ToLower(text), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeCursor and decodeCursor keep store-specific positions opaque to clients
+func encodeCursor(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(cursor string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return fmt.Errorf("invalid cursor")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("invalid cursor")
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/ngoyal88/relay/pkg/cache"
@@ -14,6 +15,8 @@ import (
 type RedisStore struct {
 	rdb *cache.Client
 	ttl time.Duration // How long to keep logs (e.g., 30 days)
+
+	compressor *cache.Compressor // nil stores logs as plain JSON
 }
 
 // NewRedisStore creates a new Redis-backed storage
@@ -27,6 +30,25 @@ func NewRedisStore(rdb *cache.Client, logRetention time.Duration) *RedisStore {
 	}
 }
 
+// EnableCompression compresses log entries written from now on. Entries
+// stored before, or with compression off, remain readable.
+func (s *RedisStore) EnableCompression(c *cache.Compressor) {
+	s.compressor = c
+}
+
+// decodeLog reads a stored log entry, compressed or not
+func decodeLog(data []byte) (*RequestLog, error) {
+	data, err := cache.Decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	var log RequestLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		return nil, err
+	}
+	return &log, nil
+}
+
 // SaveRequestLog stores a request log in Redis
 func (s *RedisStore) SaveRequestLog(ctx context.Context, log *RequestLog) error {
 	// Store full log by ID
@@ -36,7 +58,7 @@ func (s *RedisStore) SaveRequestLog(ctx context.Context, log *RequestLog) error
 		return err
 	}
 
-	if err := s.rdb.Set(ctx, key, data, s.ttl); err != nil {
+	if err := s.rdb.Set(ctx, key, s.compressor.Compress(data), s.ttl); err != nil {
 		return err
 	}
 
@@ -64,6 +86,17 @@ func (s *RedisStore) SaveRequestLog(ctx context.Context, log *RequestLog) error
 		s.rdb.Redis().Expire(ctx, userTimeline, s.ttl)
 	}
 
+	// Per-key index
+	if log.APIKey != "" {
+		keyIndex := fmt.Sprintf("logs:key:%s", log.APIKey)
+		s.rdb.Redis().ZAdd(ctx, keyIndex, redis.Z{
+			Score:  timestamp,
+			Member: log.ID,
+		})
+		s.rdb.Redis().ZRemRangeByScore(ctx, keyIndex, "-inf", cutoff)
+		s.rdb.Redis().Expire(ctx, keyIndex, s.ttl)
+	}
+
 	// Per-model index
 	if log.Model != "" {
 		modelIndex := fmt.Sprintf("logs:model:%s", log.Model)
@@ -75,7 +108,8 @@ func (s *RedisStore) SaveRequestLog(ctx context.Context, log *RequestLog) error
 		s.rdb.Redis().Expire(ctx, modelIndex, s.ttl)
 	}
 
-	return nil
+	// Pre-aggregated counters for analytics
+	return s.recordRollups(ctx, log)
 }
 
 // GetRequestLog retrieves a single log by ID
@@ -86,25 +120,12 @@ func (s *RedisStore) GetRequestLog(ctx context.Context, id string) (*RequestLog,
 		return nil, err
 	}
 
-	var log RequestLog
-	if err := json.Unmarshal(data, &log); err != nil {
-		return nil, err
-	}
-
-	return &log, nil
+	return decodeLog(data)
 }
 
 // ListRequestLogs queries logs with filters
 func (s *RedisStore) ListRequestLogs(ctx context.Context, filters LogFilters) ([]*RequestLog, error) {
-	// Determine which index to use
-	var indexKey string
-	if filters.UserID != "" {
-		indexKey = fmt.Sprintf("logs:user:%s", filters.UserID)
-	} else if filters.Model != "" {
-		indexKey = fmt.Sprintf("logs:model:%s", filters.Model)
-	} else {
-		indexKey = "logs:timeline"
-	}
+	indexKey := logIndexKey(filters)
 
 	// Query by time range
 	minScore := float64(filters.From.Unix())
@@ -136,7 +157,7 @@ func (s *RedisStore) ListRequestLogs(ctx context.Context, filters LogFilters) ([
 		log, err := s.GetRequestLog(ctx, id)
 		if err == nil {
 			// Apply additional filters
-			if filters.StatusCode != 0 && log.StatusCode != filters.StatusCode {
+			if !matchesFilters(log, filters) {
 				continue
 			}
 			logs = append(logs, log)
@@ -146,74 +167,149 @@ func (s *RedisStore) ListRequestLogs(ctx context.Context, filters LogFilters) ([
 	return logs, nil
 }
 
-// GetUsageStats calculates usage statistics
-func (s *RedisStore) GetUsageStats(ctx context.Context, userID string, from, to time.Time) (*UsageStats, error) {
-	logs, err := s.ListRequestLogs(ctx, LogFilters{
-		UserID: userID,
-		From:   from,
-		To:     to,
-		Limit:  10000, // Get all logs in range
-	})
-	if err != nil {
-		return nil, err
+// logIndexKey picks the most selective sorted-set index for the filters
+func logIndexKey(filters LogFilters) string {
+	switch {
+	case filters.UserID != "":
+		return fmt.Sprintf("logs:user:%s", filters.UserID)
+	case filters.APIKey != "":
+		return fmt.Sprintf("logs:key:%s", filters.APIKey)
+	case filters.Model != "":
+		return fmt.Sprintf("logs:model:%s", filters.Model)
+	default:
+		return "logs:timeline"
 	}
+}
+
+// redisCursor is a position in a sorted-set index: the last score returned
+// and how many members with that score have been consumed so far.
+type redisCursor struct {
+	Score float64 `json:"s"`
+	Seen  int64   `json:"n"`
+}
+
+// QueryRequestLogs walks the index in score order, filtering in batches,
+// and returns a cursor that resumes right after the last examined entry
+func (s *RedisStore) QueryRequestLogs(ctx context.Context, filters LogFilters) (*LogPage, error) {
+	const batchSize = 200
 
-	stats := &UsageStats{
-		ByModel:      make(map[string]int64),
-		ByStatusCode: make(map[int]int64),
+	indexKey := logIndexKey(filters)
+	limit := queryLimit(filters)
+	asc := ascending(filters)
+
+	minScore, maxScore := "-inf", "+inf"
+	if !filters.From.IsZero() {
+		minScore = formatScore(float64(filters.From.Unix()))
+	}
+	if !filters.To.IsZero() {
+		maxScore = formatScore(float64(filters.To.Unix()))
 	}
 
-	var totalDuration time.Duration
-	for _, log := range logs {
-		stats.TotalRequests++
+	var pos redisCursor
+	hasPos := false
+	if filters.Cursor != "" {
+		if err := decodeCursor(filters.Cursor, &pos); err != nil {
+			return nil, err
+		}
+		hasPos = true
+	}
+
+	page := &LogPage{Logs: make([]*RequestLog, 0, limit)}
+	scanned := 0
 
-		if log.CacheHit {
-			stats.CacheHits++
+	for scanned < maxQueryScan {
+		rng := &redis.ZRangeBy{Min: minScore, Max: maxScore, Count: batchSize}
+		if hasPos {
+			if asc {
+				rng.Min = formatScore(pos.Score)
+			} else {
+				rng.Max = formatScore(pos.Score)
+			}
+			rng.Offset = pos.Seen
+		}
+
+		var members []redis.Z
+		var err error
+		if asc {
+			members, err = s.rdb.Redis().ZRangeByScoreWithScores(ctx, indexKey, rng).Result()
 		} else {
-			stats.CacheMisses++
+			members, err = s.rdb.Redis().ZRevRangeByScoreWithScores(ctx, indexKey, rng).Result()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(members) == 0 {
+			return page, nil
 		}
 
-		if log.Model != "" {
-			stats.ByModel[log.Model]++
+		keys := make([]string, len(members))
+		for i, m := range members {
+			keys[i] = fmt.Sprintf("log:%v", m.Member)
+		}
+		values, err := s.rdb.Redis().MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, err
 		}
 
-		stats.ByStatusCode[log.StatusCode]++
-		totalDuration += log.Duration
-	}
+		for i, m := range members {
+			scanned++
+			if hasPos && m.Score == pos.Score {
+				pos.Seen++
+			} else {
+				pos = redisCursor{Score: m.Score, Seen: 1}
+				hasPos = true
+			}
 
-	if stats.TotalRequests > 0 {
-		stats.AvgDuration = totalDuration / time.Duration(stats.TotalRequests)
+			// Index entries can outlive their (expired) log
+			raw, ok := values[i].(string)
+			if !ok {
+				continue
+			}
+			entry, err := decodeLog([]byte(raw))
+			if err != nil {
+				continue
+			}
+			if !matchesFilters(entry, filters) {
+				continue
+			}
+
+			page.Logs = append(page.Logs, entry)
+			if len(page.Logs) == limit {
+				page.NextCursor = encodeCursor(pos)
+				return page, nil
+			}
+		}
+
+		if len(members) < batchSize {
+			return page, nil
+		}
 	}
 
-	return stats, nil
+	// Scan budget spent: hand back what we have and where to resume
+	page.NextCursor = encodeCursor(pos)
+	return page, nil
 }
 
-// GetCostStats calculates cost statistics
-func (s *RedisStore) GetCostStats(ctx context.Context, userID string, from, to time.Time) (*CostStats, error) {
-	logs, err := s.ListRequestLogs(ctx, LogFilters{
-		UserID: userID,
-		From:   from,
-		To:     to,
-		Limit:  10000,
-	})
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// GetUsageStats sums the pre-aggregated rollups for the requested series
+func (s *RedisStore) GetUsageStats(ctx context.Context, query StatsQuery) (*UsageStats, error) {
+	totals, err := s.readRollups(ctx, query)
 	if err != nil {
 		return nil, err
 	}
+	return totals.usageStats(), nil
+}
 
-	stats := &CostStats{
-		ByModel: make(map[string]float64),
-	}
-
-	for _, log := range logs {
-		stats.TotalCost += log.CostUSD
-		stats.TotalTokens += int64(log.TokensUsed)
-
-		if log.Model != "" {
-			stats.ByModel[log.Model] += log.CostUSD
-		}
+// GetCostStats sums the pre-aggregated cost rollups for the requested series
+func (s *RedisStore) GetCostStats(ctx context.Context, query StatsQuery) (*CostStats, error) {
+	totals, err := s.readRollups(ctx, query)
+	if err != nil {
+		return nil, err
 	}
-
-	return stats, nil
+	return totals.costStats(), nil
 }
 
 // Ping checks Redis connection
@@ -0,0 +1,339 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// rollupGranularity describes one bucket size of the pre-aggregated counters.
+type rollupGranularity struct {
+	name string
+	size time.Duration
+	ttl  time.Duration
+}
+
+// Ordered from finest to coarsest; queries rely on this order.
+var rollupGranularities = []rollupGranularity{
+	{name: "m", size: time.Minute, ttl: 48 * time.Hour},
+	{name: "h", size: time.Hour, ttl: 90 * 24 * time.Hour},
+	{name: "d", size: 24 * time.Hour, ttl: 400 * 24 * time.Hour},
+}
+
+// latencyBucketsMs are the upper bounds of the latency histogram in milliseconds.
+var latencyBucketsMs = []int64{50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+
+// rollupDimension is one series a request is counted under.
+type rollupDimension struct {
+	name  string
+	value string
+}
+
+// rollupKey builds the Redis hash key for a series bucket, e.g. stats:h:user:alice:1700000000
+func rollupKey(g rollupGranularity, dim rollupDimension, bucket time.Time) string {
+	if dim.name == "all" {
+		return fmt.Sprintf("stats:%s:all:%d", g.name, bucket.Unix())
+	}
+	return fmt.Sprintf("stats:%s:%s:%s:%d", g.name, dim.name, dim.value, bucket.Unix())
+}
+
+func logDimensions(log *RequestLog) []rollupDimension {
+	dims := []rollupDimension{{name: "all"}}
+	if log.UserID != "" {
+		dims = append(dims, rollupDimension{name: "user", value: log.UserID})
+	}
+	if log.APIKey != "" {
+		dims = append(dims, rollupDimension{name: "key", value: log.APIKey})
+	}
+	if log.Model != "" {
+		dims = append(dims, rollupDimension{name: "model", value: log.Model})
+	}
+	if log.TeamID != "" {
+		dims = append(dims, rollupDimension{name: "team", value: log.TeamID})
+	}
+	return dims
+}
+
+func queryDimension(q StatsQuery) rollupDimension {
+	switch {
+	case q.UserID != "":
+		return rollupDimension{name: "user", value: q.UserID}
+	case q.APIKey != "":
+		return rollupDimension{name: "key", value: q.APIKey}
+	case q.Model != "":
+		return rollupDimension{name: "model", value: q.Model}
+	case q.TeamID != "":
+		return rollupDimension{name: "team", value: q.TeamID}
+	default:
+		return rollupDimension{name: "all"}
+	}
+}
+
+func latencyField(d time.Duration) string {
+	ms := d.Milliseconds()
+	for _, bound := range latencyBucketsMs {
+		if ms <= bound {
+			return fmt.Sprintf("lat_le:%d", bound)
+		}
+	}
+	return "lat_le:+Inf"
+}
+
+// recordRollups increments the minute/hour/day counters for every dimension of the log.
+func (s *RedisStore) recordRollups(ctx context.Context, log *RequestLog) error {
+	isError := log.StatusCode >= 400 || log.Error != ""
+	latency := latencyField(log.Duration)
+
+	_, err := s.rdb.Redis().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, g := range rollupGranularities {
+			bucket := log.Timestamp.UTC().Truncate(g.size)
+			for _, dim := range logDimensions(log) {
+				key := rollupKey(g, dim, bucket)
+
+				pipe.HIncrBy(ctx, key, "requests", 1)
+				pipe.HIncrBy(ctx, key, "tokens_in", int64(log.TokensIn))
+				pipe.HIncrBy(ctx, key, "tokens_out", int64(log.TokensOut))
+				pipe.HIncrBy(ctx, key, "tokens", int64(log.TokensUsed))
+				pipe.HIncrBy(ctx, key, "duration_ms", log.Duration.Milliseconds())
+				pipe.HIncrBy(ctx, key, latency, 1)
+				pipe.HIncrBy(ctx, key, fmt.Sprintf("status:%d", log.StatusCode), 1)
+				if log.CostUSD != 0 {
+					pipe.HIncrByFloat(ctx, key, "cost", log.CostUSD)
+				}
+				if log.CacheHit {
+					pipe.HIncrBy(ctx, key, "cache_hits", 1)
+				}
+				if log.CostSaved != 0 {
+					pipe.HIncrByFloat(ctx, key, "saved", log.CostSaved)
+				}
+				if isError {
+					pipe.HIncrBy(ctx, key, "errors", 1)
+				}
+				if log.Model != "" {
+					pipe.HIncrBy(ctx, key, "model_requests:"+log.Model, 1)
+					if log.CostUSD != 0 {
+						pipe.HIncrByFloat(ctx, key, "model_cost:"+log.Model, log.CostUSD)
+					}
+					if log.CostSaved != 0 {
+						pipe.HIncrByFloat(ctx, key, "model_saved:"+log.Model, log.CostSaved)
+					}
+				}
+				pipe.Expire(ctx, key, g.ttl)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// rollupBucketKeys returns the smallest set of bucket keys covering [from, to).
+// Whole days and hours use the coarse buckets; the ragged edges use minutes.
+// When a fine bucket has already expired, the enclosing coarser bucket is used instead.
+func rollupBucketKeys(dim rollupDimension, from, to, now time.Time) []string {
+	coarsest := rollupGranularities[len(rollupGranularities)-1]
+	if oldest := now.Add(-coarsest.ttl); from.Before(oldest) {
+		from = oldest
+	}
+	if to.IsZero() || to.After(now) {
+		to = now
+	}
+
+	var keys []string
+	cursor := from.UTC().Truncate(time.Minute)
+	for cursor.Before(to) {
+		chosen := -1
+
+		// Prefer the coarsest bucket that is aligned, fully inside the range
+		// and still retained.
+		for i := len(rollupGranularities) - 1; i > 0; i-- {
+			g := rollupGranularities[i]
+			if cursor.Equal(cursor.Truncate(g.size)) && !cursor.Add(g.size).After(to) && now.Sub(cursor) <= g.ttl {
+				chosen = i
+				break
+			}
+		}
+
+		if chosen == -1 {
+			// Ragged edge: use the finest granularity still retained.
+			chosen = 0
+			for chosen < len(rollupGranularities)-1 && now.Sub(cursor) > rollupGranularities[chosen].ttl {
+				chosen++
+			}
+		}
+
+		g := rollupGranularities[chosen]
+		bucket := cursor.Truncate(g.size)
+		keys = append(keys, rollupKey(g, dim, bucket))
+		cursor = bucket.Add(g.size)
+	}
+	return keys
+}
+
+// rollupTotals is the sum of all bucket hashes in a query range.
+type rollupTotals struct {
+	requests   int64
+	cacheHits  int64
+	errors     int64
+	tokens     int64
+	tokensIn   int64
+	tokensOut  int64
+	durationMs int64
+	cost       float64
+	saved      float64
+	statuses   map[int]int64
+	latency    map[string]int64
+	modelReqs  map[string]int64
+	modelCosts map[string]float64
+	modelSaved map[string]float64
+}
+
+func (s *RedisStore) readRollups(ctx context.Context, q StatsQuery) (*rollupTotals, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	keys := rollupBucketKeys(queryDimension(q), q.From, q.To, time.Now().UTC())
+
+	cmds := make([]*redis.MapStringStringCmd, 0, len(keys))
+	_, err := s.rdb.Redis().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			cmds = append(cmds, pipe.HGetAll(ctx, key))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	totals := newRollupTotals()
+	for _, cmd := range cmds {
+		fields, err := cmd.Result()
+		if err != nil {
+			continue
+		}
+		for field, raw := range fields {
+			totals.add(field, raw)
+		}
+	}
+
+	return totals, nil
+}
+
+func newRollupTotals() *rollupTotals {
+	return &rollupTotals{
+		statuses:   make(map[int]int64),
+		latency:    make(map[string]int64),
+		modelReqs:  make(map[string]int64),
+		modelCosts: make(map[string]float64),
+		modelSaved: make(map[string]float64),
+	}
+}
+
+// addLog counts a single log entry; used by stores that aggregate on read.
+func (t *rollupTotals) addLog(log *RequestLog) {
+	t.requests++
+	t.tokens += int64(log.TokensUsed)
+	t.tokensIn += int64(log.TokensIn)
+	t.tokensOut += int64(log.TokensOut)
+	t.durationMs += log.Duration.Milliseconds()
+	t.cost += log.CostUSD
+	t.saved += log.CostSaved
+	t.statuses[log.StatusCode]++
+	t.latency[strings.TrimPrefix(latencyField(log.Duration), "lat_le:")]++
+	if log.CacheHit {
+		t.cacheHits++
+	}
+	if log.StatusCode >= 400 || log.Error != "" {
+		t.errors++
+	}
+	if log.Model != "" {
+		t.modelReqs[log.Model]++
+		t.modelCosts[log.Model] += log.CostUSD
+		if log.CostSaved != 0 {
+			t.modelSaved[log.Model] += log.CostSaved
+		}
+	}
+}
+
+func (t *rollupTotals) usageStats() *UsageStats {
+	stats := &UsageStats{
+		TotalRequests:    t.requests,
+		CacheHits:        t.cacheHits,
+		CacheMisses:      t.requests - t.cacheHits,
+		Errors:           t.errors,
+		TokensIn:         t.tokensIn,
+		TokensOut:        t.tokensOut,
+		ByModel:          t.modelReqs,
+		ByStatusCode:     t.statuses,
+		LatencyHistogram: t.latency,
+	}
+	if t.requests > 0 {
+		stats.AvgDuration = time.Duration(t.durationMs/t.requests) * time.Millisecond
+	}
+	return stats
+}
+
+func (t *rollupTotals) costStats() *CostStats {
+	return &CostStats{
+		TotalCost:      t.cost,
+		TotalTokens:    t.tokens,
+		ByModel:        t.modelCosts,
+		Savings:        t.saved,
+		SavingsByModel: t.modelSaved,
+	}
+}
+
+func (t *rollupTotals) add(field, raw string) {
+	if name, ok := strings.CutPrefix(field, "model_cost:"); ok {
+		v, _ := strconv.ParseFloat(raw, 64)
+		t.modelCosts[name] += v
+		return
+	}
+	if name, ok := strings.CutPrefix(field, "model_saved:"); ok {
+		v, _ := strconv.ParseFloat(raw, 64)
+		t.modelSaved[name] += v
+		return
+	}
+	if field == "cost" {
+		v, _ := strconv.ParseFloat(raw, 64)
+		t.cost += v
+		return
+	}
+	if field == "saved" {
+		v, _ := strconv.ParseFloat(raw, 64)
+		t.saved += v
+		return
+	}
+
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case field == "requests":
+		t.requests += n
+	case field == "cache_hits":
+		t.cacheHits += n
+	case field == "errors":
+		t.errors += n
+	case field == "tokens":
+		t.tokens += n
+	case field == "tokens_in":
+		t.tokensIn += n
+	case field == "tokens_out":
+		t.tokensOut += n
+	case field == "duration_ms":
+		t.durationMs += n
+	case strings.HasPrefix(field, "status:"):
+		code, _ := strconv.Atoi(strings.TrimPrefix(field, "status:"))
+		t.statuses[code] += n
+	case strings.HasPrefix(field, "lat_le:"):
+		t.latency[strings.TrimPrefix(field, "lat_le:")] += n
+	case strings.HasPrefix(field, "model_requests:"):
+		t.modelReqs[strings.TrimPrefix(field, "model_requests:")] += n
+	}
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ngoyal88/relay/pkg/cache"
+)
+
+func newTestRedisStore(t *testing.T) *RedisStore {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb, err := cache.NewRedis(mr.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("connect to miniredis: %v", err)
+	}
+	return NewRedisStore(rdb, time.Hour)
+}
+
+// Each filter is its own rollup series, so they can't be combined
+func TestStatsQueryRejectsCombinedFilters(t *testing.T) {
+	store := newTestRedisStore(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for _, log := range []*RequestLog{
+		{ID: "1", Timestamp: now, UserID: "alice", TeamID: "team-a", Model: "gpt-4o", StatusCode: 200},
+		{ID: "2", Timestamp: now, UserID: "bob", TeamID: "team-a", Model: "gpt-4o-mini", StatusCode: 200},
+	} {
+		if err := store.SaveRequestLog(ctx, log); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+
+	from := now.Add(-time.Hour)
+	if _, err := store.GetUsageStats(ctx, StatsQuery{Model: "gpt-4o", TeamID: "team-a", From: from}); err == nil {
+		t.Error("model and team_id together: expected an error")
+	}
+
+	for q, want := range map[StatsQuery]int64{
+		{From: from}:                   2,
+		{TeamID: "team-a", From: from}: 2,
+		{Model: "gpt-4o", From: from}:  1,
+		{UserID: "bob", From: from}:    1,
+	} {
+		stats, err := store.GetUsageStats(ctx, q)
+		if err != nil {
+			t.Fatalf("stats %+v: %v", q, err)
+		}
+		if stats.TotalRequests != want {
+			t.Errorf("stats %+v = %d requests, want %d", q, stats.TotalRequests, want)
+		}
+	}
+}
+
+func TestRollupBucketKeysRespectRetention(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	all := rollupDimension{name: "all"}
+	key := func(name string, bucket time.Time) string {
+		for _, g := range rollupGranularities {
+			if g.name == name {
+				return rollupKey(g, all, bucket)
+			}
+		}
+		t.Fatalf("no granularity %s", name)
+		return ""
+	}
+	minutes := func(from time.Time, n int) []string {
+		var keys []string
+		for i := 0; i < n; i++ {
+			keys = append(keys, key("m", from.Add(time.Duration(i)*time.Minute)))
+		}
+		return keys
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []string
+	}{
+		{
+			name: "minutes then hours within 48h",
+			from: now.Add(-3*time.Hour - 30*time.Minute),
+			to:   now,
+			want: append(minutes(now.Add(-3*time.Hour-30*time.Minute), 30),
+				key("h", now.Add(-3*time.Hour)), key("h", now.Add(-2*time.Hour)), key("h", now.Add(-time.Hour))),
+		},
+		{
+			name: "aligned hour past 48h",
+			from: now.Add(-50 * time.Hour),
+			to:   now.Add(-49 * time.Hour),
+			want: []string{key("h", now.Add(-50*time.Hour))},
+		},
+		{
+			name: "ragged edge past 48h uses the enclosing hour",
+			from: now.Add(-50*time.Hour - 30*time.Minute),
+			to:   now.Add(-49 * time.Hour),
+			want: []string{key("h", now.Add(-51*time.Hour)), key("h", now.Add(-50*time.Hour))},
+		},
+		{
+			name: "aligned hours within 90d",
+			from: now.Add(-89 * 24 * time.Hour),
+			to:   now.Add(-89*24*time.Hour + 2*time.Hour),
+			want: []string{key("h", now.Add(-89*24*time.Hour)), key("h", now.Add(-89*24*time.Hour+time.Hour))},
+		},
+		{
+			name: "aligned hour past 90d uses the enclosing day",
+			from: now.Add(-91 * 24 * time.Hour),
+			to:   now.Add(-91*24*time.Hour + 36*time.Hour),
+			want: []string{
+				key("d", time.Date(2025, 12, 9, 0, 0, 0, 0, time.UTC)),
+				key("d", time.Date(2025, 12, 10, 0, 0, 0, 0, time.UTC)),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rollupBucketKeys(all, tt.from, tt.to, now); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsSumEveryRetainedGranularity(t *testing.T) {
+	store := newTestRedisStore(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	// Only minute, hour and day buckets respectively still cover these
+	logs := []*RequestLog{
+		{ID: "1", Timestamp: now.Add(-30 * time.Minute), Model: "gpt-4o", StatusCode: 200, TokensUsed: 30, TokensIn: 10, TokensOut: 20, CostUSD: 0.25},
+		{ID: "2", Timestamp: now.Add(-72 * time.Hour), Model: "gpt-4o", StatusCode: 200, TokensUsed: 300, TokensIn: 100, TokensOut: 200, CostUSD: 1.5},
+		{ID: "3", Timestamp: now.Add(-100 * 24 * time.Hour), Model: "gpt-4o-mini", StatusCode: 200, TokensUsed: 3000, TokensIn: 1000, TokensOut: 2000, CostUSD: 0.125},
+	}
+	for _, log := range logs {
+		if err := store.SaveRequestLog(ctx, log); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		from      time.Time
+		requests  int64
+		tokensIn  int64
+		tokensOut int64
+		tokens    int64
+		cost      float64
+	}{
+		{"last 48h", now.Add(-48 * time.Hour), 1, 10, 20, 30, 0.25},
+		{"last 90d", now.Add(-90 * 24 * time.Hour), 2, 110, 220, 330, 1.75},
+		{"last 120d", now.Add(-120 * 24 * time.Hour), 3, 1110, 2220, 3330, 1.875},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := StatsQuery{From: tt.from}
+			usage, err := store.GetUsageStats(ctx, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if usage.TotalRequests != tt.requests || usage.TokensIn != tt.tokensIn || usage.TokensOut != tt.tokensOut {
+				t.Errorf("usage = %d requests, %d in, %d out; want %d, %d, %d",
+					usage.TotalRequests, usage.TokensIn, usage.TokensOut, tt.requests, tt.tokensIn, tt.tokensOut)
+			}
+			costs, err := store.GetCostStats(ctx, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if costs.TotalTokens != tt.tokens || math.Abs(costs.TotalCost-tt.cost) > 1e-9 {
+				t.Errorf("costs = %d tokens, $%v; want %d, $%v", costs.TotalTokens, costs.TotalCost, tt.tokens, tt.cost)
+			}
+		})
+	}
+}
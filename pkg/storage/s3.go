@@ -0,0 +1,363 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3SinkOptions configures the object storage exporter
+type S3SinkOptions struct {
+	Endpoint      string // e.g. https://s3.us-east-1.amazonaws.com or http://minio:9000
+	Bucket        string
+	Region        string
+	AccessKey     string
+	SecretKey     string
+	Prefix        string        // Object key prefix, e.g. "relay/logs"
+	BatchSize     int           // Upload once this many logs are buffered
+	MaxBatchBytes int           // ...or once the uncompressed batch reaches this size
+	FlushInterval time.Duration // ...or at least this often
+	SpoolDir      string        // Batches wait here until uploaded
+	MaxRetries    int
+}
+
+// S3Sink batches request logs into gzipped JSONL objects and uploads them to
+// an S3-compatible endpoint using path-style requests signed with SigV4.
+// Every batch is spooled to disk before upload, so nothing is lost while the
+// endpoint is down; spooled batches are retried on each flush.
+type S3Sink struct {
+	opts   S3SinkOptions
+	client *http.Client
+
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	count  int
+	closed bool
+
+	flushCh   chan struct{}
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewS3Sink validates options, creates the spool directory and starts the upload loop
+func NewS3Sink(opts S3SinkOptions) (*S3Sink, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, fmt.Errorf("s3 export requires endpoint and bucket")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.MaxBatchBytes <= 0 {
+		opts.MaxBatchBytes = 16 << 20 // 16MB
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Minute
+	}
+	if opts.SpoolDir == "" {
+		opts.SpoolDir = "./spool"
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 3
+	}
+	opts.Endpoint = strings.TrimRight(opts.Endpoint, "/")
+	opts.Prefix = strings.Trim(opts.Prefix, "/")
+
+	if err := os.MkdirAll(opts.SpoolDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool dir: %w", err)
+	}
+
+	s := &S3Sink{
+		opts:    opts,
+		client:  &http.Client{Timeout: 60 * time.Second},
+		flushCh: make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	go s.loop()
+
+	return s, nil
+}
+
+// SaveRequestLog buffers the log; the batch is uploaded in the background
+func (s *S3Sink) SaveRequestLog(ctx context.Context, entry *RequestLog) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		// Too late for a batch: spool it alone for the next run to upload
+		s.mu.Unlock()
+		return s.spool(append(data, '\n'))
+	}
+	s.buf.Write(data)
+	s.buf.WriteByte('\n')
+	s.count++
+	full := s.count >= s.opts.BatchSize || s.buf.Len() >= s.opts.MaxBatchBytes
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.flushCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Close uploads whatever is buffered and stops the background loop.
+// Batches that can't be uploaded stay spooled for the next run.
+func (s *S3Sink) Close() error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+
+		close(s.stopCh)
+		<-s.doneCh
+	})
+	return nil
+}
+
+func (s *S3Sink) loop() {
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+
+	// Pick up batches spooled by a previous run
+	s.uploadSpooled()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.flushCh:
+			s.flush()
+		case <-s.stopCh:
+			s.flush()
+			return
+		}
+	}
+}
+
+// flush spools the current batch and then uploads everything in the spool
+func (s *S3Sink) flush() {
+	s.mu.Lock()
+	data := append([]byte(nil), s.buf.Bytes()...)
+	s.buf.Reset()
+	s.count = 0
+	s.mu.Unlock()
+
+	if len(data) > 0 {
+		if err := s.spool(data); err != nil {
+			log.Printf("[S3 EXPORT] failed to spool batch, %d bytes dropped: %v", len(data), err)
+		}
+	}
+
+	s.uploadSpooled()
+}
+
+func (s *S3Sink) spool(data []byte) error {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(data); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	// The spool file name encodes the object key so restarts upload to the same place
+	now := time.Now().UTC()
+	key := fmt.Sprintf("%s/relay-%d.jsonl.gz", now.Format("2006/01/02/15"), now.UnixNano())
+	name := strings.ReplaceAll(key, "/", "_")
+
+	tmp := filepath.Join(s.opts.SpoolDir, name+".tmp")
+	if err := os.WriteFile(tmp, compressed.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.opts.SpoolDir, name))
+}
+
+func (s *S3Sink) uploadSpooled() {
+	entries, err := os.ReadDir(s.opts.SpoolDir)
+	if err != nil {
+		log.Printf("[S3 EXPORT] failed to read spool dir: %v", err)
+		return
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".jsonl.gz") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(s.opts.SpoolDir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		key := strings.ReplaceAll(name, "_", "/")
+		if s.opts.Prefix != "" {
+			key = s.opts.Prefix + "/" + key
+		}
+
+		if err := s.putWithRetry(key, data); err != nil {
+			// Keep it spooled; stop here and try again on the next flush
+			log.Printf("[S3 EXPORT] upload of %s failed, %d batch(es) spooled: %v", key, len(names), err)
+			return
+		}
+		os.Remove(path)
+	}
+}
+
+func (s *S3Sink) putWithRetry(key string, data []byte) error {
+	backoff := time.Second
+	var err error
+	for attempt := 0; attempt <= s.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-s.stopCh:
+				// Shutting down: leave the batch spooled
+				return err
+			}
+			backoff *= 2
+		}
+		if err = s.putObject(key, data); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *S3Sink) putObject(key string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	path := "/" + s.opts.Bucket + "/" + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.opts.Endpoint+awsURIEncode(path), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	signV4(req, data, s.opts.Region, s.opts.AccessKey, s.opts.SecretKey, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("put %s: %s: %s", key, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// signV4 adds AWS Signature Version 4 headers for the s3 service.
+// Anonymous requests are left unsigned when no access key is configured.
+func signV4(req *http.Request, payload []byte, region, accessKey, secretKey string, now time.Time) {
+	payloadHash := sha256Hex(payload)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	if accessKey == "" {
+		return
+	}
+
+	signedHeaders := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	var canonicalHeaders strings.Builder
+	for _, h := range signedHeaders {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, strings.Join(signedHeaders, ";"), signature,
+	))
+}
+
+// awsURIEncode escapes a path the way SigV4 expects: everything but unreserved characters and '/'
+func awsURIEncode(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"time"
 )
 
@@ -11,10 +12,11 @@ type Store interface {
 	SaveRequestLog(ctx context.Context, log *RequestLog) error
 	GetRequestLog(ctx context.Context, id string) (*RequestLog, error)
 	ListRequestLogs(ctx context.Context, filters LogFilters) ([]*RequestLog, error)
+	QueryRequestLogs(ctx context.Context, filters LogFilters) (*LogPage, error)
 
 	// Analytics
-	GetUsageStats(ctx context.Context, userID string, from, to time.Time) (*UsageStats, error)
-	GetCostStats(ctx context.Context, userID string, from, to time.Time) (*CostStats, error)
+	GetUsageStats(ctx context.Context, query StatsQuery) (*UsageStats, error)
+	GetCostStats(ctx context.Context, query StatsQuery) (*CostStats, error)
 
 	// Health check
 	Ping(ctx context.Context) error
@@ -30,21 +32,72 @@ type LogFilters struct {
 	Model      string
 	Limit      int
 	Offset     int
+
+	// Search filters (QueryRequestLogs)
+	StatusMin   int
+	StatusMax   int
+	Path        string // Path prefix
+	CacheHit    *bool
+	MinDuration time.Duration
+	MinCost     float64
+	Search      string // Case-insensitive text search over stored bodies
+
+	// Pagination (QueryRequestLogs)
+	Cursor string // Opaque cursor from a previous LogPage
+	Order  string // "desc" (newest first, default) or "asc"
+}
+
+// LogPage is one page of QueryRequestLogs results
+type LogPage struct {
+	Logs       []*RequestLog `json:"logs"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// StatsQuery selects the rollup series used for analytics.
+// At most one of UserID, APIKey, Model or TeamID may be set, since each is a
+// separate series; when none is set the global series is used.
+type StatsQuery struct {
+	UserID string
+	APIKey string
+	Model  string
+	TeamID string
+	From   time.Time
+	To     time.Time
+}
+
+// Validate rejects queries that filter on more than one series
+func (q StatsQuery) Validate() error {
+	set := 0
+	for _, v := range []string{q.UserID, q.APIKey, q.Model, q.TeamID} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("filter by at most one of user_id, api_key, model or team_id")
+	}
+	return nil
 }
 
 // UsageStats aggregated usage statistics
 type UsageStats struct {
-	TotalRequests int64            `json:"total_requests"`
-	CacheHits     int64            `json:"cache_hits"`
-	CacheMisses   int64            `json:"cache_misses"`
-	ByModel       map[string]int64 `json:"by_model"`
-	ByStatusCode  map[int]int64    `json:"by_status_code"`
-	AvgDuration   time.Duration    `json:"avg_duration"`
+	TotalRequests    int64            `json:"total_requests"`
+	CacheHits        int64            `json:"cache_hits"`
+	CacheMisses      int64            `json:"cache_misses"`
+	Errors           int64            `json:"errors"`
+	TokensIn         int64            `json:"tokens_in"`
+	TokensOut        int64            `json:"tokens_out"`
+	ByModel          map[string]int64 `json:"by_model"`
+	ByStatusCode     map[int]int64    `json:"by_status_code"`
+	AvgDuration      time.Duration    `json:"avg_duration"`
+	LatencyHistogram map[string]int64 `json:"latency_histogram_ms"`
 }
 
 // CostStats aggregated cost statistics
 type CostStats struct {
-	TotalCost   float64            `json:"total_cost"`
-	TotalTokens int64              `json:"total_tokens"`
-	ByModel     map[string]float64 `json:"by_model"`
+	TotalCost      float64            `json:"total_cost"`
+	TotalTokens    int64              `json:"total_tokens"`
+	ByModel        map[string]float64 `json:"by_model"`
+	Savings        float64            `json:"savings"` // Cost avoided by cache hits
+	SavingsByModel map[string]float64 `json:"savings_by_model"`
 }
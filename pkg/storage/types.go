@@ -5,6 +5,7 @@ import "time"
 // RequestLog captures request/response details for persistence layers.
 type RequestLog struct {
 	ID           string                 `json:"id"`
+	RequestID    string                 `json:"request_id,omitempty"` // Sent to the client in X-Relay-Request-Id
 	Timestamp    time.Time              `json:"timestamp"`
 	Method       string                 `json:"method"`
 	Path         string                 `json:"path"`
@@ -12,13 +13,27 @@ type RequestLog struct {
 	RemoteAddr   string                 `json:"remote_addr"`
 	APIKey       string                 `json:"api_key,omitempty"`
 	UserID       string                 `json:"user_id,omitempty"`
+	TeamID       string                 `json:"team_id,omitempty"`
 	RequestBody  map[string]interface{} `json:"request_body,omitempty"`
 	ResponseBody map[string]interface{} `json:"response_body,omitempty"`
-	StatusCode   int                    `json:"status_code"`
-	Duration     time.Duration          `json:"duration"`
-	TokensUsed   int                    `json:"tokens_used,omitempty"`
-	Model        string                 `json:"model,omitempty"`
-	CostUSD      float64                `json:"cost_usd,omitempty"`
-	CacheHit     bool                   `json:"cache_hit"`
-	Error        string                 `json:"error,omitempty"`
+
+	// Body capture details, depending on the logging policy
+	BodyCapture      string `json:"body_capture,omitempty"`
+	RequestBodyRaw   string `json:"request_body_raw,omitempty"`
+	ResponseBodyRaw  string `json:"response_body_raw,omitempty"`
+	RequestBodyHash  string `json:"request_body_sha256,omitempty"`
+	ResponseBodyHash string `json:"response_body_sha256,omitempty"`
+	BodyTruncated    bool   `json:"body_truncated,omitempty"`
+
+	StatusCode int           `json:"status_code"`
+	Duration   time.Duration `json:"duration"`
+	TokensUsed int           `json:"tokens_used,omitempty"`
+	TokensIn   int           `json:"tokens_in,omitempty"`
+	TokensOut  int           `json:"tokens_out,omitempty"`
+	Model      string        `json:"model,omitempty"`
+	ModelAlias string        `json:"model_alias,omitempty"` // Virtual model the client asked for
+	CostUSD    float64       `json:"cost_usd,omitempty"`
+	CostSaved  float64       `json:"cost_saved_usd,omitempty"` // Original cost of a response served from cache
+	CacheHit   bool          `json:"cache_hit"`
+	Error      string        `json:"error,omitempty"`
 }
@@ -0,0 +1,228 @@
+package upstreams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ngoyal88/relay/pkg/cache"
+	"github.com/ngoyal88/relay/pkg/proxy"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	overridesKey   = "upstreams:overrides" // Hash: pool name -> Overrides JSON
+	changedChannel = "upstreams:changed"   // Announces updates to the other instances
+
+	// syncInterval reloads the overrides in case an announcement was missed
+	syncInterval = 30 * time.Second
+)
+
+// Overrides are runtime changes to one pool's configured targets, made through
+// the admin API when a provider degrades and a config deploy is too slow.
+// URLs are matched after proxy.TargetURL normalizes them.
+type Overrides struct {
+	Added   []proxy.TargetConfig         `json:"added,omitempty"`   // Targets not in the config file
+	Removed []string                     `json:"removed,omitempty"` // URLs of configured or discovered targets taken out
+	Weights map[string]int               `json:"weights,omitempty"` // By URL
+	States  map[string]proxy.TargetState `json:"states,omitempty"`  // By URL: draining or disabled
+}
+
+// Apply returns the configured targets with the overrides applied
+func (o Overrides) Apply(configs []proxy.TargetConfig) []proxy.TargetConfig {
+	removed := make(map[string]bool, len(o.Removed))
+	for _, u := range o.Removed {
+		removed[proxy.TargetURL(u)] = true
+	}
+	weights := make(map[string]int, len(o.Weights))
+	for u, w := range o.Weights {
+		weights[proxy.TargetURL(u)] = w
+	}
+	states := make(map[string]proxy.TargetState, len(o.States))
+	for u, state := range o.States {
+		states[proxy.TargetURL(u)] = state
+	}
+
+	added := make([]proxy.TargetConfig, len(o.Added))
+	for i, cfg := range o.Added {
+		cfg.Source = "admin"
+		added[i] = cfg
+	}
+
+	out := make([]proxy.TargetConfig, 0, len(configs)+len(o.Added))
+	for _, list := range [][]proxy.TargetConfig{configs, added} {
+		for _, cfg := range list {
+			u := proxy.TargetURL(cfg.URL)
+			if removed[u] {
+				continue
+			}
+			if w, ok := weights[u]; ok {
+				cfg.Weight = w
+			}
+			cfg.State = states[u]
+			out = append(out, cfg)
+		}
+	}
+	return out
+}
+
+// IsAdded reports whether url is a target added at runtime
+func (o Overrides) IsAdded(url string) bool {
+	url = proxy.TargetURL(url)
+	for _, t := range o.Added {
+		if proxy.TargetURL(t.URL) == url {
+			return true
+		}
+	}
+	return false
+}
+
+func (o Overrides) empty() bool {
+	return len(o.Added) == 0 && len(o.Removed) == 0 && len(o.Weights) == 0 && len(o.States) == 0
+}
+
+// Manager keeps the overrides of every pool in Redis so all instances share them
+type Manager struct {
+	rdb       *cache.Client
+	mu        sync.RWMutex
+	pools     map[string]Overrides
+	listeners []func()
+}
+
+// New creates a new upstream override manager
+func New(rdb *cache.Client) *Manager {
+	return &Manager{rdb: rdb, pools: make(map[string]Overrides)}
+}
+
+// Start loads the overrides and keeps them in sync with the other instances:
+// updates are announced over pub/sub, with a periodic reload as a fallback
+func (m *Manager) Start(ctx context.Context) error {
+	if err := m.load(ctx); err != nil {
+		return err
+	}
+	go m.watch(ctx)
+	return nil
+}
+
+// OnChange registers fn to be called after the overrides change
+func (m *Manager) OnChange(fn func()) {
+	m.mu.Lock()
+	m.listeners = append(m.listeners, fn)
+	m.mu.Unlock()
+}
+
+// Get returns a pool's overrides; the result must not be modified
+func (m *Manager) Get(pool string) Overrides {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pools[pool]
+}
+
+// Update changes a pool's overrides with fn, which may run more than once.
+// The change is applied here right away and announced to the other instances.
+func (m *Manager) Update(ctx context.Context, pool string, fn func(*Overrides) error) error {
+	rdb := m.rdb.Redis()
+	txf := func(tx *redis.Tx) error {
+		var o Overrides
+		data, err := tx.HGet(ctx, overridesKey, pool).Bytes()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &o); err != nil {
+				return fmt.Errorf("corrupt overrides for pool %s: %w", pool, err)
+			}
+		}
+		if err := fn(&o); err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			if o.empty() {
+				p.HDel(ctx, overridesKey, pool)
+				return nil
+			}
+			data, err := json.Marshal(o)
+			if err != nil {
+				return err
+			}
+			p.HSet(ctx, overridesKey, pool, data)
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		err := rdb.Watch(ctx, txf, overridesKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue // Another instance changed the overrides first
+		}
+		if err != nil {
+			return err
+		}
+		if err := rdb.Publish(ctx, changedChannel, pool).Err(); err != nil {
+			log.Printf("⚠️ [UPSTREAMS] Failed to announce change: %v", err)
+		}
+		return m.load(ctx)
+	}
+	return fmt.Errorf("overrides of pool %s changed concurrently, try again", pool)
+}
+
+// watch reloads the overrides when another instance announces a change
+func (m *Manager) watch(ctx context.Context) {
+	sub := m.rdb.Redis().Subscribe(ctx, changedChannel)
+	defer sub.Close()
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	changes := sub.Channel()
+	for {
+		select {
+		case <-changes:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := m.load(ctx); err != nil {
+			log.Printf("⚠️ [UPSTREAMS] Failed to load overrides: %v", err)
+		}
+	}
+}
+
+// load reads every pool's overrides and notifies the listeners if they changed
+func (m *Manager) load(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	data, err := m.rdb.Redis().HGetAll(ctx, overridesKey).Result()
+	if err != nil {
+		return err
+	}
+	pools := make(map[string]Overrides, len(data))
+	for pool, raw := range data {
+		var o Overrides
+		if err := json.Unmarshal([]byte(raw), &o); err != nil {
+			log.Printf("⚠️ [UPSTREAMS] Ignoring corrupt overrides for pool %s: %v", pool, err)
+			continue
+		}
+		pools[pool] = o
+	}
+
+	m.mu.Lock()
+	changed := !reflect.DeepEqual(m.pools, pools)
+	m.pools = pools
+	listeners := m.listeners
+	m.mu.Unlock()
+
+	if changed {
+		for _, fn := range listeners {
+			fn()
+		}
+	}
+	return nil
+}